@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"ongrid-thrift/ongrid2"
+	"strconv"
+	"time"
+
+	"github.com/centrifugal/gocent"
+)
+
+// ErrCentrifugoNotConfigured is returned when ckey is not set
+var ErrCentrifugoNotConfigured = errors.New("Centrifugo is not configured")
+
+// privateChannelPrefix marks Centrifugo channels that need a subscription sign
+const privateChannelPrefix = "$"
+
+// Publish retries: the delay doubles after every failed attempt
+const (
+	publishAttempts   = 5
+	publishBackoff    = 200 * time.Millisecond
+	publishMaxBackoff = 5 * time.Second
+	publishTimeout    = 5 * time.Second
+)
+
+// ownerChannel is the private channel with messages of the service company
+// the desktop user works for
+func ownerChannel(ownerID string) string {
+	return privateChannelPrefix + "messages:" + ownerID
+}
+
+// customerChannel is the private channel with messages to one customer
+func customerChannel(customerID string) string {
+	return privateChannelPrefix + "customer:" + customerID
+}
+
+// userChannels returns the private channels the user may subscribe to
+func userChannels(user *User) []string {
+	return []string{ownerChannel(user.ID)}
+}
+
+// centrifugoConf returns the public Centrifugo endpoint and connection
+// parameters of the user signed with the secret. The secret is not sent.
+func centrifugoConf(conf CentrifugoConfig, user *User, now time.Time) (*ongrid2.CentrifugoConf, error) {
+	if conf.secret == "" {
+		return nil, ErrCentrifugoNotConfigured
+	}
+
+	port, _ := strconv.ParseInt(conf.publicPort, 10, 64)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	info := ""
+
+	return &ongrid2.CentrifugoConf{
+		Host:      conf.publicHost,
+		Port:      port,
+		User:      user.ID,
+		Timestamp: timestamp,
+		Info:      info,
+		Token:     gocent.GenerateClientToken(conf.secret, user.ID, timestamp, info),
+		ExpiresAt: now.Add(conf.tokenTTL).Unix(),
+		Channels:  userChannels(user),
+	}, nil
+}
+
+// signChannels signs subscriptions of the Centrifugo client to private
+// channels, every channel must be one of userChannels
+func signChannels(conf CentrifugoConfig, user *User, client string, channels []string) ([]*ongrid2.ChannelSign, error) {
+	if conf.secret == "" {
+		return nil, ErrCentrifugoNotConfigured
+	}
+
+	allowed := make(map[string]bool)
+	for _, channel := range userChannels(user) {
+		allowed[channel] = true
+	}
+
+	signs := make([]*ongrid2.ChannelSign, 0, len(channels))
+	for _, channel := range channels {
+		if !allowed[channel] {
+			return nil, userError(ongrid2.ErrorCode_PERMISSION_DENIED, fmt.Sprintf("Channel %s is not allowed", channel))
+		}
+		signs = append(signs, &ongrid2.ChannelSign{
+			Channel: channel,
+			Sign:    gocent.GenerateChannelSign(conf.secret, client, channel, ""),
+		})
+	}
+	return signs, nil
+}
+
+// messagePayload is the data of a customer message published to Centrifugo
+type messagePayload struct {
+	ID          int64               `json:"id"`
+	Owner       string              `json:"owner"`
+	Customer    string              `json:"customer"`
+	ParentID    int64               `json:"parentId,omitempty"`
+	Direction   int32               `json:"direction"`
+	Body        string              `json:"body"`
+	CreatedAt   int64               `json:"createdAt"`
+	Attachments []attachmentPayload `json:"attachments,omitempty"`
+}
+
+type attachmentPayload struct {
+	OriginalFilename string `json:"originalFilename"`
+	Filename         string `json:"filename"`
+}
+
+func newMessagePayload(ownerID string, messageID int64, msg CustomerMessage, createdAt time.Time) messagePayload {
+	payload := messagePayload{
+		ID:        messageID,
+		Owner:     ownerID,
+		Customer:  msg.customerID,
+		ParentID:  msg.parentMessageID,
+		Direction: 1,
+		Body:      msg.body,
+		CreatedAt: createdAt.Unix(),
+	}
+	for _, attach := range msg.attachments {
+		payload.Attachments = append(payload.Attachments, attachmentPayload{
+			OriginalFilename: attach.OriginalFilename,
+			Filename:         attach.Filename,
+		})
+	}
+	return payload
+}
+
+// CentrifugoPublisher publishes through the Centrifugo HTTP API. Failed
+// publishes are retried with backoff.
+type CentrifugoPublisher struct {
+	conf CentrifugoConfig
+}
+
+// NewCentrifugoPublisher ...
+func NewCentrifugoPublisher(conf CentrifugoConfig) *CentrifugoPublisher {
+	return &CentrifugoPublisher{conf: conf}
+}
+
+// Publish ...
+func (p *CentrifugoPublisher) Publish(channels []string, data []byte) error {
+	if p.conf.secret == "" {
+		return ErrCentrifugoNotConfigured
+	}
+
+	return retryWithBackoff(publishAttempts, publishBackoff, publishMaxBackoff, func() error {
+		c := gocent.NewClient("http://"+p.conf.host+":"+p.conf.port, p.conf.secret, publishTimeout)
+		ok, err := c.Broadcast(channels, data)
+		if err == nil && !ok {
+			err = errors.New("Centrifugo broadcast not accepted")
+		}
+		return err
+	})
+}
+
+// retryWithBackoff calls fn until it succeeds or attempts are exhausted, the
+// delay between attempts doubles up to maxDelay
+func retryWithBackoff(attempts int, delay, maxDelay time.Duration, fn func() error) (err error) {
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			if delay *= 2; delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
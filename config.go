@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kylelemons/go-gypsy/yaml"
+)
+
+// defaultConfigFile is read when neither -config nor ONGRID_CONFIG is set
+const defaultConfigFile = "config/ongrid.conf"
+
+// envPrefix is the prefix of environment variables that override config keys
+const envPrefix = "ONGRID_"
+
+// CentrifugoConfig contains the Centrifugo server address and secret. Clients
+// connect to the public address, the server publishes to host:port.
+type CentrifugoConfig struct {
+	host       string
+	port       string
+	secret     string
+	publicHost string
+	publicPort string
+	tokenTTL   time.Duration
+}
+
+// DBConfig contains configuration for Firebird db
+type DBConfig struct {
+	user     string
+	password string
+	host     string
+	port     string
+	path     string
+}
+
+// SMTPConfig contains the mail account customer registration letters are sent from
+type SMTPConfig struct {
+	host     string
+	port     int
+	user     string
+	password string
+	from     string
+}
+
+// Config is the server configuration. Keys are read from the config file,
+// then from ONGRID_<KEY> environment variables and then from command line
+// flags, a later source overrides an earlier one.
+type Config struct {
+	File string
+
+	Addr          string
+	Protocol      string
+	Framed        bool
+	Buffered      bool
+	Secure        bool
+	TLSCert       string
+	TLSKey        string
+	HTTPAddr      string
+	MaxConns      int
+	ClientTimeout time.Duration
+
+	DB         DBConfig
+	Mongo      MongoConfig
+	Centrifugo CentrifugoConfig
+	SMTP       SMTPConfig
+
+	// CustomersURL is the customer site link sent in registration letters
+	CustomersURL string
+
+	SessionStore  string
+	SessionTTL    time.Duration
+	SessionIdle   time.Duration
+	TxTimeout     time.Duration
+	MaxCursors    int
+	CursorTimeout time.Duration
+	QueryTimeout  time.Duration
+	StmtCache     int
+	SQLRowLimit   int
+
+	// Timezone is the zone of company working hours and days, location is
+	// loaded from it by LoadConfig
+	Timezone string
+	location *time.Location
+}
+
+// configKey describes one config key. value returns a pointer to the Config
+// field: *string, *int, *bool or *time.Duration.
+type configKey struct {
+	name     string
+	flag     string // flag name if it differs from the key name
+	usage    string
+	required bool
+	value    func(c *Config) interface{}
+}
+
+var configKeys = []configKey{
+	{name: "addr", usage: "Address to listen to", value: func(c *Config) interface{} { return &c.Addr }},
+	{name: "protocol", flag: "P", usage: "Specify the protocol (binary, compact, json, simplejson)", value: func(c *Config) interface{} { return &c.Protocol }},
+	{name: "framed", usage: "Use framed transport", value: func(c *Config) interface{} { return &c.Framed }},
+	{name: "buffered", usage: "Use buffered transport", value: func(c *Config) interface{} { return &c.Buffered }},
+	{name: "secure", usage: "Use tls secure transport", value: func(c *Config) interface{} { return &c.Secure }},
+	{name: "tlscert", usage: "TLS certificate file", value: func(c *Config) interface{} { return &c.TLSCert }},
+	{name: "tlskey", usage: "TLS key file", value: func(c *Config) interface{} { return &c.TLSKey }},
+	{name: "httpaddr", usage: "Address of the attachments http server", value: func(c *Config) interface{} { return &c.HTTPAddr }},
+	{name: "maxconns", usage: "Max concurrent client connections, 0 - no limit", value: func(c *Config) interface{} { return &c.MaxConns }},
+	{name: "clienttimeout", usage: "Client socket read and write timeout, 0 - no timeout", value: func(c *Config) interface{} { return &c.ClientTimeout }},
+
+	{name: "dbuser", usage: "System database user", required: true, value: func(c *Config) interface{} { return &c.DB.user }},
+	{name: "dbpass", usage: "System database password", required: true, value: func(c *Config) interface{} { return &c.DB.password }},
+	{name: "dbhost", usage: "System database host", required: true, value: func(c *Config) interface{} { return &c.DB.host }},
+	{name: "dbport", usage: "System database port", value: func(c *Config) interface{} { return &c.DB.port }},
+	{name: "dbpath", usage: "System database path", required: true, value: func(c *Config) interface{} { return &c.DB.path }},
+
+	{name: "mgouser", usage: "MongoDB user", value: func(c *Config) interface{} { return &c.Mongo.user }},
+	{name: "mgopass", usage: "MongoDB password", value: func(c *Config) interface{} { return &c.Mongo.passowrd }},
+	{name: "mgohost", usage: "MongoDB host", required: true, value: func(c *Config) interface{} { return &c.Mongo.host }},
+	{name: "mgodb", usage: "MongoDB database", required: true, value: func(c *Config) interface{} { return &c.Mongo.dbName }},
+
+	{name: "chost", usage: "Centrifugo host", value: func(c *Config) interface{} { return &c.Centrifugo.host }},
+	{name: "cport", usage: "Centrifugo port", value: func(c *Config) interface{} { return &c.Centrifugo.port }},
+	{name: "ckey", usage: "Centrifugo secret", value: func(c *Config) interface{} { return &c.Centrifugo.secret }},
+	{name: "cpublichost", usage: "Centrifugo host for clients, chost by default", value: func(c *Config) interface{} { return &c.Centrifugo.publicHost }},
+	{name: "cpublicport", usage: "Centrifugo port for clients, cport by default", value: func(c *Config) interface{} { return &c.Centrifugo.publicPort }},
+	{name: "ctokenttl", usage: "Centrifugo connection token lifetime", value: func(c *Config) interface{} { return &c.Centrifugo.tokenTTL }},
+
+	{name: "smtphost", usage: "SMTP server of registration letters", value: func(c *Config) interface{} { return &c.SMTP.host }},
+	{name: "smtpport", usage: "SMTP server port", value: func(c *Config) interface{} { return &c.SMTP.port }},
+	{name: "smtpuser", usage: "SMTP user", value: func(c *Config) interface{} { return &c.SMTP.user }},
+	{name: "smtppass", usage: "SMTP password", value: func(c *Config) interface{} { return &c.SMTP.password }},
+	{name: "smtpfrom", usage: "From address of registration letters, smtpuser by default", value: func(c *Config) interface{} { return &c.SMTP.from }},
+	{name: "customersurl", usage: "Customer site link in registration letters", value: func(c *Config) interface{} { return &c.CustomersURL }},
+
+	{name: "sessionstore", usage: "Session store: memory or mongo", value: func(c *Config) interface{} { return &c.SessionStore }},
+	{name: "sessionttl", usage: "Session lifetime, 0 - unlimited", value: func(c *Config) interface{} { return &c.SessionTTL }},
+	{name: "sessionidle", usage: "Session idle timeout, 0 - unlimited", value: func(c *Config) interface{} { return &c.SessionIdle }},
+	{name: "txtimeout", usage: "Idle transaction rollback timeout, 0 - unlimited", value: func(c *Config) interface{} { return &c.TxTimeout }},
+	{name: "maxcursors", usage: "Max open cursors of a session, 0 - unlimited", value: func(c *Config) interface{} { return &c.MaxCursors }},
+	{name: "cursortimeout", usage: "Idle cursor close timeout, 0 - unlimited", value: func(c *Config) interface{} { return &c.CursorTimeout }},
+	{name: "querytimeout", usage: "Database call timeout, 0 - unlimited", value: func(c *Config) interface{} { return &c.QueryTimeout }},
+	{name: "stmtcache", usage: "Prepared statement cache size of a session, 0 - disabled", value: func(c *Config) interface{} { return &c.StmtCache }},
+	{name: "sqlrowlimit", usage: "Max rows of ExecuteSelectQuery, 0 - unlimited", value: func(c *Config) interface{} { return &c.SQLRowLimit }},
+	{name: "timezone", usage: "Time zone of company working hours, for example Europe/Moscow, Local - the server zone", value: func(c *Config) interface{} { return &c.Timezone }},
+}
+
+// DefaultConfig returns the config with default values of optional keys.
+// clienttimeout, sessionttl and sessionidle are disabled by default, so
+// connections and sessions live until the client disconnects. maxconns is
+// unlimited as well: with a limit and no timeouts dead clients would hold the
+// slots until the server restarts.
+func DefaultConfig() *Config {
+	return &Config{
+		File:          defaultConfigFile,
+		Addr:          "0.0.0.0:9090",
+		Protocol:      "binary",
+		TLSCert:       "keys/server.crt",
+		TLSKey:        "keys/server.key",
+		HTTPAddr:      ":3000",
+		Centrifugo:    CentrifugoConfig{tokenTTL: 24 * time.Hour},
+		SMTP:          SMTPConfig{port: 465},
+		CustomersURL:  "http://customers.ongrid.xyz",
+		SessionStore:  "memory",
+		TxTimeout:     5 * time.Minute,
+		MaxCursors:    16,
+		CursorTimeout: 10 * time.Minute,
+		QueryTimeout:  time.Minute,
+		StmtCache:     64,
+		Timezone:      "Local",
+	}
+}
+
+// LoadConfig loads the config from the file, environment and command line
+// arguments and validates it
+func LoadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	// flags are registered with the defaults for -help, their values are
+	// applied only if set
+	flags := DefaultConfig()
+	fs.StringVar(&flags.File, "config", defaultConfigFile, "Config file, ONGRID_CONFIG")
+	for _, key := range configKeys {
+		name := key.flagName()
+		usage := fmt.Sprintf("%s (%s, %s)", key.usage, key.name, key.envName())
+		switch p := key.value(flags).(type) {
+		case *string:
+			fs.StringVar(p, name, *p, usage)
+		case *int:
+			fs.IntVar(p, name, *p, usage)
+		case *bool:
+			fs.BoolVar(p, name, *p, usage)
+		case *time.Duration:
+			fs.DurationVar(p, name, *p, usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// a missing default file is allowed, keys may come from the environment
+	explicit := true
+	switch {
+	case set["config"]:
+		cfg.File = flags.File
+	case os.Getenv(envPrefix+"CONFIG") != "":
+		cfg.File = os.Getenv(envPrefix + "CONFIG")
+	default:
+		explicit = false
+	}
+
+	file, err := yaml.ReadFile(cfg.File)
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		return nil, fmt.Errorf("config file %s: %v", cfg.File, err)
+	}
+
+	var errs []string
+	for _, key := range configKeys {
+		var value string
+		var ok bool
+		if file != nil {
+			if v, err := file.Get(key.name); err == nil {
+				value, ok = v, true
+			}
+		}
+		if v, found := os.LookupEnv(key.envName()); found {
+			value, ok = v, true
+		}
+		if ok {
+			if err := setConfigValue(key.value(cfg), value); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", key.name, err))
+			}
+		}
+		if set[key.flagName()] {
+			copyConfigValue(key.value(cfg), key.value(flags))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.New("invalid config:\n  " + strings.Join(errs, "\n  "))
+	}
+	if cfg.SMTP.from == "" {
+		cfg.SMTP.from = cfg.SMTP.user
+	}
+	if cfg.Centrifugo.publicHost == "" {
+		cfg.Centrifugo.publicHost = cfg.Centrifugo.host
+	}
+	if cfg.Centrifugo.publicPort == "" {
+		cfg.Centrifugo.publicPort = cfg.Centrifugo.port
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.location, _ = time.LoadLocation(cfg.Timezone) // checked by Validate
+	return cfg, nil
+}
+
+// Location returns the time zone of company working hours, the server zone if
+// the config was not loaded by LoadConfig
+func (c *Config) Location() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+	return c.location
+}
+
+// Validate checks that required keys are set and values are in range
+func (c *Config) Validate() error {
+	var errs []string
+	for _, key := range configKeys {
+		if !key.required {
+			continue
+		}
+		if p, ok := key.value(c).(*string); ok && *p == "" {
+			errs = append(errs, fmt.Sprintf("required key %s is not set (config file key %s, environment %s or flag -%s)",
+				key.name, key.name, key.envName(), key.flagName()))
+		}
+	}
+
+	switch c.Protocol {
+	case "binary", "compact", "json", "simplejson":
+	default:
+		errs = append(errs, fmt.Sprintf("protocol %q is not one of binary, compact, json, simplejson", c.Protocol))
+	}
+	switch c.SessionStore {
+	case "memory", "mongo":
+	default:
+		errs = append(errs, fmt.Sprintf("sessionstore %q is not one of memory, mongo", c.SessionStore))
+	}
+	for _, port := range []struct{ name, value string }{{"cport", c.Centrifugo.port}, {"cpublicport", c.Centrifugo.publicPort}} {
+		if _, err := strconv.Atoi(port.value); port.value != "" && err != nil {
+			errs = append(errs, fmt.Sprintf("%s %q is not a number", port.name, port.value))
+		}
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		errs = append(errs, fmt.Sprintf("timezone %q: %v", c.Timezone, err))
+	}
+	if c.Secure && (c.TLSCert == "" || c.TLSKey == "") {
+		errs = append(errs, "secure transport needs tlscert and tlskey")
+	}
+	for _, key := range configKeys {
+		switch p := key.value(c).(type) {
+		case *int:
+			if *p < 0 {
+				errs = append(errs, fmt.Sprintf("%s must not be negative", key.name))
+			}
+		case *time.Duration:
+			if *p < 0 {
+				errs = append(errs, fmt.Sprintf("%s must not be negative", key.name))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid config:\n  " + strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func (k configKey) flagName() string {
+	if k.flag != "" {
+		return k.flag
+	}
+	return k.name
+}
+
+func (k configKey) envName() string {
+	return envPrefix + strings.ToUpper(k.name)
+}
+
+// setConfigValue parses the string value into the Config field
+func setConfigValue(p interface{}, value string) error {
+	value = strings.TrimSpace(value)
+	switch p := p.(type) {
+	case *string:
+		*p = value
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*p = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 90s or 5m", value)
+		}
+		*p = v
+	}
+	return nil
+}
+
+func copyConfigValue(dst, src interface{}) {
+	switch dst := dst.(type) {
+	case *string:
+		*dst = *src.(*string)
+	case *int:
+		*dst = *src.(*int)
+	case *bool:
+		*dst = *src.(*bool)
+	case *time.Duration:
+		*dst = *src.(*time.Duration)
+	}
+}
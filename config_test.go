@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requiredKeys sets the required keys of the config file
+const requiredKeys = "dbuser: sysdba\ndbpass: masterkey\ndbhost: localhost\ndbpath: /data/system.fdb\nmgohost: localhost\nmgodb: ongrid\n"
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "ongrid.conf")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func loadConfig(args ...string) (*Config, error) {
+	return LoadConfig(flag.NewFlagSet("ongrid", flag.ContinueOnError), args)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := writeConfig(t, requiredKeys+"maxconns: 10\nquerytimeout: 30s\nstmtcache: 8\nsqlrowlimit: 500\n")
+	t.Setenv("ONGRID_CONFIG", "")
+	t.Setenv("ONGRID_QUERYTIMEOUT", "45s")
+	t.Setenv("ONGRID_STMTCACHE", "16")
+
+	cfg, err := loadConfig("-config", file, "-stmtcache", "32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key       string
+		got, want interface{}
+	}{
+		{"default", cfg.Protocol, "binary"},
+		{"default", cfg.TxTimeout, 5 * time.Minute},
+		{"file", cfg.MaxConns, 10},
+		{"file", cfg.SQLRowLimit, 500},
+		{"file", cfg.DB.host, "localhost"},
+		{"env over file", cfg.QueryTimeout, 45 * time.Second},
+		{"flag over env and file", cfg.StmtCache, 32},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.key, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnvironment(t *testing.T) {
+	t.Setenv("ONGRID_CONFIG", writeConfig(t, requiredKeys+"addr: 127.0.0.1:9999\n"))
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != "127.0.0.1:9999" {
+		t.Errorf("addr %s", cfg.Addr)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	t.Setenv("ONGRID_CONFIG", "")
+
+	cfg, err := loadConfig("-config", writeConfig(t, requiredKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// disabled by default, connections and sessions live until disconnect, so
+	// the number of connections is not limited either
+	if cfg.ClientTimeout != 0 || cfg.SessionTTL != 0 || cfg.SessionIdle != 0 || cfg.MaxConns != 0 {
+		t.Errorf("clienttimeout %v, sessionttl %v, sessionidle %v, maxconns %d", cfg.ClientTimeout, cfg.SessionTTL, cfg.SessionIdle, cfg.MaxConns)
+	}
+	// working hours are in the server zone unless timezone is set
+	if cfg.Location() != time.Local {
+		t.Errorf("timezone %q, location %v", cfg.Timezone, cfg.Location())
+	}
+	// ExecuteSelectQuery returns all rows as before the limit was added
+	if cfg.SQLRowLimit != 0 {
+		t.Errorf("sqlrowlimit %d", cfg.SQLRowLimit)
+	}
+	// derived from other keys
+	if cfg.SMTP.from != cfg.SMTP.user || cfg.Centrifugo.publicHost != cfg.Centrifugo.host {
+		t.Errorf("smtpfrom %q, cpublichost %q", cfg.SMTP.from, cfg.Centrifugo.publicHost)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Setenv("ONGRID_CONFIG", "")
+	valid := writeConfig(t, requiredKeys)
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"missing file", nil, []string{"-config", filepath.Join(t.TempDir(), "missing.conf")}, "missing.conf"},
+		{"required key", nil, []string{"-config", writeConfig(t, "dbuser: sysdba\n")}, "required key dbhost"},
+		{"invalid env", map[string]string{"ONGRID_MAXCONNS": "many"}, []string{"-config", valid}, "maxconns"},
+		{"negative", map[string]string{"ONGRID_STMTCACHE": "-1"}, []string{"-config", valid}, "stmtcache must not be negative"},
+		{"protocol", nil, []string{"-config", valid, "-P", "xml"}, "protocol"},
+		{"timezone", map[string]string{"ONGRID_TIMEZONE": "Mars/Olympus"}, []string{"-config", valid}, "timezone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := loadConfig(tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
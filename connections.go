@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ConnectionManager owns the connections shared by all sessions: the system
+// Firebird database and the MongoDB session. They are opened on first use and
+// live until the process stops, so a client logging out never closes them.
+type ConnectionManager struct {
+	mu        sync.Mutex
+	dbConfig  DBConfig
+	mgoConfig MongoConfig
+	db        *sqlx.DB
+	mongo     *MongoConnection
+}
+
+// NewConnectionManager ...
+func NewConnectionManager(dbConfig DBConfig, mgoConfig MongoConfig) *ConnectionManager {
+	return &ConnectionManager{dbConfig: dbConfig, mgoConfig: mgoConfig}
+}
+
+// SystemDB returns the system firebird database pool, connecting if needed
+func (m *ConnectionManager) SystemDB() (*sqlx.DB, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.db != nil {
+		return m.db, nil
+	}
+
+	db, err := sqlx.Connect("firebirdsql", getSystemConnectionString(m.dbConfig))
+	if err != nil {
+		log.Printf("ConnectionManager: connect to system db: %v", err)
+		return nil, err
+	}
+	log.Println("ConnectionManager: System firebird database connection established")
+
+	m.db = db
+	return m.db, nil
+}
+
+// Mongo returns the shared mongo connection, dialing if needed
+func (m *ConnectionManager) Mongo() (*MongoConnection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mongo != nil {
+		return m.mongo, nil
+	}
+
+	conn := NewMongoConnection(m.mgoConfig)
+	if conn.originalSession == nil {
+		return nil, errors.New("No original session found")
+	}
+
+	m.mongo = conn
+	return m.mongo, nil
+}
+
+// Close closes the shared connections, it is called once on shutdown
+func (m *ConnectionManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.db != nil {
+		m.db.Close()
+		m.db = nil
+	}
+	if m.mongo != nil {
+		m.mongo.CloseConnection()
+		m.mongo = nil
+	}
+	log.Println("ConnectionManager: Shared connections closed")
+}
+
+func getSystemConnectionString(dbConfig DBConfig) string {
+	return dbConfig.user + ":" + dbConfig.password + "@" + dbConfig.host + ":" + dbConfig.port + "/" + dbConfig.path
+}
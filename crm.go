@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"ongrid-thrift/privileges"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Crm paging: the default and the largest page size, and the default number of
+// search results
+const (
+	defaultCrmPage   = 100
+	maxCrmPage       = 1000
+	defaultCrmSearch = 50
+)
+
+// CrmHandler serves the Crm service, clients, persons, companies and cars of
+// the system database
+type CrmHandler struct {
+	conns    *ConnectionManager
+	cfg      *Config
+	sessions SessionStore
+}
+
+// NewCrmHandler ...
+func NewCrmHandler(conns *ConnectionManager, cfg *Config, sessions SessionStore) *CrmHandler {
+	return &CrmHandler{conns: conns, cfg: cfg, sessions: sessions}
+}
+
+// GetClient возвращает клиента с физ. лицом или компанией
+func (p *CrmHandler) GetClient(authToken string, clientID int64) (_ *ongrid2.Client, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return loadClient(ctx, db, clientID)
+}
+
+// ListClients возвращает страницу клиентов по возрастанию id
+func (p *CrmHandler) ListClients(authToken string, offset int32, limit int32) (_ *ongrid2.ClientList, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	list := &ongrid2.ClientList{}
+	if list.Total, err = countRows(ctx, db, "sys$clients"); err != nil {
+		return nil, err
+	}
+	if list.Clients, err = selectClientsPage(ctx, db, crmPage(offset, limit), ""); err != nil {
+		return nil, sqlError(err)
+	}
+	return list, nil
+}
+
+// SearchClients ищет клиентов по имени, email и телефону
+func (p *CrmHandler) SearchClients(authToken string, query string, limit int32) (_ []*ongrid2.Client, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	where, args, err := crmSearch(clientSearchColumns, query)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := selectClientsPage(ctx, db, searchPage(limit), where, args...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return clients, nil
+}
+
+// CreateClient создает клиента. Физ. лицо или компания с id 0 создаются вместе
+// с ним, иначе клиент связывается с существующими.
+func (p *CrmHandler) CreateClient(authToken string, client *ongrid2.Client) (_ *ongrid2.Client, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if client == nil {
+		return nil, invalidData("Client is empty")
+	}
+	if err = validateClient(client); err != nil {
+		return nil, err
+	}
+	if client.RegistrationDate == 0 {
+		client.RegistrationDate = time.Now().Unix()
+	}
+
+	var clientID int64
+	err = p.write(ctx, db, func(tx *sqlx.Tx) (err error) {
+		clientID, err = insertClient(ctx, tx, client)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadClient(ctx, db, clientID)
+}
+
+// UpdateClient изменяет клиента client.id
+func (p *CrmHandler) UpdateClient(authToken string, client *ongrid2.Client) (_ *ongrid2.Client, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if client == nil {
+		return nil, invalidData("Client is empty")
+	}
+	if err = validateClient(client); err != nil {
+		return nil, err
+	}
+
+	err = p.write(ctx, db, func(tx *sqlx.Tx) error {
+		return updateClient(ctx, tx, client)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadClient(ctx, db, client.ID)
+}
+
+// GetPerson возвращает физ. лицо
+func (p *CrmHandler) GetPerson(authToken string, personID int32) (_ *ongrid2.Person, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return loadPerson(ctx, db, int64(personID))
+}
+
+// ListPersons возвращает страницу физ. лиц по возрастанию id
+func (p *CrmHandler) ListPersons(authToken string, offset int32, limit int32) (_ *ongrid2.PersonList, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	list := &ongrid2.PersonList{}
+	if list.Total, err = countRows(ctx, db, "sys$person"); err != nil {
+		return nil, err
+	}
+	if list.Persons, err = selectPersonsPage(ctx, db, crmPage(offset, limit), ""); err != nil {
+		return nil, sqlError(err)
+	}
+	return list, nil
+}
+
+// SearchPersons ищет физ. лица по имени, фамилии и номеру паспорта
+func (p *CrmHandler) SearchPersons(authToken string, query string, limit int32) (_ []*ongrid2.Person, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	where, args, err := crmSearch(personSearchColumns, query)
+	if err != nil {
+		return nil, err
+	}
+	persons, err := selectPersonsPage(ctx, db, searchPage(limit), where, args...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return persons, nil
+}
+
+// CreatePerson создает физ. лицо
+func (p *CrmHandler) CreatePerson(authToken string, person *ongrid2.Person) (_ *ongrid2.Person, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if person == nil {
+		return nil, invalidData("Person is empty")
+	}
+	if err = validatePerson(person); err != nil {
+		return nil, err
+	}
+
+	var personID int64
+	err = p.write(ctx, db, func(tx *sqlx.Tx) (err error) {
+		personID, err = insertPerson(ctx, tx, person)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadPerson(ctx, db, personID)
+}
+
+// UpdatePerson изменяет физ. лицо person.id
+func (p *CrmHandler) UpdatePerson(authToken string, person *ongrid2.Person) (_ *ongrid2.Person, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if person == nil {
+		return nil, invalidData("Person is empty")
+	}
+	if err = validatePerson(person); err != nil {
+		return nil, err
+	}
+
+	err = p.write(ctx, db, func(tx *sqlx.Tx) error {
+		return updatePerson(ctx, tx, person)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadPerson(ctx, db, int64(person.ID))
+}
+
+// GetCompany возвращает компанию
+func (p *CrmHandler) GetCompany(authToken string, companyID int32) (_ *ongrid2.Company, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return loadCompany(ctx, db, int64(companyID))
+}
+
+// ListCompanies возвращает страницу компаний по возрастанию id
+func (p *CrmHandler) ListCompanies(authToken string, offset int32, limit int32) (_ *ongrid2.CompanyList, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	list := &ongrid2.CompanyList{}
+	if list.Total, err = countRows(ctx, db, "sys$companies"); err != nil {
+		return nil, err
+	}
+	if list.Companies, err = selectCompaniesPage(ctx, db, crmPage(offset, limit), ""); err != nil {
+		return nil, sqlError(err)
+	}
+	return list, nil
+}
+
+// SearchCompanies ищет компании по названию, полному наименованию, ИНН и
+// телефону
+func (p *CrmHandler) SearchCompanies(authToken string, query string, limit int32) (_ []*ongrid2.Company, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	where, args, err := crmSearch(companySearchColumns, query)
+	if err != nil {
+		return nil, err
+	}
+	companies, err := selectCompaniesPage(ctx, db, searchPage(limit), where, args...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return companies, nil
+}
+
+// CreateCompany создает компанию, ИНН и КПП проверяются validateCompany
+func (p *CrmHandler) CreateCompany(authToken string, company *ongrid2.Company) (_ *ongrid2.Company, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if company == nil {
+		return nil, invalidData("Company is empty")
+	}
+	if err = validateCompany(company); err != nil {
+		return nil, err
+	}
+
+	var companyID int64
+	err = p.write(ctx, db, func(tx *sqlx.Tx) (err error) {
+		companyID, err = insertCompany(ctx, tx, company)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadCompany(ctx, db, companyID)
+}
+
+// UpdateCompany изменяет компанию company.id
+func (p *CrmHandler) UpdateCompany(authToken string, company *ongrid2.Company) (_ *ongrid2.Company, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if company == nil {
+		return nil, invalidData("Company is empty")
+	}
+	if err = validateCompany(company); err != nil {
+		return nil, err
+	}
+
+	err = p.write(ctx, db, func(tx *sqlx.Tx) error {
+		return updateCompany(ctx, tx, company)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadCompany(ctx, db, int64(company.ID))
+}
+
+// GetCar возвращает автомобиль
+func (p *CrmHandler) GetCar(authToken string, carID int64) (_ *ongrid2.Car, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return loadCar(ctx, db, carID)
+}
+
+// ListCars возвращает страницу автомобилей по возрастанию id
+func (p *CrmHandler) ListCars(authToken string, offset int32, limit int32) (_ *ongrid2.CarList, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	list := &ongrid2.CarList{}
+	if list.Total, err = countRows(ctx, db, "sys$cars"); err != nil {
+		return nil, err
+	}
+	if list.Cars, err = selectCarsPage(ctx, db, crmPage(offset, limit), ""); err != nil {
+		return nil, sqlError(err)
+	}
+	return list, nil
+}
+
+// SearchCars ищет автомобили по госномеру, VIN, марке и модели
+func (p *CrmHandler) SearchCars(authToken string, query string, limit int32) (_ []*ongrid2.Car, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	where, args, err := crmSearch(carSearchColumns, query)
+	if err != nil {
+		return nil, err
+	}
+	cars, err := selectCarsPage(ctx, db, searchPage(limit), where, args...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return cars, nil
+}
+
+// CreateCar создает автомобиль. VIN проверяется validateCar, owner - пустой
+// или id существующего клиента.
+func (p *CrmHandler) CreateCar(authToken string, car *ongrid2.Car) (_ *ongrid2.Car, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if car == nil {
+		return nil, invalidData("Car is empty")
+	}
+	if err = validateCar(car); err != nil {
+		return nil, err
+	}
+
+	var carID int64
+	err = p.write(ctx, db, func(tx *sqlx.Tx) (err error) {
+		carID, err = insertCar(ctx, tx, car)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadCar(ctx, db, carID)
+}
+
+// UpdateCar изменяет автомобиль car.id
+func (p *CrmHandler) UpdateCar(authToken string, car *ongrid2.Car) (_ *ongrid2.Car, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if car == nil {
+		return nil, invalidData("Car is empty")
+	}
+	if err = validateCar(car); err != nil {
+		return nil, err
+	}
+
+	err = p.write(ctx, db, func(tx *sqlx.Tx) error {
+		return updateCar(ctx, tx, car)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadCar(ctx, db, car.ID)
+}
+
+// SetCarOwner связывает автомобиль с владельцем - клиентом clientID,
+// clientID 0 отвязывает автомобиль
+func (p *CrmHandler) SetCarOwner(authToken string, carID int64, clientID int64) (_ *ongrid2.Car, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	err = p.write(ctx, db, func(tx *sqlx.Tx) error {
+		return setCarOwner(ctx, tx, carID, clientID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadCar(ctx, db, carID)
+}
+
+// GetOwnerCars возвращает автомобили клиента clientID
+func (p *CrmHandler) GetOwnerCars(authToken string, clientID int64) (_ []*ongrid2.Car, err error) {
+	defer mapError(&err)
+
+	db, ctx, cancel, err := p.begin(authToken, privileges.PermissionCrmRead)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if _, err = loadClient(ctx, db, clientID); err != nil {
+		return nil, err
+	}
+	cars, err := selectCarsPage(ctx, db, "", " where owner = ?", strconv.FormatInt(clientID, 10))
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return cars, nil
+}
+
+// begin checks the token and the permission of the og$users user bound by
+// CheckUser and returns the system database and the context of the call
+func (p *CrmHandler) begin(authToken string, permission string) (*sqlx.DB, context.Context, context.CancelFunc, error) {
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !session.hasPermission(permission) {
+		return nil, nil, nil, userError(ongrid2.ErrorCode_PERMISSION_DENIED, fmt.Sprintf("Permission %s is required", permission))
+	}
+	db, err := p.conns.SystemDB()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, cancel := callContext(p.cfg.QueryTimeout)
+	return db, ctx, cancel, nil
+}
+
+// write runs fn in one transaction of the system database
+func (p *CrmHandler) write(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return sqlError(err)
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return sqlError(err)
+	}
+	return nil
+}
+
+// countRows returns the number of rows of the table
+func countRows(ctx context.Context, db *sqlx.DB, table string) (int32, error) {
+	var n int32
+	if err := db.GetContext(ctx, &n, "select count(*) from "+table); err != nil {
+		log.Printf("countRows, count %s error: %v", table, err)
+		return 0, sqlError(err)
+	}
+	return n, nil
+}
+
+// crmPage returns "first N skip M " of the page, limit defaultCrmPage by
+// default and maxCrmPage at most
+func crmPage(offset, limit int32) string {
+	if limit <= 0 {
+		limit = defaultCrmPage
+	}
+	if limit > maxCrmPage {
+		limit = maxCrmPage
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return fmt.Sprintf("first %d skip %d ", limit, offset)
+}
+
+// searchPage returns "first N " of the search results, limit
+// defaultCrmSearch by default and maxCrmPage at most
+func searchPage(limit int32) string {
+	if limit <= 0 {
+		limit = defaultCrmSearch
+	}
+	if limit > maxCrmPage {
+		limit = maxCrmPage
+	}
+	return fmt.Sprintf("first %d ", limit)
+}
+
+// crmSearch returns the where clause of the search, the query is required
+func crmSearch(columns []string, query string) (string, []interface{}, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", nil, invalidData("Search query is empty")
+	}
+	where, args := searchWhere(columns, query)
+	return where, args, nil
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Columns searched by the Crm search methods with "containing", which is case
+// insensitive in firebird
+var (
+	clientSearchColumns  = []string{"name", "email", "phone"}
+	personSearchColumns  = []string{"firstname", "lastname", "passportnumber"}
+	companySearchColumns = []string{"servicename", "fullname", "inn", "phone"}
+	carSearchColumns     = []string{"number", "vin", "brand", "model"}
+)
+
+// searchWhere returns the where clause matching query in any of the columns
+func searchWhere(columns []string, query string) (string, []interface{}) {
+	conds := make([]string, 0, len(columns))
+	args := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		conds = append(conds, column+" containing ?")
+		args = append(args, query)
+	}
+	return " where " + strings.Join(conds, " or "), args
+}
+
+// nullInt returns the number, 0 is NULL
+func nullInt(n int64) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// nextID returns the next value of the generator
+func nextID(ctx context.Context, tx *sqlx.Tx, generator string) (int64, error) {
+	var id int64
+	if err := tx.GetContext(ctx, &id, "select gen_id("+generator+", 1) from rdb$database"); err != nil {
+		log.Printf("nextID, select gen_id(%s) error: %v", generator, err)
+		return 0, sqlError(err)
+	}
+	return id, nil
+}
+
+// rowUpdated returns NotFoundException when no row was updated
+func rowUpdated(res sql.Result, what string, id int64) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return sqlError(err)
+	}
+	if n == 0 {
+		return notFoundError(fmt.Sprintf("%s %d not found", what, id))
+	}
+	return nil
+}
+
+// loadClient returns the client or NotFoundException
+func loadClient(ctx context.Context, q sqlx.QueryerContext, clientID int64) (*ongrid2.Client, error) {
+	clients, err := loadClients(ctx, q, []int64{clientID})
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if clients[clientID] == nil {
+		return nil, notFoundError(fmt.Sprintf("Client %d not found", clientID))
+	}
+	return clients[clientID], nil
+}
+
+// loadPerson returns the person or NotFoundException
+func loadPerson(ctx context.Context, q sqlx.QueryerContext, personID int64) (*ongrid2.Person, error) {
+	persons, err := loadPersons(ctx, q, []int64{personID})
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if persons[personID] == nil {
+		return nil, notFoundError(fmt.Sprintf("Person %d not found", personID))
+	}
+	return persons[personID], nil
+}
+
+// loadCompany returns the company or NotFoundException
+func loadCompany(ctx context.Context, q sqlx.QueryerContext, companyID int64) (*ongrid2.Company, error) {
+	companies, err := loadCompanies(ctx, q, []int64{companyID})
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if companies[companyID] == nil {
+		return nil, notFoundError(fmt.Sprintf("Company %d not found", companyID))
+	}
+	return companies[companyID], nil
+}
+
+// loadCar returns the car or NotFoundException
+func loadCar(ctx context.Context, q sqlx.QueryerContext, carID int64) (*ongrid2.Car, error) {
+	cars, err := loadCars(ctx, q, []int64{carID})
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if cars[carID] == nil {
+		return nil, notFoundError(fmt.Sprintf("Car %d not found", carID))
+	}
+	return cars[carID], nil
+}
+
+// selectClientsPage and the other select*Page functions return the rows of the
+// where clause ordered by id, page is "first N skip M " or ""
+func selectClientsPage(ctx context.Context, db *sqlx.DB, page string, where string, args ...interface{}) ([]*ongrid2.Client, error) {
+	var dbClients []DBClient
+	query := "select " + page + clientColumns + " from sys$clients" + where + " order by id"
+	if err := db.SelectContext(ctx, &dbClients, query, args...); err != nil {
+		log.Printf("selectClientsPage, select from sys$clients error: %v", err)
+		return nil, err
+	}
+	return clientsFromDB(ctx, db, dbClients)
+}
+
+func selectPersonsPage(ctx context.Context, db *sqlx.DB, page string, where string, args ...interface{}) ([]*ongrid2.Person, error) {
+	var dbPersons []DBPerson
+	if err := db.SelectContext(ctx, &dbPersons, "select "+page+"* from sys$person"+where+" order by id", args...); err != nil {
+		log.Printf("selectPersonsPage, select from sys$person error: %v", err)
+		return nil, err
+	}
+	persons := make([]*ongrid2.Person, 0, len(dbPersons))
+	for _, dbPerson := range dbPersons {
+		persons = append(persons, personFromDB(dbPerson))
+	}
+	return persons, nil
+}
+
+func selectCompaniesPage(ctx context.Context, db *sqlx.DB, page string, where string, args ...interface{}) ([]*ongrid2.Company, error) {
+	var dbCompanies []DBCompany
+	if err := db.SelectContext(ctx, &dbCompanies, "select "+page+"* from sys$companies"+where+" order by id", args...); err != nil {
+		log.Printf("selectCompaniesPage, select from sys$companies error: %v", err)
+		return nil, err
+	}
+	companies := make([]*ongrid2.Company, 0, len(dbCompanies))
+	for _, dbCompany := range dbCompanies {
+		companies = append(companies, companyFromDB(dbCompany))
+	}
+	return companies, nil
+}
+
+func selectCarsPage(ctx context.Context, db *sqlx.DB, page string, where string, args ...interface{}) ([]*ongrid2.Car, error) {
+	var dbCars []DBCar
+	if err := db.SelectContext(ctx, &dbCars, "select "+page+"* from sys$cars"+where+" order by id", args...); err != nil {
+		log.Printf("selectCarsPage, select from sys$cars error: %v", err)
+		return nil, err
+	}
+	cars := make([]*ongrid2.Car, 0, len(dbCars))
+	for _, dbCar := range dbCars {
+		cars = append(cars, carFromDB(dbCar))
+	}
+	return cars, nil
+}
+
+func personParams(person *ongrid2.Person) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       person.ID,
+		"first":    nullString(person.FirstName),
+		"last":     nullString(person.LastName),
+		"passnum":  nullString(person.PassportNumber),
+		"passser":  nullString(person.PassportSeries),
+		"passdate": nullString(person.PassportDate),
+		"birthday": unixTime(person.BirthDay),
+		"gender":   nullInt(int64(person.Gender)),
+	}
+}
+
+// insertPerson inserts the person with a new id and returns the id
+func insertPerson(ctx context.Context, tx *sqlx.Tx, person *ongrid2.Person) (int64, error) {
+	id, err := nextID(ctx, tx, "gen_sys$person_id")
+	if err != nil {
+		return 0, err
+	}
+	person.ID = int32(id)
+
+	_, err = tx.NamedExecContext(ctx, "insert into sys$person (id, firstname, lastname, passportnumber, passportseries, passportdate, birthday, gender) "+
+		"values (:id, :first, :last, :passnum, :passser, :passdate, :birthday, :gender)", personParams(person))
+	if err != nil {
+		log.Printf("insertPerson, insert into sys$person error: %v", err)
+		return 0, sqlError(err)
+	}
+	return id, nil
+}
+
+func updatePerson(ctx context.Context, tx *sqlx.Tx, person *ongrid2.Person) error {
+	res, err := tx.NamedExecContext(ctx, "update sys$person set firstname = :first, lastname = :last, passportnumber = :passnum, "+
+		"passportseries = :passser, passportdate = :passdate, birthday = :birthday, gender = :gender where id = :id", personParams(person))
+	if err != nil {
+		log.Printf("updatePerson, update sys$person error: %v", err)
+		return sqlError(err)
+	}
+	return rowUpdated(res, "Person", int64(person.ID))
+}
+
+func companyParams(company *ongrid2.Company) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          company.ID,
+		"name":        company.Servicename,
+		"phone":       nullString(company.Phone),
+		"legalform":   nullString(company.LegalForm),
+		"fullname":    nullString(company.FullName),
+		"inn":         nullString(company.Inn),
+		"kpp":         nullString(company.Kpp),
+		"legaladdr":   nullString(company.LegalAddress),
+		"account":     nullString(company.BankAccountNumber),
+		"corraccount": nullString(company.CorrespondentAccount),
+		"bankcode":    nullString(company.BankCode),
+		"bank":        nullString(company.Bank),
+		"ceo":         nullString(company.Ceo),
+		"accountant":  nullString(company.ChiefAccountant),
+		"realaddr":    nullString(company.RealAddress),
+	}
+}
+
+// insertCompany inserts the company with a new id and returns the id
+func insertCompany(ctx context.Context, tx *sqlx.Tx, company *ongrid2.Company) (int64, error) {
+	id, err := nextID(ctx, tx, "gen_sys$companies_id")
+	if err != nil {
+		return 0, err
+	}
+	company.ID = int32(id)
+
+	_, err = tx.NamedExecContext(ctx, "insert into sys$companies (id, servicename, phone, legalform, fullname, inn, kpp, legaladdress, "+
+		"bankaccountnumber, correspondentaccount, bankcode, bank, ceo, chiefaccountant, realaddress) "+
+		"values (:id, :name, :phone, :legalform, :fullname, :inn, :kpp, :legaladdr, :account, :corraccount, :bankcode, :bank, :ceo, :accountant, :realaddr)",
+		companyParams(company))
+	if err != nil {
+		log.Printf("insertCompany, insert into sys$companies error: %v", err)
+		return 0, sqlError(err)
+	}
+	return id, nil
+}
+
+func updateCompany(ctx context.Context, tx *sqlx.Tx, company *ongrid2.Company) error {
+	res, err := tx.NamedExecContext(ctx, "update sys$companies set servicename = :name, phone = :phone, legalform = :legalform, fullname = :fullname, "+
+		"inn = :inn, kpp = :kpp, legaladdress = :legaladdr, bankaccountnumber = :account, correspondentaccount = :corraccount, "+
+		"bankcode = :bankcode, bank = :bank, ceo = :ceo, chiefaccountant = :accountant, realaddress = :realaddr where id = :id",
+		companyParams(company))
+	if err != nil {
+		log.Printf("updateCompany, update sys$companies error: %v", err)
+		return sqlError(err)
+	}
+	return rowUpdated(res, "Company", int64(company.ID))
+}
+
+// clientParams returns the bind values of sys$clients. A person or company
+// with id 0 is inserted first, otherwise the client is linked to it.
+func clientParams(ctx context.Context, tx *sqlx.Tx, client *ongrid2.Client) (map[string]interface{}, error) {
+	var personID, companyID int64
+	var err error
+	if client.Person != nil {
+		personID = int64(client.Person.ID)
+		if personID == 0 {
+			if personID, err = insertPerson(ctx, tx, client.Person); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if client.Company != nil {
+		companyID = int64(client.Company.ID)
+		if companyID == 0 {
+			if companyID, err = insertCompany(ctx, tx, client.Company); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"id":      client.ID,
+		"email":   nullString(client.GetEmail()),
+		"name":    nullString(client.GetName()),
+		"acctype": int(client.AccountType),
+		"clitype": int(client.ClientType),
+		"regdate": unixTime(client.RegistrationDate),
+		"phone":   nullString(client.Phone),
+		"person":  nullInt(personID),
+		"company": nullInt(companyID),
+	}, nil
+}
+
+// insertClient inserts the client with a new id and returns the id
+func insertClient(ctx context.Context, tx *sqlx.Tx, client *ongrid2.Client) (int64, error) {
+	id, err := nextID(ctx, tx, "gen_sys$clients_id")
+	if err != nil {
+		return 0, err
+	}
+	client.ID = id
+
+	params, err := clientParams(ctx, tx, client)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.NamedExecContext(ctx, "insert into sys$clients (id, email, name, accounttype, clienttype, registrationdate, phone, person, company) "+
+		"values (:id, :email, :name, :acctype, :clitype, :regdate, :phone, :person, :company)", params)
+	if err != nil {
+		log.Printf("insertClient, insert into sys$clients error: %v", err)
+		return 0, sqlError(err)
+	}
+	return id, nil
+}
+
+// updateClient updates the client, a registration date of 0 keeps the stored
+// one
+func updateClient(ctx context.Context, tx *sqlx.Tx, client *ongrid2.Client) error {
+	params, err := clientParams(ctx, tx, client)
+	if err != nil {
+		return err
+	}
+	res, err := tx.NamedExecContext(ctx, "update sys$clients set email = :email, name = :name, accounttype = :acctype, clienttype = :clitype, "+
+		"registrationdate = coalesce(cast(:regdate as timestamp), registrationdate), phone = :phone, person = :person, company = :company where id = :id", params)
+	if err != nil {
+		log.Printf("updateClient, update sys$clients error: %v", err)
+		return sqlError(err)
+	}
+	return rowUpdated(res, "Client", client.ID)
+}
+
+// carOwner returns the bind value of sys$cars.owner, the id of the owner
+// client. The client must exist, "" is no owner.
+func carOwner(ctx context.Context, tx *sqlx.Tx, owner string) (interface{}, error) {
+	if owner == "" {
+		return nil, nil
+	}
+	clientID, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil || clientID <= 0 {
+		return nil, invalidData(fmt.Sprintf("Car owner %q is not a client id", owner))
+	}
+	if _, err = loadClient(ctx, tx, clientID); err != nil {
+		return nil, err
+	}
+	return owner, nil
+}
+
+func carParams(ctx context.Context, tx *sqlx.Tx, car *ongrid2.Car) (map[string]interface{}, error) {
+	owner, err := carOwner(ctx, tx, car.Owner)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"id":       car.ID,
+		"brand":    nullString(car.Brand),
+		"model":    nullString(car.Model),
+		"number":   nullString(car.Number),
+		"year":     nullInt(int64(car.Year)),
+		"mileage":  nullInt(int64(car.Mileage)),
+		"volume":   car.EngineVolume,
+		"engine":   nullInt(int64(car.EngineType)),
+		"gear":     nullInt(int64(car.GearType)),
+		"body":     nullInt(int64(car.BodyType)),
+		"drive":    nullInt(int64(car.DriveType)),
+		"vin":      nullString(car.VIN),
+		"traider":  nullString(car.CarTraider),
+		"saledate": unixTime(car.SaleDate),
+		"color":    nullString(car.Color),
+		"owner":    owner,
+	}, nil
+}
+
+// insertCar inserts the car with a new id and returns the id
+func insertCar(ctx context.Context, tx *sqlx.Tx, car *ongrid2.Car) (int64, error) {
+	id, err := nextID(ctx, tx, "gen_sys$cars_id")
+	if err != nil {
+		return 0, err
+	}
+	car.ID = id
+
+	params, err := carParams(ctx, tx, car)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.NamedExecContext(ctx, "insert into sys$cars (id, brand, model, number, fyear, mileage, enginevolume, enginetype, geartype, "+
+		"bodytype, drivetype, vin, cartraider, saledate, color, owner) "+
+		"values (:id, :brand, :model, :number, :year, :mileage, :volume, :engine, :gear, :body, :drive, :vin, :traider, :saledate, :color, :owner)", params)
+	if err != nil {
+		log.Printf("insertCar, insert into sys$cars error: %v", err)
+		return 0, sqlError(err)
+	}
+	return id, nil
+}
+
+func updateCar(ctx context.Context, tx *sqlx.Tx, car *ongrid2.Car) error {
+	params, err := carParams(ctx, tx, car)
+	if err != nil {
+		return err
+	}
+	res, err := tx.NamedExecContext(ctx, "update sys$cars set brand = :brand, model = :model, number = :number, fyear = :year, mileage = :mileage, "+
+		"enginevolume = :volume, enginetype = :engine, geartype = :gear, bodytype = :body, drivetype = :drive, vin = :vin, "+
+		"cartraider = :traider, saledate = :saledate, color = :color, owner = :owner where id = :id", params)
+	if err != nil {
+		log.Printf("updateCar, update sys$cars error: %v", err)
+		return sqlError(err)
+	}
+	return rowUpdated(res, "Car", car.ID)
+}
+
+// setCarOwner links the car to the owner client, clientID 0 unlinks it
+func setCarOwner(ctx context.Context, tx *sqlx.Tx, carID, clientID int64) error {
+	var owner string
+	if clientID != 0 {
+		owner = strconv.FormatInt(clientID, 10)
+	}
+	value, err := carOwner(ctx, tx, owner)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "update sys$cars set owner = ? where id = ?", value, carID)
+	if err != nil {
+		log.Printf("setCarOwner, update sys$cars error: %v", err)
+		return sqlError(err)
+	}
+	return rowUpdated(res, "Car", carID)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"ongrid-thrift/ongrid2"
+	"regexp"
+	"strings"
+)
+
+var (
+	// innPattern: 10 digits for organizations, 12 for individual entrepreneurs
+	innPattern = regexp.MustCompile(`^(\d{10}|\d{12})$`)
+	// kppPattern: tax office code, reason code and number
+	kppPattern = regexp.MustCompile(`^\d{4}[\dA-Z]{2}\d{3}$`)
+	// vinPattern: 17 characters without I, O and Q (ISO 3779)
+	vinPattern = regexp.MustCompile(`^[A-HJ-NPR-Z0-9]{17}$`)
+)
+
+func invalidData(message string) error {
+	return userError(ongrid2.ErrorCode_DATA_INCORRECT, message)
+}
+
+// validateCompany checks the company before it is written and normalizes INN
+// and KPP. INN and KPP are optional, KPP is only set with a 10 digit INN.
+func validateCompany(company *ongrid2.Company) error {
+	company.Servicename = strings.TrimSpace(company.Servicename)
+	company.Inn = strings.TrimSpace(company.Inn)
+	company.Kpp = strings.ToUpper(strings.TrimSpace(company.Kpp))
+
+	if company.Servicename == "" {
+		return invalidData("Company servicename is required")
+	}
+	if company.Inn != "" && !innPattern.MatchString(company.Inn) {
+		return invalidData("Company INN must be 10 or 12 digits")
+	}
+	if company.Kpp != "" {
+		if !kppPattern.MatchString(company.Kpp) {
+			return invalidData("Company KPP must be 9 characters: 4 digits, 2 digits or letters, 3 digits")
+		}
+		if len(company.Inn) != 10 {
+			return invalidData("Company KPP is set only with a 10 digit INN")
+		}
+	}
+	return nil
+}
+
+// validateCar checks the car before it is written and normalizes VIN, an
+// empty VIN is allowed
+func validateCar(car *ongrid2.Car) error {
+	car.VIN = strings.ToUpper(strings.TrimSpace(car.VIN))
+
+	if car.VIN != "" && !vinPattern.MatchString(car.VIN) {
+		return invalidData("Car VIN must be 17 letters and digits without I, O and Q")
+	}
+	if car.Year < 0 || car.Mileage < 0 || car.EngineVolume < 0 {
+		return invalidData("Car year, mileage and engine volume must not be negative")
+	}
+	return nil
+}
+
+// validatePerson checks the person before it is written
+func validatePerson(person *ongrid2.Person) error {
+	person.FirstName = strings.TrimSpace(person.FirstName)
+	person.LastName = strings.TrimSpace(person.LastName)
+
+	if person.FirstName == "" && person.LastName == "" {
+		return invalidData("Person first or last name is required")
+	}
+	return nil
+}
+
+// validateClient checks the client and its new person or company before they
+// are written. A PERSON client needs a person, a COMPANY client a company.
+func validateClient(client *ongrid2.Client) error {
+	if client.Email != nil {
+		*client.Email = strings.TrimSpace(*client.Email)
+	}
+
+	switch client.ClientType {
+	case ongrid2.ClientType_PERSON:
+		if client.Person == nil {
+			return invalidData("Person client requires a person")
+		}
+	case ongrid2.ClientType_COMPANY:
+		if client.Company == nil {
+			return invalidData("Company client requires a company")
+		}
+	default:
+		return invalidData("Client type must be PERSON or COMPANY")
+	}
+
+	if client.Person != nil && client.Person.ID == 0 {
+		if err := validatePerson(client.Person); err != nil {
+			return err
+		}
+	}
+	if client.Company != nil && client.Company.ID == 0 {
+		if err := validateCompany(client.Company); err != nil {
+			return err
+		}
+	}
+	return nil
+}
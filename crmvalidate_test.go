@@ -0,0 +1,118 @@
+package main
+
+import (
+	"ongrid-thrift/ongrid2"
+	"testing"
+)
+
+func isDataIncorrect(err error) bool {
+	e, ok := err.(*ongrid2.UserException)
+	return ok && e.Code == ongrid2.ErrorCode_DATA_INCORRECT
+}
+
+func TestValidateCompany(t *testing.T) {
+	tests := []struct {
+		name     string
+		company  ongrid2.Company
+		valid    bool
+		inn, kpp string // normalized
+	}{
+		{"no INN and KPP", ongrid2.Company{Servicename: "Service"}, true, "", ""},
+		{"organization", ongrid2.Company{Servicename: "Service", Inn: "7707083893", Kpp: "773601001"}, true, "7707083893", "773601001"},
+		{"entrepreneur", ongrid2.Company{Servicename: "Service", Inn: "500100732259"}, true, "500100732259", ""},
+		{"spaces and lower case KPP", ongrid2.Company{Servicename: " Service ", Inn: " 7707083893 ", Kpp: " 7736ab001 "}, true, "7707083893", "7736AB001"},
+		{"no servicename", ongrid2.Company{Servicename: "  ", Inn: "7707083893"}, false, "", ""},
+		{"INN of 11 digits", ongrid2.Company{Servicename: "Service", Inn: "77070838931"}, false, "", ""},
+		{"INN with letters", ongrid2.Company{Servicename: "Service", Inn: "77070838AB"}, false, "", ""},
+		{"KPP of 8 characters", ongrid2.Company{Servicename: "Service", Inn: "7707083893", Kpp: "77360100"}, false, "", ""},
+		{"KPP with letters in the number", ongrid2.Company{Servicename: "Service", Inn: "7707083893", Kpp: "7736010AB"}, false, "", ""},
+		{"KPP with entrepreneur INN", ongrid2.Company{Servicename: "Service", Inn: "500100732259", Kpp: "773601001"}, false, "", ""},
+		{"KPP without INN", ongrid2.Company{Servicename: "Service", Kpp: "773601001"}, false, "", ""},
+	}
+
+	for _, tt := range tests {
+		company := tt.company
+		err := validateCompany(&company)
+		if !tt.valid {
+			if !isDataIncorrect(err) {
+				t.Errorf("%s: got %v, want DATA_INCORRECT", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if company.Inn != tt.inn || company.Kpp != tt.kpp || company.Servicename != "Service" {
+			t.Errorf("%s: normalized to %q, %q, %q", tt.name, company.Servicename, company.Inn, company.Kpp)
+		}
+	}
+}
+
+func TestValidateCar(t *testing.T) {
+	tests := []struct {
+		name  string
+		car   ongrid2.Car
+		valid bool
+		vin   string // normalized
+	}{
+		{"no VIN", ongrid2.Car{}, true, ""},
+		{"VIN", ongrid2.Car{VIN: "XTA21099043456789", Year: 2004, Mileage: 120000, EngineVolume: 1.5}, true, "XTA21099043456789"},
+		{"lower case VIN", ongrid2.Car{VIN: " wvwzzz1jzxw000001 "}, true, "WVWZZZ1JZXW000001"},
+		{"VIN of 16 characters", ongrid2.Car{VIN: "XTA2109904345678"}, false, ""},
+		{"VIN of 18 characters", ongrid2.Car{VIN: "XTA210990434567890"}, false, ""},
+		{"VIN with I", ongrid2.Car{VIN: "XTA21099I43456789"}, false, ""},
+		{"VIN with O", ongrid2.Car{VIN: "XTA21099O43456789"}, false, ""},
+		{"VIN with Q", ongrid2.Car{VIN: "XTA21099Q43456789"}, false, ""},
+		{"VIN with a dash", ongrid2.Car{VIN: "XTA-1099043456789"}, false, ""},
+		{"negative year", ongrid2.Car{Year: -1}, false, ""},
+		{"negative mileage", ongrid2.Car{Mileage: -1}, false, ""},
+		{"negative engine volume", ongrid2.Car{EngineVolume: -0.1}, false, ""},
+	}
+
+	for _, tt := range tests {
+		car := tt.car
+		err := validateCar(&car)
+		if !tt.valid {
+			if !isDataIncorrect(err) {
+				t.Errorf("%s: got %v, want DATA_INCORRECT", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if car.VIN != tt.vin {
+			t.Errorf("%s: VIN normalized to %q, want %q", tt.name, car.VIN, tt.vin)
+		}
+	}
+}
+
+func TestValidateClient(t *testing.T) {
+	tests := []struct {
+		name   string
+		client ongrid2.Client
+		valid  bool
+	}{
+		{"person", ongrid2.Client{ClientType: ongrid2.ClientType_PERSON, Person: &ongrid2.Person{FirstName: "Ivan"}}, true},
+		{"existing person", ongrid2.Client{ClientType: ongrid2.ClientType_PERSON, Person: &ongrid2.Person{ID: 5}}, true},
+		{"company", ongrid2.Client{ClientType: ongrid2.ClientType_COMPANY, Company: &ongrid2.Company{Servicename: "Service"}}, true},
+		{"person without person", ongrid2.Client{ClientType: ongrid2.ClientType_PERSON}, false},
+		{"company without company", ongrid2.Client{ClientType: ongrid2.ClientType_COMPANY}, false},
+		{"no type", ongrid2.Client{Person: &ongrid2.Person{FirstName: "Ivan"}}, false},
+		{"new person without name", ongrid2.Client{ClientType: ongrid2.ClientType_PERSON, Person: &ongrid2.Person{}}, false},
+		{"new company with invalid INN", ongrid2.Client{ClientType: ongrid2.ClientType_COMPANY, Company: &ongrid2.Company{Servicename: "Service", Inn: "123"}}, false},
+	}
+
+	for _, tt := range tests {
+		client := tt.client
+		err := validateClient(&client)
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && !isDataIncorrect(err) {
+			t.Errorf("%s: got %v, want DATA_INCORRECT", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrCursorNotFound is returned for an unknown or closed cursor id
+var ErrCursorNotFound = errors.New("Cursor not found")
+
+// maxFetchRows limits the rows returned by one FetchCursor call
+const maxFetchRows = 10000
+
+// ErrTooManyCursors is returned by OpenCursor when the session already has
+// maxcursors open cursors
+var ErrTooManyCursors = errors.New("Too many open cursors, close unused cursors")
+
+// Cursor is an open select on the data database, rows are read by the client
+// in pages. A cursor holds a database connection until it is closed.
+type Cursor struct {
+	id      string
+	rows    *sqlx.Rows
+	columns []*ongrid2.ColumnMetadata
+
+	// the select context lives between calls, timeout is applied to each
+	// call by callTimer
+	cancel  context.CancelFunc
+	timeout time.Duration
+	query   *runningQuery
+	queryID string
+
+	mu       sync.Mutex
+	done     bool
+	lastUsed time.Time
+}
+
+// fetch reads up to count rows, after the last row the rows are released
+func (c *Cursor) fetch(count int) (*ongrid2.DataRowSet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer func() { c.lastUsed = time.Now() }()
+
+	dataRowSet := &ongrid2.DataRowSet{Columns: c.columns, Rows: []*ongrid2.DataRow{}}
+	if c.done {
+		return dataRowSet, nil
+	}
+
+	timer := startCallTimer(c.timeout, c.cancel)
+	defer timer.stop()
+
+	for len(dataRowSet.Rows) < count {
+		if !c.rows.Next() {
+			c.done = true
+			c.rows.Close()
+			if err := c.rows.Err(); err != nil {
+				if timer.stop() {
+					err = context.DeadlineExceeded
+				}
+				return nil, sqlError(err)
+			}
+			break
+		}
+		dataRow, err := scanDataRow(c.rows, c.columns)
+		if err != nil {
+			return nil, err
+		}
+		dataRowSet.Rows = append(dataRowSet.Rows, dataRow)
+	}
+
+	return dataRowSet, nil
+}
+
+// idle reports whether the cursor was not fetched longer than timeout, it
+// waits for a running fetch
+func (c *Cursor) idle(now time.Time, timeout time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return timeout > 0 && now.Sub(c.lastUsed) > timeout
+}
+
+// close releases the rows, it waits for a running fetch
+func (c *Cursor) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.done = true
+	err := c.rows.Close()
+	c.cancel()
+	return err
+}
+
+// openCursor runs the select and registers the cursor in the session. The
+// cursor can be cancelled by the query id until it is closed. A session keeps
+// at most maxcursors open cursors.
+func (s *Session) openCursor(query *ongrid2.Query) (*Cursor, error) {
+	if s.tooManyCursors() {
+		return nil, userError(ongrid2.ErrorCode_DATA_INCORRECT, ErrTooManyCursors.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queryID := query.GetID()
+	q := s.registerQuery(cancel, queryID)
+
+	timer := startCallTimer(s.cfg.QueryTimeout, cancel)
+	rows, err := s.namedQuery(ctx, nil, query)
+	if timer.stop() {
+		if err == nil {
+			rows.Close()
+		}
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		s.unregisterQuery(q, queryID)
+		cancel()
+		log.Printf("openCursor error: %v", err)
+		return nil, sqlError(err)
+	}
+
+	columns, err := columnsMetadata(rows)
+	if err != nil {
+		rows.Close()
+		s.unregisterQuery(q, queryID)
+		cancel()
+		return nil, err
+	}
+
+	c := &Cursor{id: s.nextID(), rows: rows, columns: columns, cancel: cancel, timeout: s.cfg.QueryTimeout, query: q, queryID: queryID, lastUsed: time.Now()}
+
+	// a concurrent OpenCursor may have taken the last place while the select
+	// was running
+	s.mu.Lock()
+	if s.cfg.MaxCursors > 0 && len(s.cursors) >= s.cfg.MaxCursors {
+		s.mu.Unlock()
+		s.unregisterQuery(q, queryID)
+		c.close()
+		return nil, userError(ongrid2.ErrorCode_DATA_INCORRECT, ErrTooManyCursors.Error())
+	}
+	s.cursors[c.id] = c
+	s.mu.Unlock()
+
+	return c, nil
+}
+
+// tooManyCursors reports whether the session has maxcursors open cursors
+func (s *Session) tooManyCursors() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cfg.MaxCursors > 0 && len(s.cursors) >= s.cfg.MaxCursors
+}
+
+// cursor returns the open cursor by id
+func (s *Session) cursor(id string) (*Cursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.cursors[id]
+	if !ok {
+		return nil, ErrCursorNotFound
+	}
+	return c, nil
+}
+
+// closeCursor removes the cursor from the session and closes it
+func (s *Session) closeCursor(id string) error {
+	s.mu.Lock()
+	c, ok := s.cursors[id]
+	delete(s.cursors, id)
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrCursorNotFound
+	}
+	s.unregisterQuery(c.query, c.queryID)
+	return c.close()
+}
+
+// closeIdleCursors closes cursors that were not fetched longer than
+// cursortimeout
+func (s *Session) closeIdleCursors(now time.Time) {
+	s.mu.Lock()
+	list := make([]*Cursor, 0, len(s.cursors))
+	for _, c := range s.cursors {
+		list = append(list, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range list {
+		if !c.idle(now, s.cfg.CursorTimeout) {
+			continue
+		}
+		if err := s.closeCursor(c.id); err != nil {
+			if err != ErrCursorNotFound {
+				log.Printf("Session %s: close cursor %s: %v", s.id, c.id, err)
+			}
+			continue
+		}
+		log.Printf("Session %s: idle cursor %s closed", s.id, c.id)
+	}
+}
+
+// closeCursors closes all cursors of the session
+func (s *Session) closeCursors() {
+	s.mu.Lock()
+	list := make([]*Cursor, 0, len(s.cursors))
+	for id, c := range s.cursors {
+		delete(s.cursors, id)
+		list = append(list, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range list {
+		s.unregisterQuery(c.query, c.queryID)
+		if err := c.close(); err != nil {
+			log.Printf("Session %s: close cursor %s: %v", s.id, c.id, err)
+		}
+	}
+}
@@ -3,11 +3,8 @@ package main
 import (
 	"database/sql"
 	"database/sql/driver"
-	"log"
 	"ongrid-thrift/ongrid2"
 	"time"
-
-	"github.com/jmoiron/sqlx"
 )
 
 // DBAuth ...
@@ -38,53 +35,53 @@ type DBRequest struct {
 
 // DBClient ...
 type DBClient struct {
-	ID               int           `db:"ID"`
-	Email            string        `db:"EMAIL"`
-	Name             string        `db:"NAME"`
-	AccountType      int           `db:"ACCOUNTTYPE"`
-	ClientType       int           `db:"CLIENTTYPE"`
-	RegistrationDate NullTime      `db:"REGISTRATIONDATE"`
-	Phone            string        `db:"PHONE"`
-	Person           sql.NullInt64 `db:"PERSON"`
-	Company          sql.NullInt64 `db:"COMPANY"`
+	ID               int            `db:"ID"`
+	Email            sql.NullString `db:"EMAIL"`
+	Name             sql.NullString `db:"NAME"`
+	AccountType      int            `db:"ACCOUNTTYPE"`
+	ClientType       int            `db:"CLIENTTYPE"`
+	RegistrationDate NullTime       `db:"REGISTRATIONDATE"`
+	Phone            sql.NullString `db:"PHONE"`
+	Person           sql.NullInt64  `db:"PERSON"`
+	Company          sql.NullInt64  `db:"COMPANY"`
 }
 
 // DBCar ...
 type DBCar struct {
-	ID           int      `db:"ID"`
-	Brand        string   `db:"BRAND"`
-	Model        string   `db:"MODEL"`
-	Number       string   `db:"NUMBER"`
-	Year         int32    `db:"FYEAR"`
-	Mileage      int32    `db:"MILEAGE"`
-	EngineVolume float64  `db:"ENGINEVOLUME"`
-	EngineType   int      `db:"ENGINETYPE"`
-	GearType     int      `db:"GEARTYPE"`
-	BodyType     int      `db:"BODYTYPE"`
-	DriveType    int      `db:"DRIVETYPE"`
-	VIN          string   `db:"VIN"`
-	CarTraider   string   `db:"CARTRAIDER"`
-	SaleDate     NullTime `db:"SALEDATE"`
-	Color        string   `db:"COLOR"`
-	Owner        string   `db:"OWNER"`
+	ID           int             `db:"ID"`
+	Brand        sql.NullString  `db:"BRAND"`
+	Model        sql.NullString  `db:"MODEL"`
+	Number       sql.NullString  `db:"NUMBER"`
+	Year         sql.NullInt64   `db:"FYEAR"`
+	Mileage      sql.NullInt64   `db:"MILEAGE"`
+	EngineVolume sql.NullFloat64 `db:"ENGINEVOLUME"`
+	EngineType   sql.NullInt64   `db:"ENGINETYPE"`
+	GearType     sql.NullInt64   `db:"GEARTYPE"`
+	BodyType     sql.NullInt64   `db:"BODYTYPE"`
+	DriveType    sql.NullInt64   `db:"DRIVETYPE"`
+	VIN          sql.NullString  `db:"VIN"`
+	CarTraider   sql.NullString  `db:"CARTRAIDER"`
+	SaleDate     NullTime        `db:"SALEDATE"`
+	Color        sql.NullString  `db:"COLOR"`
+	Owner        sql.NullString  `db:"OWNER"`
 }
 
 // DBPerson ...
 type DBPerson struct {
 	ID             int            `db:"ID"`
-	FirstName      string         `db:"FIRSTNAME"`
-	LastName       string         `db:"LASTNAME"`
+	FirstName      sql.NullString `db:"FIRSTNAME"`
+	LastName       sql.NullString `db:"LASTNAME"`
 	PassportNumber sql.NullString `db:"PASSPORTNUMBER"`
 	PassportSeries sql.NullString `db:"PASSPORTSERIES"`
 	PassportDate   sql.NullString `db:"PASSPORTDATE"`
 	BirthDay       NullTime       `db:"BIRTHDAY"`
-	Gender         int            `db:"GENDER"`
+	Gender         sql.NullInt64  `db:"GENDER"`
 }
 
 // DBCompany ...
 type DBCompany struct {
 	ID                   int            `db:"ID"`
-	ServiceName          string         `db:"SERVICENAME"`
+	ServiceName          sql.NullString `db:"SERVICENAME"`
 	Phone                sql.NullString `db:"PHONE"`
 	LegalForm            sql.NullString `db:"LEGALFORM"`
 	FullName             sql.NullString `db:"FULLNAME"`
@@ -146,249 +143,14 @@ func (nt NullTime) Value() (driver.Value, error) {
 	return nt.Time, nil
 }
 
-func getClient(clientID int) (*ongrid2.Client, error) {
-	client := ongrid2.Client{}
-	var dbClient DBClient
-
-	err := dbOnGrid.Get(&dbClient, "select id, email, name, accounttype, clienttype, registrationdate, phone, person, company from sys$clients where id = ?", clientID)
-	if err != nil {
-		log.Printf("dbOnGrid.Get from sys$client error: %v", err)
-		return nil, err
-	}
-
-	client.ID = int64(dbClient.ID)
-	client.Email = &dbClient.Email
-	client.Name = &dbClient.Name
-	client.AccountType = ongrid2.AccountType(dbClient.AccountType)
-	client.ClientType = ongrid2.ClientType(dbClient.ClientType)
-	client.RegistrationDate = dbClient.RegistrationDate.Time.Unix()
-	client.Phone = dbClient.Phone
-	if dbClient.Person.Valid {
-		person := getPerson(dbClient.Person.Int64)
-		client.Person = person
-	}
-	if dbClient.Company.Valid {
-		company := getCompany(dbClient.Company.Int64)
-		client.Company = company
-	}
-
-	return &client, nil
-}
-
-func getClients() ([]*ongrid2.Client, error) {
-	var clients []*ongrid2.Client
-
-	rows, err := dbOnGrid.Queryx("select id from sys$clients")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var clientID int
-
-	for rows.Next() {
-		err := rows.Scan(&clientID)
-		if err != nil {
-			log.Printf("getClients, StructScan: %v", err)
-		}
-		client, err := getClient(clientID)
-		if err != nil {
-			log.Printf("getClients: %v", err)
-		}
-		clients = append(clients, client)
-	}
-
-	return clients, nil
-}
-
-func getPerson(personID int64) *ongrid2.Person {
-	person := ongrid2.Person{}
-	var dbPerson DBPerson
-
-	err := dbOnGrid.Get(&dbPerson, "select * from sys$person where id = ?", personID)
-	if err != nil {
-		log.Printf("db.Get from sys$person error: %v", err)
-	}
-
-	person.ID = int32(dbPerson.ID)
-	person.FirstName = dbPerson.FirstName
-	person.LastName = dbPerson.LastName
-
-	person.PassportNumber = dbPerson.PassportNumber.String
-	person.PassportSeries = dbPerson.PassportSeries.String
-	person.PassportDate = dbPerson.PassportDate.String
-	person.BirthDay = dbPerson.BirthDay.Time.Unix()
-	person.Gender = ongrid2.GenderType(dbPerson.Gender)
-
-	return &person
-}
-
-func getCompany(companyID int64) *ongrid2.Company {
-	company := ongrid2.Company{}
-	var dbCompany DBCompany
-
-	err := dbOnGrid.Get(&dbCompany, "select * from sys$companies where id = ?", companyID)
-	if err != nil {
-		log.Printf("db.Get from sys$companies error: %v", err)
-	}
-
-	company.ID = int32(dbCompany.ID)
-	company.Servicename = dbCompany.ServiceName
-	company.Phone = dbCompany.Phone.String
-	company.LegalForm = dbCompany.LegalForm.String
-	company.FullName = dbCompany.FullName.String
-	company.Inn = dbCompany.Inn.String
-	company.Kpp = dbCompany.Kpp.String
-	company.LegalAddress = dbCompany.LegalAddress.String
-	company.BankAccountNumber = dbCompany.BankAccountNumber.String
-	company.CorrespondentAccount = dbCompany.CorrespondentAccount.String
-	company.BankCode = dbCompany.BankCode.String
-	company.Bank = dbCompany.Bank.String
-	company.Ceo = dbCompany.Ceo.String
-	company.ChiefAccountant = dbCompany.ChiefAccountant.String
-	company.RealAddress = dbCompany.RealAddress.String
-
-	return &company
-}
-
-func getCar(carID int) (*ongrid2.Car, error) {
-	car := ongrid2.Car{}
-	var dbCar DBCar
-
-	err := dbOnGrid.Get(&dbCar, "select * from sys$cars where id = ?", carID)
-	if err != nil {
-		log.Printf("db.Get from sys$cars error: %v", err)
-		return nil, err
+// messageFromDB converts the row of igo$messages without attachments
+func messageFromDB(dbMsg DBMessage) *ongrid2.Message {
+	return &ongrid2.Message{
+		ID:        int64(dbMsg.ID),
+		Customer:  dbMsg.Customer,
+		Body:      dbMsg.Body,
+		ParentId:  dbMsg.ParentID.Int64,
+		Direction: int32(dbMsg.Direction),
+		CreatedAt: dbMsg.CreatedAt.Unix(),
 	}
-
-	car.ID = int64(dbCar.ID)
-	car.Brand = dbCar.Brand
-	car.Model = dbCar.Model
-	car.Number = dbCar.Number
-	car.Year = int32(dbCar.Year)
-	car.Mileage = int32(dbCar.Mileage)
-	car.EngineVolume = dbCar.EngineVolume
-	car.EngineType = ongrid2.EngineType(dbCar.EngineType)
-	car.GearType = ongrid2.GearType(dbCar.GearType)
-	car.BodyType = ongrid2.BodyType(dbCar.BodyType)
-	car.DriveType = ongrid2.DriveType(dbCar.DriveType)
-	car.VIN = dbCar.VIN
-	car.CarTraider = dbCar.CarTraider
-	if dbCar.SaleDate.Valid {
-		car.SaleDate = dbCar.SaleDate.Time.Unix()
-	}
-	car.Color = dbCar.Color
-	car.Owner = dbCar.Owner
-
-	return &car, nil
-}
-
-func getCars() ([]*ongrid2.Car, error) {
-	var cars []*ongrid2.Car
-
-	rows, err := dbOnGrid.Queryx("select id from sys$cars")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var carID int
-
-	for rows.Next() {
-		err := rows.Scan(&carID)
-		if err != nil {
-			log.Printf("getCars, StructScan: %v", err)
-		}
-		car, err := getCar(carID)
-		if err != nil {
-			log.Printf("getCars: %v", err)
-		}
-		cars = append(cars, car)
-	}
-
-	return cars, nil
-}
-
-func getMessage(db *sqlx.DB, messageID int) (*ongrid2.Message, error) {
-	//msg := ongrid2.Message{}
-	var msg ongrid2.Message
-	var dbMsg DBMessage
-
-	log.Printf("getMessage, messageId = %v", messageID)
-
-	err := db.Get(&dbMsg, "select * from igo$messages where id = ?", messageID)
-	if err != nil {
-		log.Printf("db.Get from igo$messages error: %v", err)
-		return nil, err
-	}
-
-	msg.ID = int64(dbMsg.ID)
-	msg.Customer = dbMsg.Customer
-	msg.Body = dbMsg.Body
-	msg.ParentId = dbMsg.ParentID.Int64
-	msg.Direction = int32(dbMsg.Direction)
-	msg.CreatedAt = dbMsg.CreatedAt.Unix()
-
-	if dbMsg.Attach == 1 {
-		rows, err := db.Queryx("select * from igo$attachments where messageid = ?", messageID)
-		if err != nil {
-			log.Printf("db.Get from igo$attachments error: %v", err)
-			return nil, err
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var dbAttach DBAttach
-			err = rows.StructScan(&dbAttach)
-			if err != nil {
-				log.Printf("igo$attachments, StructScan: %v", err)
-			}
-			var attach ongrid2.FileAttach
-			attach.OriginalFilename = dbAttach.OriginalFilename
-			attach.Filename = dbAttach.Filename
-			msg.Attachments = append(msg.Attachments, &attach)
-		}
-	}
-
-	return &msg, nil
-}
-
-func getRequest(requestID int) (*ongrid2.Request, error) {
-	request := ongrid2.Request{}
-	var dbRequest DBRequest
-
-	err := dbOnGrid.Get(&dbRequest, "select * from sys$requests where id = ?", requestID)
-	if err != nil {
-		log.Printf("db.Get from sys$cars error: %v", err)
-		return nil, err
-	}
-
-	user, _ := getClient(dbRequest.User)
-	company, _ := getClient(dbRequest.Company)
-	car, _ := getCar(dbRequest.Car)
-
-	request.ID = int32(dbRequest.ID)
-	request.User = user
-	request.Company = company
-	if dbRequest.CreatedDateTime.Valid {
-		request.CreatedDateTime = dbRequest.CreatedDateTime.Time.Unix()
-	}
-	if dbRequest.DesiredDateTime.Valid {
-		request.DesiredDateTime = dbRequest.DesiredDateTime.Time.Unix()
-	}
-	request.DesiredTimePeriod = int32(dbRequest.DesiredTimePeriod.Int64)
-	request.Phone = dbRequest.Phone.String
-	request.Email = dbRequest.Email.String
-	request.Description = dbRequest.Description.String
-	request.Car = car
-	if dbRequest.CheckInDateTime.Valid {
-		request.CheckInDateTime = dbRequest.CheckInDateTime.Time.Unix()
-	}
-	if dbRequest.CheckOutDateTime.Valid {
-		request.CheckOutDateTime = dbRequest.CheckOutDateTime.Time.Unix()
-	}
-	request.Status = ongrid2.RequestStatus(dbRequest.Status)
-	request.MasterInspector = dbRequest.MasterInspector.String
-
-	return &request, nil
 }
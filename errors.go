@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"ongrid-thrift/ongrid2"
+
+	"gopkg.in/mgo.v2"
+)
+
+// ErrPermissionDenied is returned when the user has no rights for the action
+var ErrPermissionDenied = errors.New("Permission denied")
+
+// userError returns UserException with the code, clients branch on the code
+// instead of the message text
+func userError(code ongrid2.ErrorCode, message string) *ongrid2.UserException {
+	return &ongrid2.UserException{Code: code, Message: message}
+}
+
+// notFoundError returns NotFoundException for a missing row or document
+func notFoundError(message string) *ongrid2.NotFoundException {
+	return &ongrid2.NotFoundException{Message: message}
+}
+
+// sqlError returns InvalidOperation with the database error text, typed
+// exceptions are returned as is
+func sqlError(err error) error {
+	switch err.(type) {
+	case *ongrid2.UserException, *ongrid2.NotFoundException, *ongrid2.InvalidOperation, *ongrid2.IntergridException, *ongrid2.InvalidTransition:
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return notFoundError(err.Error())
+	}
+	if e := queryError(err); e != nil {
+		return e
+	}
+	return &ongrid2.InvalidOperation{What: int32(ongrid2.ErrorCode_SQL_ERROR), Why: err.Error()}
+}
+
+// queryError returns UserException for a database call stopped by its
+// context, nil for other errors
+func queryError(err error) *ongrid2.UserException {
+	switch err {
+	case context.DeadlineExceeded:
+		return userError(ongrid2.ErrorCode_QUERY_TIMEOUT, "Query timeout exceeded")
+	case context.Canceled:
+		return userError(ongrid2.ErrorCode_QUERY_CANCELED, "Query canceled")
+	}
+	return nil
+}
+
+// mapError converts the error returned by a handler to one of the exceptions
+// declared in ongrid2.thrift. Handlers call it deferred:
+//
+//	defer mapError(&err)
+//
+// Anything not declared in the IDL would reach the client as a generic
+// TApplicationException.
+func mapError(err *error) {
+	if *err == nil {
+		return
+	}
+
+	switch e := (*err).(type) {
+	case *ongrid2.UserException, *ongrid2.NotFoundException, *ongrid2.InvalidOperation, *ongrid2.IntergridException, *ongrid2.InvalidTransition:
+		return
+	default:
+		switch e {
+		case sql.ErrNoRows, mgo.ErrNotFound, ErrQueryNotFound:
+			*err = notFoundError(e.Error())
+		case ErrSessionNotFound:
+			*err = userError(ongrid2.ErrorCode_INVALID_AUTH, e.Error())
+		case ErrSessionExpired:
+			*err = userError(ongrid2.ErrorCode_AUTH_EXPIRED, e.Error())
+		case ErrPermissionDenied:
+			*err = userError(ongrid2.ErrorCode_PERMISSION_DENIED, e.Error())
+		case context.DeadlineExceeded, context.Canceled:
+			*err = queryError(e)
+		default:
+			log.Printf("mapError: %v", e)
+			*err = userError(ongrid2.ErrorCode_UNKNOWN, e.Error())
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/mgo.v2"
+)
+
+// maxEventsBatch is the default and the largest number of events one
+// GetEvents call returns
+const maxEventsBatch = 500
+
+// Event waits: the longest wait, and how often the database is checked for
+// events posted by other servers while waiting
+const (
+	maxWaitEvents  = 2 * time.Minute
+	waitEventsPoll = 5 * time.Second
+)
+
+// eventsFromCursor as lastId resumes GetEvents from the cursor of the workplace
+const eventsFromCursor = -1
+
+// eventCursor returns the id of the last event acknowledged by the workplace
+// of the session
+func (p *OngridHandler) eventCursor(session *Session) (int64, error) {
+	if session.macAddr == "" {
+		return 0, userError(ongrid2.ErrorCode_DATA_INCORRECT, "Session is not bound to a workplace")
+	}
+	mongo, err := p.conns.Mongo()
+	if err != nil {
+		return 0, err
+	}
+
+	last, err := mongo.GetEventCursor(session.user.ID, session.macAddr)
+	if err == mgo.ErrNotFound {
+		return 0, notFoundError("Workplace not found")
+	}
+	return last, err
+}
+
+// resolveLast returns last, or the cursor of the workplace for eventsFromCursor
+func (p *OngridHandler) resolveLast(session *Session, last int64) (int64, error) {
+	if last != eventsFromCursor {
+		return last, nil
+	}
+	return p.eventCursor(session)
+}
+
+// eventQuery selects events of igo$events
+type eventQuery struct {
+	last  int64
+	types []int
+	limit int
+}
+
+func newEventQuery(last int64, filter *ongrid2.EventFilter) eventQuery {
+	q := eventQuery{last: last, limit: maxEventsBatch}
+	if filter == nil {
+		return q
+	}
+	for _, t := range filter.GetTypes() {
+		q.types = append(q.types, int(t))
+	}
+	if n := int(filter.GetMaxCount()); n > 0 && n < maxEventsBatch {
+		q.limit = n
+	}
+	return q
+}
+
+// loadEvents returns the events of the session database selected by q. The
+// messages of MESSAGE events and the requests of REQUEST events are loaded with
+// one query each, other events have no object. An event whose object is
+// missing is returned without it, so the client can move on.
+func (p *OngridHandler) loadEvents(session *Session, q eventQuery) ([]*ongrid2.Event, error) {
+	ctx, done := session.queryContext()
+	defer done()
+
+	query := fmt.Sprintf("select first %d id, type, objectid, created_at from igo$events where id > ?", q.limit)
+	args := []interface{}{q.last}
+	if len(q.types) > 0 {
+		var err error
+		query, args, err = sqlx.In(query+" and type in (?)", q.last, q.types)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var dbEvents []DBEvent
+	err := session.dbData.SelectContext(ctx, &dbEvents, query+" order by id", args...)
+	if err != nil {
+		log.Printf("loadEvents, select from igo$events error: %v", err)
+		return nil, sqlError(err)
+	}
+	if len(dbEvents) == 0 {
+		return nil, nil
+	}
+
+	var messageIDs, requestIDs []int
+	for _, dbEvent := range dbEvents {
+		switch ongrid2.EventType(dbEvent.EventType) {
+		case ongrid2.EventType_MESSAGE:
+			messageIDs = append(messageIDs, dbEvent.ObjectID)
+		case ongrid2.EventType_REQUEST:
+			requestIDs = append(requestIDs, dbEvent.ObjectID)
+		}
+	}
+
+	messages, err := getMessages(ctx, session.dbData, messageIDs)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	var requests map[int]*ongrid2.Request
+	if len(requestIDs) > 0 {
+		db, err := p.conns.SystemDB()
+		if err != nil {
+			return nil, err
+		}
+		if requests, err = getRequests(ctx, db, requestIDs); err != nil {
+			return nil, sqlError(err)
+		}
+	}
+
+	events := make([]*ongrid2.Event, 0, len(dbEvents))
+	for _, dbEvent := range dbEvents {
+		event := &ongrid2.Event{ID: int64(dbEvent.ID), Type: ongrid2.EventType(dbEvent.EventType)}
+		switch event.Type {
+		case ongrid2.EventType_MESSAGE:
+			if event.Message = messages[dbEvent.ObjectID]; event.Message == nil {
+				log.Printf("loadEvents: message %d of event %d not found", dbEvent.ObjectID, dbEvent.ID)
+			}
+		case ongrid2.EventType_REQUEST:
+			if event.Request = requests[dbEvent.ObjectID]; event.Request == nil {
+				log.Printf("loadEvents: request %d of event %d not found", dbEvent.ObjectID, dbEvent.ID)
+			}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// waitEvents returns the events selected by q, when there are none it waits
+// for them up to timeout. A message published to the channels of the user
+// wakes the wait up, events of other servers are polled every waitEventsPoll.
+func (p *OngridHandler) waitEvents(session *Session, q eventQuery, timeout time.Duration) ([]*ongrid2.Event, error) {
+	if timeout > maxWaitEvents {
+		timeout = maxWaitEvents
+	}
+
+	// subscribe before the first check, so an event posted in between wakes us up
+	sub := p.hub.Subscribe(userChannels(session.user)...)
+	defer sub.Unsubscribe()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(waitEventsPoll)
+	defer poll.Stop()
+
+	for {
+		events, err := p.loadEvents(session, q)
+		if err != nil || len(events) > 0 {
+			return events, err
+		}
+
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				return nil, nil
+			}
+		case <-poll.C:
+		case <-deadline.C:
+			return nil, nil
+		}
+	}
+}
+
+// getMessages returns the messages of igo$messages with attachments by id
+func getMessages(ctx context.Context, db *sqlx.DB, ids []int) (map[int]*ongrid2.Message, error) {
+	messages := make(map[int]*ongrid2.Message, len(ids))
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	query, args, err := sqlx.In("select * from igo$messages where id in (?)", ids)
+	if err != nil {
+		return nil, err
+	}
+	var dbMessages []DBMessage
+	if err = db.SelectContext(ctx, &dbMessages, query, args...); err != nil {
+		log.Printf("getMessages, select from igo$messages error: %v", err)
+		return nil, err
+	}
+
+	var attached []int
+	for _, dbMsg := range dbMessages {
+		messages[dbMsg.ID] = messageFromDB(dbMsg)
+		if dbMsg.Attach > 0 {
+			attached = append(attached, dbMsg.ID)
+		}
+	}
+	if len(attached) == 0 {
+		return messages, nil
+	}
+
+	query, args, err = sqlx.In("select * from igo$attachments where messageid in (?)", attached)
+	if err != nil {
+		return nil, err
+	}
+	var dbAttaches []DBAttach
+	if err = db.SelectContext(ctx, &dbAttaches, query, args...); err != nil {
+		log.Printf("getMessages, select from igo$attachments error: %v", err)
+		return nil, err
+	}
+	for _, dbAttach := range dbAttaches {
+		if msg := messages[dbAttach.MessageID]; msg != nil {
+			msg.Attachments = append(msg.Attachments, &ongrid2.FileAttach{
+				OriginalFilename: dbAttach.OriginalFilename,
+				Filename:         dbAttach.Filename,
+			})
+		}
+	}
+	return messages, nil
+}
+
+// getRequests returns the requests of sys$requests by id
+func getRequests(ctx context.Context, db *sqlx.DB, ids []int) (map[int]*ongrid2.Request, error) {
+	requests := make(map[int]*ongrid2.Request, len(ids))
+	if len(ids) == 0 {
+		return requests, nil
+	}
+
+	query, args, err := sqlx.In("select * from sys$requests where id in (?)", ids)
+	if err != nil {
+		return nil, err
+	}
+	var dbRequests []DBRequest
+	if err = db.SelectContext(ctx, &dbRequests, query, args...); err != nil {
+		log.Printf("getRequests, select from sys$requests error: %v", err)
+		return nil, err
+	}
+	list, err := requestsFromDB(ctx, db, dbRequests)
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range list {
+		requests[int(request.ID)] = request
+	}
+	return requests, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"ongrid-thrift/ongrid2"
+	"strconv"
+	"time"
+)
+
+/*
+	Firebird -> FieldType
+	SMALLINT, INTEGER          - INTEGER
+	BIGINT                     - BIGINT
+	NUMERIC, DECIMAL           - DECIMAL, stringValue "123.45", precision - digits after the point
+	FLOAT, DOUBLE PRECISION    - DOUBLE
+	CHAR, VARCHAR              - STRING, OCTETS charset - blobValue
+	TIMESTAMP                  - DATETIME, unix time in seconds
+	DATE                       - DATE, unix time in seconds of the midnight UTC
+	TIME                       - TIME, seconds since midnight
+	BOOLEAN                    - BOOLEAN
+	BLOB                       - BLOB
+	NULL of any type           - isNull = true, no value is set
+*/
+
+// columnType returns the FieldType and the decimal scale of a result column
+func columnType(cType *sql.ColumnType) (ongrid2.FieldType, int32) {
+	if _, scale, ok := cType.DecimalSize(); ok && scale != 0 {
+		return ongrid2.FieldType_DECIMAL, int32(-scale)
+	}
+
+	switch cType.DatabaseTypeName() {
+	case "SHORT", "LONG":
+		return ongrid2.FieldType_INTEGER, 0
+	case "INT64", "QUAD":
+		return ongrid2.FieldType_BIGINT, 0
+	case "FLOAT", "DOUBLE", "D_FLOAT":
+		return ongrid2.FieldType_DOUBLE, 0
+	case "TIMESTAMP":
+		return ongrid2.FieldType_DATETIME, 0
+	case "DATE":
+		return ongrid2.FieldType_DATE, 0
+	case "TIME":
+		return ongrid2.FieldType_TIME, 0
+	case "BOOLEAN":
+		return ongrid2.FieldType_BOOLEAN, 0
+	case "BLOB":
+		return ongrid2.FieldType_BLOB, 0
+	}
+	// TEXT has no type name in the driver
+	return ongrid2.FieldType_STRING, 0
+}
+
+// fieldValue converts a value scanned from the column into DataField
+func fieldValue(column *ongrid2.ColumnMetadata, val interface{}) *ongrid2.DataField {
+	dataField := ongrid2.DataField{}
+
+	if val == nil {
+		isNull := true
+		dataField.IsNull = &isNull
+		return &dataField
+	}
+
+	switch valT := val.(type) {
+	case int16:
+		IntVal := int64(valT)
+		dataField.IntegerValue = &IntVal
+	case int32:
+		IntVal := int64(valT)
+		dataField.IntegerValue = &IntVal
+	case int64:
+		if column.Type == ongrid2.FieldType_DECIMAL {
+			// a positive scale is returned as a scaled integer
+			StrVal := strconv.FormatInt(valT, 10)
+			dataField.StringValue = &StrVal
+		} else {
+			IntVal := valT
+			dataField.IntegerValue = &IntVal
+		}
+	case float32:
+		DoubleVal := float64(valT)
+		dataField.DoubleValue = &DoubleVal
+	case float64:
+		if column.Type == ongrid2.FieldType_DECIMAL {
+			StrVal := strconv.FormatFloat(valT, 'f', int(column.Precision), 64)
+			dataField.StringValue = &StrVal
+		} else {
+			DoubleVal := valT
+			dataField.DoubleValue = &DoubleVal
+		}
+	case string:
+		StrVal := valT
+		dataField.StringValue = &StrVal
+	case bool:
+		BoolVal := valT
+		dataField.BoolValue = &BoolVal
+	case time.Time:
+		var TimeVal int64
+		if column.Type == ongrid2.FieldType_TIME {
+			TimeVal = int64(valT.Hour()*3600 + valT.Minute()*60 + valT.Second())
+		} else {
+			TimeVal = valT.Unix()
+		}
+		dataField.DatetimeValue = &TimeVal
+	case []uint8:
+		BlobVal := valT
+		dataField.BlobValue = BlobVal
+	}
+
+	return &dataField
+}
+
+// paramValue converts a query parameter into a value for the driver, it is
+// the reverse of fieldValue. A parameter without a value is bound as NULL.
+func paramValue(param *ongrid2.Parameter) interface{} {
+	if param.IsSetIsNull() && param.GetIsNull() {
+		return nil
+	}
+
+	switch param.Type {
+	case ongrid2.FieldType_INTEGER:
+		if param.IsSetIntegerValue() {
+			return param.GetIntegerValue()
+		}
+	case ongrid2.FieldType_BIGINT:
+		// the driver binds int64 as a 32 bit integer, a string keeps the value
+		if param.IsSetIntegerValue() {
+			return strconv.FormatInt(param.GetIntegerValue(), 10)
+		}
+	case ongrid2.FieldType_DECIMAL:
+		if param.IsSetStringValue() {
+			return param.GetStringValue()
+		}
+	case ongrid2.FieldType_DOUBLE:
+		if param.IsSetDoubleValue() {
+			return param.GetDoubleValue()
+		}
+	case ongrid2.FieldType_STRING:
+		if param.IsSetStringValue() {
+			return param.GetStringValue()
+		}
+	case ongrid2.FieldType_BOOLEAN:
+		if param.IsSetBoolValue() {
+			return param.GetBoolValue()
+		}
+	case ongrid2.FieldType_DATETIME, ongrid2.FieldType_DATE:
+		if param.IsSetDatetimeValue() {
+			return time.Unix(param.GetDatetimeValue(), 0).UTC()
+		}
+	case ongrid2.FieldType_TIME:
+		if param.IsSetDatetimeValue() {
+			// year 0 makes the driver bind the value as TIME
+			return time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(param.GetDatetimeValue()) * time.Second)
+		}
+	case ongrid2.FieldType_BLOB:
+		if param.IsSetBlobValue() {
+			return param.GetBlobValue()
+		}
+	}
+	return nil
+}
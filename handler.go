@@ -1,43 +1,29 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"database/sql"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"ongrid-thrift/ongrid2"
 	"ongrid-thrift/privileges"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
-	"github.com/centrifugal/gocent"
 	"github.com/jmoiron/sqlx"
-	"github.com/kylelemons/go-gypsy/yaml"
 	_ "github.com/nakagami/firebirdsql"
 	"github.com/sethvargo/go-password/password"
 	"github.com/twinj/uuid"
 	"gopkg.in/gomail.v2"
+	"gopkg.in/mgo.v2"
 )
 
-type CentrifugoConfig struct {
-	host   string
-	port   string
-	secret string
-}
-
-// DBConfig contains configuration for Firebird db
-type DBConfig struct {
-	user     string
-	password string
-	host     string
-	port     string
-	path     string
-}
-
 // Database config for User struct
 type Database struct {
 	host     string
@@ -58,70 +44,97 @@ type User struct {
 	LastName  string
 	Email     string
 	DB        Database
+	CompanyID int64 // sys$clients id of the company, 0 if not bound
 }
 
 // Session contains client session data
 type Session struct {
-	token         string
+	id            string
+	tokenHash     string
 	user          *User
+	macAddr       string // workplace of the session, empty for login by password
 	queries       map[string][]ongrid2.Query
 	transactionID int
 	dbData        *sqlx.DB
 	dbConfig      *sqlx.DB
 	config        *ongrid2.ConfigObject
+	transactions  map[string]*Transaction
+	cursors       map[string]*Cursor
+	running       map[string]*runningQuery
+	stmts         *stmtCache
+	cfg           *Config
+	appUserID     int
+	policy        *privileges.SQLPolicy
+	acl           *privileges.ACLService
+
+	mu        sync.Mutex
+	createdAt time.Time
+	lastSeen  time.Time
 }
 
-// Sessions is session array
-type Sessions map[string]*Session
-
-var sessions Sessions
-var dbOnGrid *sqlx.DB
-var dbConfig DBConfig
-var mgoConfig MongoConfig
-var cConfig CentrifugoConfig
-var mongoConnection *MongoConnection
-
-func init() {
-	config, err := yaml.ReadFile("config/ongrid.conf")
-	if err != nil {
-		log.Fatalln(err)
+// close cancels running queries, closes cursors, rolls back open transactions, drops the statement
+// cache and closes the client databases owned by the session
+func (s *Session) close() {
+	s.cancelQueries()
+	s.closeCursors()
+	s.rollbackTransactions(time.Now(), false)
+	if s.stmts != nil {
+		s.logStmtCacheStats()
+		s.stmts.close()
 	}
-	log.Println("Config file readed..")
-
-	dbConfig.user, _ = config.Get("dbuser")
-	dbConfig.password, _ = config.Get("dbpass")
-	dbConfig.host, _ = config.Get("dbhost")
-	dbConfig.port, _ = config.Get("dbport")
-	dbConfig.path, _ = config.Get("dbpath")
+	if s.dbData != nil {
+		s.dbData.Close()
+	}
+	if s.dbConfig != nil {
+		s.dbConfig.Close()
+	}
+}
 
-	mgoConfig.user, _ = config.Get("mgouser")
-	mgoConfig.passowrd, _ = config.Get("mgopass")
-	mgoConfig.host, _ = config.Get("mgohost")
-	mgoConfig.dbName, _ = config.Get("mgodb")
+// touch marks the session as used now
+func (s *Session) touch(now time.Time) {
+	s.mu.Lock()
+	s.lastSeen = now
+	s.mu.Unlock()
+}
 
-	cConfig.host, _ = config.Get("chost")
-	cConfig.port, _ = config.Get("cport")
-	cConfig.secret, _ = config.Get("ckey")
+// expired reports whether the session outlived the sessionttl or was idle
+// longer than the sessionidle config keys. A zero ttl disables the check.
+func (s *Session) expired(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	sessions = make(Sessions)
+	if s.cfg.SessionTTL > 0 && now.Sub(s.createdAt) > s.cfg.SessionTTL {
+		return true
+	}
+	if s.cfg.SessionIdle > 0 && now.Sub(s.lastSeen) > s.cfg.SessionIdle {
+		return true
+	}
+	return false
 }
 
 // DBHandler ...
 type DBHandler struct {
+	cfg      *Config
+	sessions SessionStore
 }
 
 // NewDBHandler ...
-func NewDBHandler() *DBHandler {
-	return &DBHandler{}
+func NewDBHandler(cfg *Config, sessions SessionStore) *DBHandler {
+	return &DBHandler{cfg: cfg, sessions: sessions}
 }
 
 // OngridHandler ...
 type OngridHandler struct {
+	conns    *ConnectionManager
+	cfg      *Config
+	sessions SessionStore
+	hub      *LocalPublisher
+	outbox   *OutboxDispatcher
 }
 
 // NewOngridHandler ...
-func NewOngridHandler() *OngridHandler {
-	return &OngridHandler{}
+func NewOngridHandler(conns *ConnectionManager, cfg *Config, sessions SessionStore, hub *LocalPublisher, outbox *OutboxDispatcher) *OngridHandler {
+	return &OngridHandler{conns: conns, cfg: cfg, sessions: sessions, hub: hub, outbox: outbox}
 }
 
 // Ping ...
@@ -132,86 +145,81 @@ func (p *OngridHandler) Ping() (err error) {
 
 // Connect - авторизация в системе по мак адресу
 func (p *OngridHandler) Connect(login string, macAddr string) (token string, err error) {
-	dbOnGrid, err = sqlx.Connect("firebirdsql", dbConfig.user+":"+dbConfig.password+"@"+dbConfig.host+":"+dbConfig.port+"/"+dbConfig.path)
+	defer mapError(&err)
+
+	mongo, err := p.conns.Mongo()
 	if err != nil {
-		log.Fatalln("Connect: ", err)
+		log.Printf("Connect: %v", err)
 		return "", err
 	}
-	log.Println("Connect: System firebird database connection established")
-
-	mongoConnection = NewMongoConnection(mgoConfig)
 
-	token, err = authMac(login, macAddr)
+	token, err = p.authMac(mongo, login, macAddr)
 	if err != nil {
-		dbOnGrid.Close()
-		mongoConnection.CloseConnection()
-		log.Println("Connect: Unknown macaddress. Database connection closed")
+		log.Println("Connect: Unknown macaddress")
 		return
 	}
-	log.Printf("Auth.. Token = %s", token)
+	log.Println("Connect: Session started")
 
 	return
 }
 
 // AddWorkPlace добавляет новое рабочее место в таблицу sys$workplaces
 func (p *OngridHandler) AddWorkPlace(wpName, macAddr, login, password string) (token string, err error) {
+	defer mapError(&err)
+
 	var user *User
-	dbOnGrid, err = sqlx.Connect("firebirdsql", dbConfig.user+":"+dbConfig.password+"@"+dbConfig.host+":"+dbConfig.port+"/"+dbConfig.path)
+	mongo, err := p.conns.Mongo()
 	if err != nil {
-		log.Fatalln("Connect: ", err)
+		log.Printf("AddWorkPlace: %v", err)
 		return "", err
 	}
-	log.Println("AddWorkPlace: Firebird db connection established")
 
-	mongoConnection = NewMongoConnection(mgoConfig)
-
-	token, user, err = authLP(login, password)
+	token, user, err = p.authLP(mongo, login, password, macAddr)
 	if err != nil {
-		dbOnGrid.Close()
-		mongoConnection.CloseConnection()
-		log.Println("AddWorkPlace: User not found. Database connection closed")
+		log.Println("AddWorkPlace: User not found")
 		return
 	}
 
-	_, err = mongoConnection.GetUserByMacAddr(login, macAddr)
+	_, err = mongo.GetUserByMacAddr(login, macAddr)
 	if err != nil {
 		log.Printf("GetUserByMacAddr: %v\n", err)
-		if err.Error() == "Workplace is disabled" {
-			return "", errors.New("Workplace already exists")
+		if err == ErrWorkplaceDisabled {
+			return "", userError(ongrid2.ErrorCode_DATA_INCORRECT, "Workplace already exists")
 		}
 	}
-	err = mongoConnection.ClientAddWorkPlace(user.ID, wpName, macAddr)
+	err = mongo.ClientAddWorkPlace(user.ID, wpName, macAddr)
 	if err != nil {
 		return "", err
 	}
 
-	log.Printf("AddWorkPlace: Auth.. Token = %s", token)
+	log.Println("AddWorkPlace: Session started")
 
 	return
 }
 
 // Disconnect выход из системы
-func (p *OngridHandler) Disconnect(authToken string) error {
-	sessionID, err := checkToken(authToken)
+func (p *OngridHandler) Disconnect(authToken string) (err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return err
 	}
 
-	//dbOnGrid.MustExec("update sys$sessions set active = 0, closed_at = ? where token = ? and active = 1", time.Now(), authToken)
-	sessions[sessionID].dbData.Close()
-	sessions[sessionID].dbConfig.Close()
-	dbOnGrid.Close()
-	mongoConnection.CloseConnection()
-
-	delete(sessions, sessionID)
+	if _, err = p.sessions.Remove(session.id); err != nil {
+		return err
+	}
+	session.close()
 
-	log.Println("Logout: Databases connections closed")
+	log.Println("Logout: Session databases connections closed")
 
 	return nil
 }
 
 // Login ...
 func (p *OngridHandler) Login(login, password string) (userid int64, err error) {
+	defer mapError(&err)
+
 	userid = 1
 	return
 }
@@ -219,367 +227,702 @@ func (p *OngridHandler) Login(login, password string) (userid int64, err error)
 /* SQL function */
 
 // ExecuteSelectQuery выполняет sql запрос и возвращает результат
-func (p *DBHandler) ExecuteSelectQuery(authToken string, query *ongrid2.Query) (*ongrid2.DataRowSet, error) {
-	sessionID, err := checkToken(authToken)
+func (p *DBHandler) ExecuteSelectQuery(authToken string, query *ongrid2.Query) (_ *ongrid2.DataRowSet, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return nil, err
 	}
 
+	return selectQuery(session, nil, query)
+}
+
+// selectQuery выполняет select на БД сессии или в транзакции tx и возвращает результат
+func selectQuery(session *Session, tx *sqlx.Tx, query *ongrid2.Query) (*ongrid2.DataRowSet, error) {
 	var dataRowSet ongrid2.DataRowSet
 
 	start := time.Now()
 
-	rows, err := sessions[sessionID].dbData.NamedQuery(query.Sql, getParams(query))
+	ctx, done := session.queryContext(query.GetID())
+	defer done()
+
+	rows, err := session.namedQuery(ctx, tx, query)
 	if err != nil {
-		return nil, fmt.Errorf("ExecuteSelectQuery error: %v", err)
+		log.Printf("ExecuteSelectQuery error: %v", err)
+		return nil, sqlError(err)
 	}
 	defer rows.Close()
 
-	columnTypes, err := rows.ColumnTypes()
+	dataRowSet.Columns, err = columnsMetadata(rows)
 	if err != nil {
-		return nil, fmt.Errorf("rows.ColumnTypes error: %v", err)
-	}
-
-	for _, cType := range columnTypes {
-		colomnMetadata := ongrid2.ColumnMetadata{}
-		colomnMetadata.Name = cType.Name()
-		switch cType.ScanType().String() {
-		case "int16":
-			colomnMetadata.Type = ongrid2.FieldType_INTEGER
-		case "int32":
-			colomnMetadata.Type = ongrid2.FieldType_INTEGER
-		case "string":
-			colomnMetadata.Type = ongrid2.FieldType_STRING
-		case "float64":
-			colomnMetadata.Type = ongrid2.FieldType_DOUBLE
-		case "time.Time":
-			colomnMetadata.Type = ongrid2.FieldType_DATETIME
-		case "[]uint8":
-			colomnMetadata.Type = ongrid2.FieldType_BLOB
-		}
-		if length, ok := cType.Length(); ok {
-			colomnMetadata.Length = int32(length)
-		}
-		log.Printf("Field name: %s, type: %s, scan type: %v\n", cType.Name(), cType.DatabaseTypeName(), cType.ScanType().String())
-		dataRowSet.Columns = append(dataRowSet.Columns, &colomnMetadata)
+		return nil, err
 	}
 
 	var rowsCount int
 
 	for rows.Next() {
-		dataRow := ongrid2.DataRow{}
-		columnValues, err := rows.SliceScan()
-		if err != nil {
-			return nil, fmt.Errorf("rows.Scan: %v", err)
+		if limit := session.cfg.SQLRowLimit; limit > 0 && rowsCount >= limit {
+			log.Printf("ExecuteSelectQuery: row limit %d exceeded, session %s: %s", limit, session.id, query.Sql)
+			return nil, userError(ongrid2.ErrorCode_DATA_INCORRECT, fmt.Sprintf("Row limit %d exceeded, use OpenCursor", limit))
 		}
-		for _, val := range columnValues {
-			dataField := ongrid2.DataField{}
-
-			switch valT := val.(type) {
-			case int16:
-				IntVal := int64(valT)
-				dataField.IntegerValue = &IntVal
-			case int32:
-				IntVal := int64(valT)
-				dataField.IntegerValue = &IntVal
-			case string:
-				StrVal := fmt.Sprint(valT)
-				dataField.StringValue = &StrVal
-			case float64:
-				DoubleVal := float64(valT)
-				dataField.DoubleValue = &DoubleVal
-			case time.Time:
-				TimeVal := valT.Unix()
-				dataField.DatetimeValue = &TimeVal
-			case []uint8:
-				BlobVal := valT
-				dataField.BlobValue = BlobVal
-			}
-
-			dataRow.Fields = append(dataRow.Fields, &dataField)
+		dataRow, err := scanDataRow(rows, dataRowSet.Columns)
+		if err != nil {
+			return nil, err
 		}
 
-		dataRowSet.Rows = append(dataRowSet.Rows, &dataRow)
+		dataRowSet.Rows = append(dataRowSet.Rows, dataRow)
 
 		rowsCount++
 	}
+	if err = rows.Err(); err != nil {
+		log.Printf("rows.Next: %v", err)
+		return nil, sqlError(err)
+	}
 
 	log.Printf("ExecuteSelectQuery complete, selected %d rows, %.2fs elapsed\n", rowsCount, time.Since(start).Seconds())
 
 	return &dataRowSet, nil
 }
 
+// columnsMetadata возвращает метаданные столбцов результата запроса
+func columnsMetadata(rows *sqlx.Rows) ([]*ongrid2.ColumnMetadata, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		log.Printf("rows.ColumnTypes error: %v", err)
+		return nil, sqlError(err)
+	}
+
+	var columns []*ongrid2.ColumnMetadata
+
+	for _, cType := range columnTypes {
+		colomnMetadata := ongrid2.ColumnMetadata{}
+		colomnMetadata.Name = cType.Name()
+		colomnMetadata.Type, colomnMetadata.Precision = columnType(cType)
+		if length, ok := cType.Length(); ok {
+			colomnMetadata.Length = int32(length)
+		}
+		log.Printf("Field name: %s, type: %s, scan type: %v\n", cType.Name(), cType.DatabaseTypeName(), cType.ScanType().String())
+		columns = append(columns, &colomnMetadata)
+	}
+
+	return columns, nil
+}
+
+// scanDataRow читает текущую строку результата запроса
+func scanDataRow(rows *sqlx.Rows, columns []*ongrid2.ColumnMetadata) (*ongrid2.DataRow, error) {
+	dataRow := ongrid2.DataRow{}
+	columnValues, err := rows.SliceScan()
+	if err != nil {
+		log.Printf("rows.Scan: %v", err)
+		return nil, sqlError(err)
+	}
+	for i, val := range columnValues {
+		dataRow.Fields = append(dataRow.Fields, fieldValue(columns[i], val))
+	}
+
+	return &dataRow, nil
+}
+
 // ExecuteNonSelectQuery аналог ExecSQL, не возвращает результата запроса
-func (p *DBHandler) ExecuteNonSelectQuery(authToken string, query *ongrid2.Query) error {
-	sessionID, err := checkToken(authToken)
+func (p *DBHandler) ExecuteNonSelectQuery(authToken string, query *ongrid2.Query) (err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return err
 	}
 
-	_, err = sessions[sessionID].dbData.NamedExec(query.Sql, getParams(query))
+	ctx, done := session.queryContext(query.GetID())
+	defer done()
+
+	_, err = session.namedExec(ctx, nil, query)
 	if err != nil {
 		log.Printf("ExecuteNonSelectQuery error: %v", err)
+		return sqlError(err)
 	}
-	return err
+	return nil
 }
 
 // StartBatchExecution возвращяет новый batchId
-func (p *DBHandler) StartBatchExecution(authToken string) (string, error) {
-	sessionID, err := checkToken(authToken)
+func (p *DBHandler) StartBatchExecution(authToken string) (_ string, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return "", err
 	}
-	sessions[sessionID].transactionID++
-	return string(sessions[sessionID].transactionID), nil
+	return session.nextID(), nil
 }
 
 // AddQuery добавляет запрос в map queries с определенным batchId
-func (p *DBHandler) AddQuery(authToken string, batchID string, query *ongrid2.Query) error {
-	sessionID, err := checkToken(authToken)
+func (p *DBHandler) AddQuery(authToken string, batchID string, query *ongrid2.Query) (err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return err
 	}
-	sessions[sessionID].queries[batchID] = append(sessions[sessionID].queries[batchID], *query)
+	session.mu.Lock()
+	session.queries[batchID] = append(session.queries[batchID], *query)
+	session.mu.Unlock()
 	return nil
 }
 
 // FinishBatchExecution выполняет все запросы из map queries с определенным batchId
-func (p *DBHandler) FinishBatchExecution(authToken string, batchID string, condition *ongrid2.Query, onSuccess *ongrid2.Query) (string, error) {
-	sessionID, err := checkToken(authToken)
+func (p *DBHandler) FinishBatchExecution(authToken string, batchID string, condition *ongrid2.Query, onSuccess *ongrid2.Query) (_ string, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return "", err
 	}
 
-	tx := sessions[sessionID].dbData.MustBegin()
-	for _, query := range sessions[sessionID].queries[batchID] {
-		_, err := tx.NamedExec(query.Sql, getParams(&query))
-		if err != nil {
-			return "", fmt.Errorf("FinishBatchExecute error: query: %s - %v", query.Sql, err)
-		}
+	session.mu.Lock()
+	buffered := session.queries[batchID]
+	delete(session.queries, batchID)
+	session.mu.Unlock()
+
+	queries := make([]*ongrid2.Query, len(buffered))
+	for i := range buffered {
+		queries[i] = &buffered[i]
 	}
-	tx.Commit()
 
-	_, err = sessions[sessionID].dbData.NamedExec(onSuccess.Sql, getParams(onSuccess))
-	if err != nil {
-		return "", fmt.Errorf("FinishBatchExecute, onSuccess error: query: %s - %v", onSuccess.Sql, err)
+	if err = executeBatch(session, batchID, queries, condition, onSuccess); err != nil {
+		log.Printf("FinishBatchExecution, batch %s: %v", batchID, err)
+		return "", err
 	}
 
 	return "", nil
 }
 
 // BatchExecute выполняет в транзакции все запросы из queries
-func (p *DBHandler) BatchExecute(authToken string, queries []*ongrid2.Query, condition *ongrid2.Query, onSuccess *ongrid2.Query) (string, error) {
-	sessionID, err := checkToken(authToken)
+func (p *DBHandler) BatchExecute(authToken string, queries []*ongrid2.Query, condition *ongrid2.Query, onSuccess *ongrid2.Query) (_ string, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return "", err
 	}
-	tx := sessions[sessionID].dbData.MustBegin()
+
+	if err = executeBatch(session, "", queries, condition, onSuccess); err != nil {
+		log.Printf("BatchExecute: %v", err)
+		return "", err
+	}
+
+	return "", nil
+}
+
+// executeBatch выполняет queries в одной транзакции, затем проверяет condition
+// и выполняет onSuccess в той же транзакции. Если condition не вернул строк
+// или вернул ложное значение, а также при ошибке любого запроса транзакция
+// откатывается. Пустые condition и onSuccess пропускаются. Пакет выполняется
+// с одним таймаутом и отменяется CancelQuery по batchID или id любого запроса.
+func executeBatch(session *Session, batchID string, queries []*ongrid2.Query, condition *ongrid2.Query, onSuccess *ongrid2.Query) (err error) {
+	ids := append(queryIDs(queries...), queryIDs(condition, onSuccess)...)
+	ctx, done := session.queryContext(append(ids, batchID)...)
+	defer done()
+
+	tx, err := session.dbData.BeginTxx(ctx, nil)
+	if err != nil {
+		return sqlError(err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
 	for _, query := range queries {
-		_, err := tx.NamedExec(query.Sql, getParams(query))
+		if _, err = session.namedExec(ctx, tx, query); err != nil {
+			log.Printf("executeBatch error: query: %s - %v", query.Sql, err)
+			return sqlError(err)
+		}
+	}
+
+	if condition != nil && condition.Sql != "" {
+		var ok bool
+		ok, err = checkCondition(ctx, session, tx, condition)
 		if err != nil {
-			return "", fmt.Errorf("BatchExecute error: query: %s - %v", query.Sql, err)
+			log.Printf("executeBatch, condition error: query: %s - %v", condition.Sql, err)
+			return sqlError(err)
+		}
+		if !ok {
+			return userError(ongrid2.ErrorCode_DATA_INCORRECT, "Batch condition failed: "+condition.Sql)
 		}
 	}
-	tx.Commit()
 
-	return "", nil
+	if onSuccess != nil && onSuccess.Sql != "" {
+		if _, err = session.namedExec(ctx, tx, onSuccess); err != nil {
+			log.Printf("executeBatch, onSuccess error: query: %s - %v", onSuccess.Sql, err)
+			return sqlError(err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return sqlError(err)
+	}
+	return nil
+}
 
-	_, err = sessions[sessionID].dbData.NamedExec(onSuccess.Sql, getParams(onSuccess))
+// checkCondition выполняет select условия и возвращает значение первого поля
+// первой строки. Нет строк, null, 0, пустая строка, "0" и "false" - ложь.
+func checkCondition(ctx context.Context, session *Session, tx *sqlx.Tx, condition *ongrid2.Query) (bool, error) {
+	rows, err := session.namedQuery(ctx, tx, condition)
 	if err != nil {
-		return "", fmt.Errorf("BatchExecute, onSuccess error: query: %s - %v", onSuccess.Sql, err)
+		return false, err
 	}
+	defer rows.Close()
 
-	return "", nil
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	values, err := rows.SliceScan()
+	if err != nil || len(values) == 0 {
+		return false, err
+	}
+
+	switch v := values[0].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case int16:
+		return v != 0, nil
+	case int32:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case float32:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		return isTrueString(v), nil
+	case []byte:
+		return isTrueString(string(v)), nil
+	}
+	return true, nil
 }
 
-/* Other function */
+func isTrueString(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s != "" && s != "0" && s != "false" && s != "f"
+}
+
+// BeginTransaction открывает транзакцию и возвращает ее id. Транзакция живет
+// между вызовами до Commit или Rollback и откатывается, если не используется
+// дольше txtimeout
+func (p *DBHandler) BeginTransaction(authToken string) (_ string, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return "", err
+	}
+
+	t, err := session.beginTransaction()
+	if err != nil {
+		log.Printf("BeginTransaction error: %v", err)
+		return "", sqlError(err)
+	}
+
+	return t.id, nil
+}
+
+// ExecuteSelectQueryInTransaction выполняет select в открытой транзакции
+func (p *DBHandler) ExecuteSelectQueryInTransaction(authToken string, transactionID string, query *ongrid2.Query) (_ *ongrid2.DataRowSet, err error) {
+	defer mapError(&err)
 
-// GetEvents ...
-func (p *OngridHandler) GetEvents(authToken string, last int64) (events []*ongrid2.Event, err error) {
-	sessionID, err := checkToken(authToken)
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("GetEvents start..")
+	t, err := session.transaction(transactionID)
+	if err != nil {
+		return nil, notFoundError(err.Error())
+	}
+	t.lock()
+	defer t.unlock()
+
+	return selectQuery(session, t.tx, query)
+}
 
-	var rows *sqlx.Rows
+// ExecuteNonSelectQueryInTransaction выполняет update, insert, delete в открытой транзакции
+func (p *DBHandler) ExecuteNonSelectQueryInTransaction(authToken string, transactionID string, query *ongrid2.Query) (err error) {
+	defer mapError(&err)
 
-	rows, err = sessions[sessionID].dbData.Queryx("select * from igo$events where id > ? and type = 4", last)
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
-		log.Printf("GetEvents, select * from igo$events error: %v\n", err)
-		return
+		return err
 	}
-	defer rows.Close()
 
-	var dbEvent DBEvent
-	for rows.Next() {
-		err = rows.StructScan(&dbEvent)
-		if err != nil {
-			log.Printf("GetEvents, StructScan: %v\n", err)
-		}
+	t, err := session.transaction(transactionID)
+	if err != nil {
+		return notFoundError(err.Error())
+	}
+	t.lock()
+	defer t.unlock()
 
-		if dbEvent.EventType == 4 {
-			rowsMsg, err := sessions[sessionID].dbData.Queryx("select * from igo$messages where id = ?", dbEvent.ObjectID)
-			if err != nil {
-				log.Printf("GetEvents: %v\n", err)
-				return nil, err
-			}
-			defer rowsMsg.Close()
+	ctx, done := session.queryContext(query.GetID())
+	defer done()
 
-			var dbMsg DBMessage
+	_, err = session.namedExec(ctx, t.tx, query)
+	if err != nil {
+		log.Printf("ExecuteNonSelectQueryInTransaction error: %v", err)
+		return sqlError(err)
+	}
+	return nil
+}
 
-			for rowsMsg.Next() {
-				err = rowsMsg.StructScan(&dbMsg)
-				if err != nil {
-					log.Printf("msg scan: %v\n", err)
-					return nil, err
-				}
+// Commit фиксирует транзакцию
+func (p *DBHandler) Commit(authToken string, transactionID string) (err error) {
+	defer mapError(&err)
 
-				event := ongrid2.Event{}
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return err
+	}
 
-				event.ID = int64(dbEvent.ID)
-				event.Type = ongrid2.EventType_MESSAGE
-				event.Message, err = getMessage(sessions[sessionID].dbData, dbEvent.ObjectID)
+	t, err := session.endTransaction(transactionID)
+	if err != nil {
+		return notFoundError(err.Error())
+	}
+	t.lock()
+	defer t.unlock()
 
-				events = append(events, &event)
-			}
-		}
+	if err = t.tx.Commit(); err != nil {
+		log.Printf("Commit error: %v", err)
+		return sqlError(err)
+	}
+	return nil
+}
+
+// Rollback откатывает транзакцию
+func (p *DBHandler) Rollback(authToken string, transactionID string) (err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return err
+	}
+
+	t, err := session.endTransaction(transactionID)
+	if err != nil {
+		return notFoundError(err.Error())
+	}
 
-		// rowsRq, err := dbOnGrid.Queryx("select * from sys$requests where id = ?", dbEvent.ObjectID)
-		// if err != nil {
-		// 	log.Printf("GetEvents: %v\n", err)
-		// 	return nil, err
-		// }
-		// defer rowsRq.Close()
+	if err = t.rollback(); err != nil {
+		log.Printf("Rollback error: %v", err)
+		return sqlError(err)
+	}
+	return nil
+}
 
-		// var dbRequest DBRequest
-		// for rowsRq.Next() {
-		// 	err = rowsRq.StructScan(&dbRequest)
-		// 	if err != nil {
-		// 		log.Printf("GetEvents, StructScan: %v\n", err)
-		// 	}
-		// 	event := ongrid2.Event{}
+// OpenCursor выполняет select и возвращает id курсора и метаданные столбцов.
+// Строки читаются вызовами FetchCursor, курсор закрывается CloseCursor или
+// вместе с сессией
+func (p *DBHandler) OpenCursor(authToken string, query *ongrid2.Query) (_ *ongrid2.Cursor, err error) {
+	defer mapError(&err)
 
-		// 	event.ID = int64(dbEvent.ID)
-		// 	event.Type = 1
-		// event.Request, err = getRequest(dbEvent.ObjectID)
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return nil, err
+	}
 
-		// 	events = append(events, &event)
-		// }
+	c, err := session.openCursor(query)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("events: %v", events)
+	return &ongrid2.Cursor{ID: c.id, Columns: c.columns}, nil
+}
 
-	return
+// FetchCursor возвращает следующие count строк курсора. Если строк вернулось
+// меньше count, курсор прочитан до конца
+func (p *DBHandler) FetchCursor(authToken string, cursorID string, count int32) (_ *ongrid2.DataRowSet, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if count <= 0 || count > maxFetchRows {
+		return nil, userError(ongrid2.ErrorCode_DATA_INCORRECT, fmt.Sprintf("Fetch count must be from 1 to %d", maxFetchRows))
+	}
+
+	c, err := session.cursor(cursorID)
+	if err != nil {
+		return nil, notFoundError(err.Error())
+	}
+
+	return c.fetch(int(count))
+}
+
+// CloseCursor закрывает курсор
+func (p *DBHandler) CloseCursor(authToken string, cursorID string) (err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return err
+	}
+
+	err = session.closeCursor(cursorID)
+	if err == ErrCursorNotFound {
+		return notFoundError(err.Error())
+	}
+	if err != nil {
+		return sqlError(err)
+	}
+	return nil
+}
+
+// GetStatementCacheStats возвращает счетчики кеша подготовленных запросов сессии
+func (p *DBHandler) GetStatementCacheStats(authToken string) (_ *ongrid2.StatementCacheStats, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.stmts == nil {
+		return &ongrid2.StatementCacheStats{}, nil
+	}
+	return session.stmts.stats(), nil
+}
+
+// CancelQuery отменяет выполняющийся запрос, пакет или курсор по id из Query.
+// Отмененный вызов возвращает UserException с кодом QUERY_CANCELED
+func (p *DBHandler) CancelQuery(authToken string, queryID string) (err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return err
+	}
+
+	if err = session.cancelQuery(queryID); err != nil {
+		return notFoundError(err.Error())
+	}
+	log.Printf("CancelQuery: session %s, query %s", session.id, queryID)
+	return nil
+}
+
+/* Other function */
+
+// GetEvents возвращает события с id > lastId, отфильтрованные filter. Если
+// событий нет и filter.waitMs > 0, ждет новых событий не дольше waitMs.
+// lastId = -1 продолжает с курсора рабочего места (см. AckEvents).
+func (p *OngridHandler) GetEvents(authToken string, last int64, filter *ongrid2.EventFilter) (events []*ongrid2.Event, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return nil, err
+	}
+	if last, err = p.resolveLast(session, last); err != nil {
+		return nil, err
+	}
+
+	q := newEventQuery(last, filter)
+	if filter != nil && filter.GetWaitMs() > 0 {
+		wait := filter.GetWaitMs()
+		return p.waitEvents(session, q, time.Duration(wait)*time.Millisecond)
+	}
+	return p.loadEvents(session, q)
+}
+
+// WaitEvents ждет событий с id > lastId не дольше timeoutMs миллисекунд,
+// lastId = -1 продолжает с курсора рабочего места
+func (p *OngridHandler) WaitEvents(authToken string, last int64, timeoutMs int32) (events []*ongrid2.Event, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return nil, err
+	}
+	if last, err = p.resolveLast(session, last); err != nil {
+		return nil, err
+	}
+
+	return p.waitEvents(session, newEventQuery(last, nil), time.Duration(timeoutMs)*time.Millisecond)
 }
 
-// PostEvent create or update event in backend
-func (p *OngridHandler) PostEvent(authToken string, event *ongrid2.Event) (string, error) {
-	if _, err := checkToken(authToken); err != nil {
+// AckEvents запоминает, что рабочее место сессии получило события с id <=
+// upToId. Курсор только растет, меньший upToId его не меняет.
+func (p *OngridHandler) AckEvents(authToken string, upToID int64) (err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return err
+	}
+	if session.macAddr == "" {
+		return userError(ongrid2.ErrorCode_DATA_INCORRECT, "Session is not bound to a workplace")
+	}
+	if upToID < 0 {
+		return userError(ongrid2.ErrorCode_DATA_INCORRECT, "Event id must not be negative")
+	}
+
+	mongo, err := p.conns.Mongo()
+	if err != nil {
+		return err
+	}
+	err = mongo.AckEvents(session.user.ID, session.macAddr, upToID)
+	if err == mgo.ErrNotFound {
+		return notFoundError("Workplace not found")
+	}
+	return err
+}
+
+// PostEvent create or update event in backend. Заявки удобнее писать через
+// сервис Requests, PostEvent оставлен для старых клиентов и проверяет те же
+// права: requests.write и компанию сессии.
+func (p *OngridHandler) PostEvent(authToken string, event *ongrid2.Event) (_ string, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return "", err
+	}
+
+	db, err := p.conns.SystemDB()
+	if err != nil {
 		return "", err
 	}
 
 	if event.Type != ongrid2.EventType_REQUEST {
 		return "", nil
 	}
+	if event.Request == nil {
+		return "", userError(ongrid2.ErrorCode_DATA_INCORRECT, "Request is empty")
+	}
 
 	request := event.Request
+	companyID, err := sessionCompany(session, privileges.PermissionRequestsWrite)
+	if err != nil {
+		return "", err
+	}
+	if err = checkCompany(request, companyID); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := callContext(p.cfg.QueryTimeout)
+	defer cancel()
 
-	var objectID int
-	err := dbOnGrid.QueryRowx("select id from sys$requests where id = ?", request.ID).Scan(&objectID)
+	// the request and its event are written in one transaction
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
+		return "", sqlError(err)
+	}
+	defer tx.Rollback()
 
-		} else {
+	var objectID, status int
+	err = tx.QueryRowxContext(ctx, "select id, status from sys$requests where id = ?", request.ID).Scan(&objectID, &status)
+	if err != nil {
+		if err != sql.ErrNoRows {
 			log.Printf("PostEvent, select id from sys$requests error: %v", err)
+			return "", sqlError(err)
+		}
+	}
+	if objectID != 0 {
+		if err = checkRequestCompany(ctx, tx, objectID, companyID, true); err != nil {
 			return "", err
 		}
 	}
 
+	// the status follows the state machine of requests like SetRequestStatus
+	actor := sessionActor(session)
 	if objectID == 0 {
-		err = dbOnGrid.QueryRowx("select gen_id(gen_sys$requests_id, 1) from rdb$database").Scan(&objectID)
-		if err != nil {
-			log.Printf("PostEvent, select gen_id error: %v", err)
+		if err = checkInitialStatus(request.Status); err != nil {
 			return "", err
 		}
-
-		_, err := dbOnGrid.NamedExec("insert into sys$requests (id, userid, company, createddatetime, desireddatetime, desiredtimeperiod, phone, email, description, car, status) "+
-			"values (:id, :user, :company, :createdat, :desired, :desiredperiod, :phone, :email, :descr, :car, :status)",
-			map[string]interface{}{
-				"id":            objectID,
-				"user":          request.User.ID,
-				"company":       request.Company.ID,
-				"createdat":     time.Unix(request.CreatedDateTime, 0),
-				"desired":       time.Unix(request.DesiredDateTime, 0),
-				"desiredperiod": request.DesiredTimePeriod,
-				"phone":         request.Phone,
-				"email":         request.Email,
-				"descr":         request.Description,
-				"car":           request.Car.ID,
-				"status":        request.Status,
-			})
-		if err != nil {
-			log.Printf("PostEvent, insert into sys$requests error: %v", err)
+		if objectID, err = insertRequest(ctx, tx, p.cfg.Location(), request); err != nil {
+			return "", err
+		}
+		if err = addRequestHistory(ctx, tx, objectID, sql.NullInt64{}, request.Status, actor, ""); err != nil {
 			return "", err
 		}
 	} else {
-		_, err = dbOnGrid.NamedExec("update sys$requests set userid = :user, company = :comapny, createddatetime = :createdat, desireddatetime = :desired, "+
-			"desiredtimeperiod = :desiredperiod, phone = :phone, email = :email, description = :descr, car = :car, status = :status where id = :reqid",
-			map[string]interface{}{
-				"user":          request.User.ID,
-				"company":       request.Company.ID,
-				"createdat":     time.Unix(request.CreatedDateTime, 0),
-				"desired":       time.Unix(request.DesiredDateTime, 0),
-				"desiredperiod": request.DesiredTimePeriod,
-				"phone":         request.Phone,
-				"email":         request.Email,
-				"descr":         request.Description,
-				"car":           request.Car.ID,
-				"status":        request.Status,
-				"reqid":         objectID,
-			})
-		if err != nil {
-			log.Printf("PostEvent, update sys$requests error: %v", err)
+		if err = updateRequest(ctx, tx, p.cfg.Location(), objectID, request); err != nil {
 			return "", err
 		}
+		if ongrid2.RequestStatus(status) != request.Status {
+			if err = changeRequestStatus(ctx, tx, p.cfg.Location(), objectID, request.Status, actor, ""); err != nil {
+				return "", err
+			}
+		}
 	}
 
-	var hexUUID string
-	err = dbOnGrid.QueryRowx("select hex_uuid from get_hex_uuid").Scan(&hexUUID)
+	hexUUID, err := postRequestEvent(ctx, tx, objectID)
 	if err != nil {
-		log.Printf("select hex_uuid from get_hex_uuid error: %v", err)
-		return "", err
+		return "", sqlError(err)
 	}
 	log.Printf("New UUID: %s", hexUUID)
 
-	_, err = dbOnGrid.NamedExec("insert into sys$events (id, type, objectid) values (:id, :type, :objid)",
-		map[string]interface{}{
-			"id":    hexUUID,
-			"type":  1,
-			"objid": objectID,
-		})
-	if err != nil {
-		log.Printf("PostEvent, insert into sys$events error: %v", err)
-		return "", err
+	if err = tx.Commit(); err != nil {
+		return "", sqlError(err)
 	}
 
 	return hexUUID, nil
 }
 
-// GetCentrifugoConf return Centrifugo config
-func (p *OngridHandler) GetCentrifugoConf(authToken string) (*ongrid2.CentrifugoConf, error) {
-	if _, err := checkToken(authToken); err != nil {
+// ReplayEvents ставит сообщения outbox с id >= fromId на повторную отправку.
+// Требует права events.replay у пользователя, заданного CheckUser.
+func (p *OngridHandler) ReplayEvents(authToken string, fromID int64) (_ int64, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return 0, err
+	}
+	if !session.hasPermission(privileges.PermissionEventsReplay) {
+		return 0, userError(ongrid2.ErrorCode_PERMISSION_DENIED, "Replay of events is not allowed")
+	}
+
+	ctx, done := session.queryContext()
+	defer done()
+
+	count, err := replayOutbox(ctx, session.dbData, fromID, time.Now())
+	if err != nil {
+		log.Printf("ReplayEvents: %v", err)
+		return 0, sqlError(err)
+	}
+	log.Printf("ReplayEvents: session %s, %d events from %d", session.id, count, fromID)
+	p.outbox.Notify()
+
+	return count, nil
+}
+
+// GetCentrifugoConf возвращает публичный адрес Centrifugo и параметры
+// подключения пользователя, подписанные секретом сервера. Сам секрет клиенту
+// не передается, токен нужно обновить до expiresAt
+func (p *OngridHandler) GetCentrifugoConf(authToken string) (_ *ongrid2.CentrifugoConf, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
 		return nil, err
 	}
 
-	var centrifugoConf ongrid2.CentrifugoConf
+	return centrifugoConf(p.cfg.Centrifugo, session.user, time.Now())
+}
+
+// SignCentrifugoChannels подписывает подписку клиента Centrifugo client на
+// приватные каналы пользователя
+func (p *OngridHandler) SignCentrifugoChannels(authToken string, client string, channels []string) (_ []*ongrid2.ChannelSign, err error) {
+	defer mapError(&err)
 
-	centrifugoConf.Host = "178.128.207.49"
-	centrifugoConf.Port = 8000
-	centrifugoConf.Secret = "secret"
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return nil, err
+	}
 
-	return &centrifugoConf, nil
+	return signChannels(p.cfg.Centrifugo, session.user, client, channels)
 }
 
 /*
@@ -609,30 +952,36 @@ type dbConfigigObject struct {
 }
 
 // GetConfiguration ...
-func (p *OngridHandler) GetConfiguration(authToken string) (*ongrid2.ConfigObject, error) {
-	sessionID, err := checkToken(authToken)
+func (p *OngridHandler) GetConfiguration(authToken string) (_ *ongrid2.ConfigObject, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return nil, err
 	}
 
 	start := time.Now()
 
-	if sessions[sessionID].config != nil {
+	if session.config != nil {
 		log.Printf("GetConfiguration %.2fs elapsed\n", time.Since(start).Seconds())
-		log.Printf("Configuration name: %s, description: %s", sessions[sessionID].config.Name, sessions[sessionID].config.Description)
-		return sessions[sessionID].config, nil
+		log.Printf("Configuration name: %s, description: %s", session.config.Name, session.config.Description)
+		return session.config, nil
 	}
 
 	var Configuration ongrid2.ConfigObject
 	baseObjects := make(map[int]*ongrid2.ConfigObject)
 
-	rows, err := sessions[sessionID].dbConfig.Queryx("select objectid, objecttype, paramtype, objectname, objectparam, paramvalue, addfield, " +
-		"coalesce(objectowner, 0) as objectowner, " +
-		"coalesce(objecttag, 0) as objecttag " +
-		"from igo$objects " +
+	ctx, done := session.queryContext()
+	defer done()
+
+	rows, err := session.dbConfig.QueryxContext(ctx, "select objectid, objecttype, paramtype, objectname, objectparam, paramvalue, addfield, "+
+		"coalesce(objectowner, 0) as objectowner, "+
+		"coalesce(objecttag, 0) as objecttag "+
+		"from igo$objects "+
 		"order by coalesce(objectowner, 0), objecttag, objectname")
 	if err != nil {
 		log.Printf("GetConfiguration error: %v", err)
+		return nil, sqlError(err)
 	}
 
 	log.Println("Configuration loaded")
@@ -726,7 +1075,7 @@ func (p *OngridHandler) GetConfiguration(authToken string) (*ongrid2.ConfigObjec
 	log.Printf("GetConfiguration, object count = %d, %.2fs elapsed\n", objectCount, time.Since(start).Seconds())
 	log.Printf("Configuration name: %s, description: %s", Configuration.Name, Configuration.Description)
 
-	sessions[sessionID].config = &Configuration
+	session.config = &Configuration
 
 	return &Configuration, nil
 }
@@ -747,18 +1096,23 @@ type dbConfigigProp struct {
 
 // GetProps ...
 func (p *OngridHandler) GetProps(authToken string) (props []*ongrid2.ConfigProp, err error) {
-	sessionID, err := checkToken(authToken)
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := sessions[sessionID].dbConfig.Queryx("select id, objecttype, paramtype, proptype, pname, " +
-		"pcaption, ptype, pvalues, pdefault, paction from igo$props where isfolder = 0 " +
+	ctx, done := session.queryContext()
+	defer done()
+
+	rows, err := session.dbConfig.QueryxContext(ctx, "select id, objecttype, paramtype, proptype, pname, "+
+		"pcaption, ptype, pvalues, pdefault, paction from igo$props where isfolder = 0 "+
 		"order by objecttype")
 
 	if err != nil {
 		log.Printf("GetProps error: %v", err)
-		return
+		return nil, sqlError(err)
 	}
 
 	var DBProp dbConfigigProp
@@ -793,14 +1147,16 @@ func (p *OngridHandler) GetProps(authToken string) (props []*ongrid2.ConfigProp,
 }
 
 // GetUserPrivileges ...
-func (p *OngridHandler) GetUserPrivileges(authToken string, userID int64) ([]*ongrid2.Privilege, error) {
-	sessionID, err := checkToken(authToken)
+func (p *OngridHandler) GetUserPrivileges(authToken string, userID int64) (_ []*ongrid2.Privilege, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return nil, err
 	}
 
 	aclService := privileges.ACLService{}
-	aclService.Load(sessions[sessionID].dbConfig)
+	aclService.Load(session.dbConfig)
 
 	privileges, err := aclService.GetACL(int(userID))
 	if err != nil {
@@ -819,15 +1175,20 @@ type dbUser struct {
 
 // GetUsers возвращает всех пользователей из таблицы og$users
 func (p *OngridHandler) GetUsers(authToken string) (users []*ongrid2.User, err error) {
-	sessionID, err := checkToken(authToken)
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := sessions[sessionID].dbConfig.Queryx("select id, login, fullname from og$users")
+	ctx, done := session.queryContext()
+	defer done()
+
+	rows, err := session.dbConfig.QueryxContext(ctx, "select id, login, fullname from og$users")
 	if err != nil {
 		log.Printf("GetUsers error: %v", err)
-		return
+		return nil, sqlError(err)
 	}
 
 	var DBUser dbUser
@@ -852,8 +1213,10 @@ func (p *OngridHandler) GetUsers(authToken string) (users []*ongrid2.User, err e
 }
 
 // RegisterCustomer - create new customer in mongodb, send him email with a login and password
-func (p *OngridHandler) RegisterCustomer(authToken string, email string, name string, phone string) (string, error) {
-	sessionID, err := checkToken(authToken)
+func (p *OngridHandler) RegisterCustomer(authToken string, email string, name string, phone string) (_ string, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return "", err
 	}
@@ -871,49 +1234,78 @@ func (p *OngridHandler) RegisterCustomer(authToken string, email string, name st
 	io.WriteString(h, password)
 	hpass := fmt.Sprintf("%x", h.Sum(nil))
 
-	owner := sessions[sessionID].user.ID
-	customerID, err := mongoConnection.CreateCustomer(owner, name, email, phone, hpass)
+	mongo, err := p.conns.Mongo()
+	if err != nil {
+		return "", err
+	}
+
+	owner := session.user.ID
+	customerID, err := mongo.CreateCustomer(owner, name, email, phone, hpass)
+	if err == ErrDuplicateEmail {
+		return "", userError(ongrid2.ErrorCode_DATA_INCORRECT, err.Error())
+	}
 	if err != nil {
 		return "", err
 	}
 
 	log.Println("Customer created...")
 
+	smtp := p.cfg.SMTP
+	if smtp.host == "" {
+		return customerID, fmt.Errorf("Registration letter not sent: smtphost is not configured")
+	}
+
 	m := gomail.NewMessage()
-	m.SetHeader("From", "verify@ongrid.xyz")
+	m.SetHeader("From", smtp.from)
 	m.SetHeader("To", email)
 	m.SetHeader("Subject", "Customer registration")
-	m.SetBody("text/html", "Hello "+name+"! <br> go to <a href='http://customers.ongrid.xyz'>Customer Service</a><br>Login: "+email+
+	m.SetBody("text/html", "Hello "+name+"! <br> go to <a href='"+p.cfg.CustomersURL+"'>Customer Service</a><br>Login: "+email+
 		"<br>Password: "+password)
-	d := gomail.NewDialer("smtp.yandex.ru", 465, "verify@ongrid.xyz", "ak0srulez")
+	d := gomail.NewDialer(smtp.host, smtp.port, smtp.user, smtp.password)
 	if err = d.DialAndSend(m); err != nil {
 		return customerID, err
 	}
 	return customerID, nil
 }
 
-// CheckUser ...
-func (p *OngridHandler) CheckUser(authToken string, login string, password string) (*ongrid2.User, error) {
-	sessionID, err := checkToken(authToken)
+// CheckUser проверяет пользователя og$users и привязывает его к сессии
+func (p *OngridHandler) CheckUser(authToken string, login string, password string) (_ *ongrid2.User, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return nil, err
 	}
 
 	DBUser := dbUser{}
 
-	err = sessions[sessionID].dbConfig.Get(&DBUser, "select first 1 id, login, fullname, password from og$users where login = ?", login)
+	ctx, done := session.queryContext()
+	defer done()
+
+	err = session.dbConfig.GetContext(ctx, &DBUser, "select first 1 id, login, fullname, password from og$users where login = ?", login)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			err = errors.New("User not found")
-		}
 		log.Printf("CheckUser: %v", err)
-		return nil, err
+		if err == sql.ErrNoRows {
+			return nil, notFoundError("User not found")
+		}
+		return nil, sqlError(err)
 	}
 
 	log.Printf("User: %v", DBUser)
 
 	if DBUser.Password != password {
-		return nil, errors.New("Password incorrect")
+		return nil, userError(ongrid2.ErrorCode_INVALID_AUTH, "Password incorrect")
+	}
+
+	// the sql policy of the session follows the roles of this user
+	if err = session.bindAppUser(DBUser.ID); err != nil {
+		log.Printf("CheckUser, load sql policy: %v", err)
+		return nil, err
+	}
+	// a session restored after a restart binds the same user
+	if err = p.sessions.SetAppUser(session.id, DBUser.ID); err != nil {
+		log.Printf("CheckUser, save the session user: %v", err)
+		return nil, err
 	}
 
 	var user ongrid2.User
@@ -934,39 +1326,60 @@ type CustomerMessage struct {
 }
 
 // SendMessageToAllCustomers ...
-func (p *OngridHandler) SendMessageToAllCustomers(authToken string, body string, attachments []*ongrid2.FileAttach) error {
-	sessionID, err := checkToken(authToken)
+func (p *OngridHandler) SendMessageToAllCustomers(authToken string, body string, attachments []*ongrid2.FileAttach) (err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return err
+	}
+
+	mongo, err := p.conns.Mongo()
 	if err != nil {
 		return err
 	}
 
 	var customers []*User
-	customers, err = mongoConnection.GetCustomersByOwnerID(sessions[sessionID].user.ID)
+	customers, err = mongo.GetCustomersByOwnerID(session.user.ID)
 	if err != nil {
 		return err
 	}
 
+	ctx, done := session.queryContext()
+	defer done()
+
+	// the messages to all customers are sent or none
+	tx, err := session.dbData.BeginTxx(ctx, nil)
+	if err != nil {
+		return sqlError(err)
+	}
+	defer tx.Rollback()
+
 	for _, customer := range customers {
 		msg := CustomerMessage{}
 		msg.customerID = customer.ID
 		msg.body = body
 		msg.attachments = attachments
 
-		_, err = postMessage(sessions[sessionID].dbData, msg)
-		if err != nil {
+		if _, err = postMessage(ctx, tx, session.user.ID, msg); err != nil {
 			log.Printf("postMessage: %v\n", err)
-			return err
+			return sqlError(err)
 		}
+	}
 
-		publishToCentrifugo("web", 0, body)
+	if err = tx.Commit(); err != nil {
+		return sqlError(err)
 	}
+	p.outbox.Notify()
 
 	return nil
 }
 
 // SendMessageToCustomer ...
-func (p *OngridHandler) SendMessageToCustomer(authToken string, customerID string, body string, parentMessageID int64, attachments []*ongrid2.FileAttach) (int64, error) {
-	sessionID, err := checkToken(authToken)
+func (p *OngridHandler) SendMessageToCustomer(authToken string, customerID string, body string, parentMessageID int64, attachments []*ongrid2.FileAttach) (_ int64, err error) {
+	defer mapError(&err)
+
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return -1, err
 	}
@@ -979,9 +1392,23 @@ func (p *OngridHandler) SendMessageToCustomer(authToken string, customerID strin
 
 	var lastID int64
 
-	lastID, err = postMessage(sessions[sessionID].dbData, msg)
+	ctx, done := session.queryContext()
+	defer done()
 
-	publishToCentrifugo("web", lastID, body)
+	tx, err := session.dbData.BeginTxx(ctx, nil)
+	if err != nil {
+		return -1, sqlError(err)
+	}
+	defer tx.Rollback()
+
+	lastID, err = postMessage(ctx, tx, session.user.ID, msg)
+	if err != nil {
+		return -1, sqlError(err)
+	}
+	if err = tx.Commit(); err != nil {
+		return -1, sqlError(err)
+	}
+	p.outbox.Notify()
 
 	return lastID, nil
 }
@@ -993,17 +1420,24 @@ type DBFileName struct {
 
 // GetResourcesList get all filenames of resources from configuration
 func (p *OngridHandler) GetResourcesList(authToken string) (fileNames []*ongrid2.Resource, err error) {
+	defer mapError(&err)
+
 	log.Println("Start GetResourcesList1")
-	sessionID, err := checkToken(authToken)
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
 		return nil, err
 	}
 	log.Println("Start GetResourcesList2")
 	var filename DBFileName
-	rows, err := sessions[sessionID].dbConfig.Queryx("select ParamValue from igo$objects i where i.objecttype = 2 and i.paramvalue is not null " +
+
+	ctx, done := session.queryContext()
+	defer done()
+
+	rows, err := session.dbConfig.QueryxContext(ctx, "select ParamValue from igo$objects i where i.objecttype = 2 and i.paramvalue is not null "+
 		"and i.objectname in (select ii.pname from igo$props ii where ii.proptype = 2 and (ii.ptype = 7 or ii.ptype = 8))")
 	if err != nil {
 		log.Printf("GetResourcesFileNames error: %v\n", err)
+		return nil, sqlError(err)
 	}
 	for rows.Next() {
 		var resource ongrid2.Resource
@@ -1019,36 +1453,27 @@ func (p *OngridHandler) GetResourcesList(authToken string) (fileNames []*ongrid2
 }
 
 // GetUserID ...
-func (p *OngridHandler) GetUserID(authToken string) (string, error) {
-	sessionID, err := checkToken(authToken)
-	if err != nil {
-		return "", err
-	}
-
-	return sessions[sessionID].user.ID, nil
-}
+func (p *OngridHandler) GetUserID(authToken string) (_ string, err error) {
+	defer mapError(&err)
 
-func publishToCentrifugo(channel string, lastID int64, body string) error {
-	c := gocent.NewClient("http://"+cConfig.host+":"+cConfig.port, cConfig.secret, 5*time.Second)
-
-	ok, err := c.Publish(channel, []byte(`{"id": `+strconv.Itoa(int(lastID))+`, "body": "`+body+`"}`))
+	session, err := checkToken(p.sessions, authToken)
 	if err != nil {
-		log.Println(err.Error())
-		return err
+		return "", err
 	}
-	fmt.Printf("Publish into channel %s successful: %v\n", channel, ok)
 
-	return nil
+	return session.user.ID, nil
 }
 
-func postMessage(db *sqlx.DB, msg CustomerMessage) (int64, error) {
+// postMessage writes the message with attachments and its outbox row in the
+// transaction
+func postMessage(ctx context.Context, tx *sqlx.Tx, ownerID string, msg CustomerMessage) (int64, error) {
 	var lastID int64
-	err := db.Get(&lastID, "select gen_id(GEN_IGO$MESSAGES_ID, 1) from rdb$database")
+	err := tx.GetContext(ctx, &lastID, "select gen_id(GEN_IGO$MESSAGES_ID, 1) from rdb$database")
 	if err != nil {
 		log.Printf("select gen_id: %v", err)
 		return -1, err
 	}
-	_, err = db.NamedExec("insert into igo$messages (id, customer, body, parentid, direction, attach)"+
+	_, err = tx.NamedExecContext(ctx, "insert into igo$messages (id, customer, body, parentid, direction, attach)"+
 		" values (:id, :customer, :body, :parentId, :direction, :attach) returning id",
 		map[string]interface{}{
 			"id":        lastID,
@@ -1064,7 +1489,7 @@ func postMessage(db *sqlx.DB, msg CustomerMessage) (int64, error) {
 	}
 
 	for _, attach := range msg.attachments {
-		_, err = db.NamedExec("insert into igo$attachments (messageid, originalfilename, filename)"+
+		_, err = tx.NamedExecContext(ctx, "insert into igo$attachments (messageid, originalfilename, filename)"+
 			" values (:messageid, :ofname, :fname)",
 			map[string]interface{}{
 				"messageid": lastID,
@@ -1077,6 +1502,11 @@ func postMessage(db *sqlx.DB, msg CustomerMessage) (int64, error) {
 		}
 	}
 
+	now := time.Now()
+	if err = enqueueMessage(ctx, tx, newMessagePayload(ownerID, lastID, msg, now), now); err != nil {
+		return -1, err
+	}
+
 	log.Printf("insert into igo$message, id: %v", lastID)
 
 	return lastID, nil
@@ -1088,42 +1518,44 @@ func getParams(query *ongrid2.Query) map[string]interface{} {
 	params := make(map[string]interface{})
 
 	for _, param := range query.Parameters {
-		switch param.Type {
-		case ongrid2.FieldType_INTEGER:
-			params[*param.Name] = param.IntegerValue
-		case ongrid2.FieldType_DOUBLE:
-			params[*param.Name] = param.DoubleValue
-		case ongrid2.FieldType_STRING:
-			params[*param.Name] = param.StringValue
-		case ongrid2.FieldType_DATETIME:
-			params[*param.Name] = param.DatetimeValue
-		case ongrid2.FieldType_BLOB:
-			params[*param.Name] = param.BlobValue
+		if param.Name == nil {
+			continue
 		}
+		params[*param.Name] = paramValue(param)
 	}
 	return params
 }
 
 /* Auth func */
 
-func authMac(login string, macAddr string) (string, error) {
-	user, err := mongoConnection.GetUserByMacAddr(login, macAddr)
+func (p *OngridHandler) authMac(mongo *MongoConnection, login string, macAddr string) (string, error) {
+	user, err := mongo.GetUserByMacAddr(login, macAddr)
 	if err == nil {
-		authToken, err := startSession(&user)
+		authToken, err := p.startSession(&user, macAddr)
 		if err != nil {
 			return "", err
 		}
 		return authToken, nil
 	}
 
-	//return "", fmt.Errorf("AuthMac failed. MacAddr: %s", macAddr)
-	return "", fmt.Errorf(err.Error())
+	switch err {
+	case mgo.ErrNotFound:
+		return "", userError(ongrid2.ErrorCode_INVALID_AUTH, fmt.Sprintf("AuthMac failed. MacAddr: %s", macAddr))
+	case ErrWorkplaceDisabled:
+		return "", userError(ongrid2.ErrorCode_PERMISSION_DENIED, err.Error())
+	}
+	return "", err
 }
 
-func authLP(login, password string) (string, *User, error) {
-	user, err := mongoConnection.GetUserByLogin(login)
+// authLP starts a session by login and password, macAddr is the workplace the
+// session works from or empty
+func (p *OngridHandler) authLP(mongo *MongoConnection, login, password, macAddr string) (string, *User, error) {
+	user, err := mongo.GetUserByLogin(login)
 	if err != nil {
 		log.Printf("AuthLP: select from sys$clients: %v\n", err)
+		if err == mgo.ErrNotFound {
+			return "", nil, userError(ongrid2.ErrorCode_INVALID_AUTH, fmt.Sprintf("Auth failed. Login: %s", login))
+		}
 		return "", nil, err
 	}
 	log.Printf("authLP: User: %v\n", user)
@@ -1136,14 +1568,14 @@ func authLP(login, password string) (string, *User, error) {
 	// if user.Password == hpass {
 	if err == nil {
 		log.Println("authLP: Password correct")
-		authToken, err := startSession(&user)
+		authToken, err := p.startSession(&user, macAddr)
 		if err != nil {
 			return "", nil, err
 		}
 		return authToken, &user, nil
 	}
 	log.Println("Password incorrect")
-	return "", nil, fmt.Errorf("Auth failed. Login: %s", login)
+	return "", nil, userError(ongrid2.ErrorCode_INVALID_AUTH, fmt.Sprintf("Auth failed. Login: %s", login))
 }
 
 func getDataConnectionString(user *User) string {
@@ -1158,30 +1590,48 @@ func getConfigConnectionString(user *User) string {
 	return conn
 }
 
-func startSession(user *User) (authToken string, err error) {
-	htoken := md5.New()
-	io.WriteString(htoken, user.Login)
-	io.WriteString(htoken, user.Password)
-	io.WriteString(htoken, time.Now().String())
+func (p *OngridHandler) startSession(user *User, macAddr string) (authToken string, err error) {
+	authToken, err = newAuthToken()
+	if err != nil {
+		log.Printf("startSession: %v", err)
+		return "", err
+	}
 
-	authToken = fmt.Sprintf("%x", htoken.Sum(nil))
+	sessionID := uuid.NewV4().String()
+	log.Printf("Session id = %s\n", sessionID)
+
+	session, err := openSession(p.cfg, sessionID, hashToken(authToken), user)
+	if err != nil {
+		return "", err
+	}
+	session.macAddr = macAddr
+
+	if err = p.sessions.Add(session); err != nil {
+		log.Printf("startSession: %v", err)
+		session.close()
+		return "", err
+	}
 
-	var sessionID string
-	u4 := uuid.NewV4()
-	sessionID = u4.String()
+	return
+}
 
-	// dbOnGrid.NamedExec("insert into sys$sessions (id, login, token, created_at, active) values (:id, :login, :token, :createdat, :active)",
-	// 	map[string]interface{}{
-	// 		"id":        sessionID,
-	// 		"login":     user.Login,
-	// 		"token":     authToken,
-	// 		"createdat": time.Now(),
-	// 		"active":    1,
-	// 	})
-	// log.Println("insert into sys$sessions complete..")
+// openSession connects to the client databases of the user
+func openSession(cfg *Config, sessionID, tokenHash string, user *User) (*Session, error) {
+	var err error
 
-	sessions[sessionID] = &Session{token: authToken, user: user}
-	log.Printf("Session id = %s\n", sessionID)
+	now := time.Now()
+	session := &Session{
+		id:           sessionID,
+		tokenHash:    tokenHash,
+		user:         user,
+		queries:      make(map[string][]ongrid2.Query),
+		transactions: make(map[string]*Transaction),
+		cursors:      make(map[string]*Cursor),
+		running:      make(map[string]*runningQuery),
+		cfg:          cfg,
+		createdAt:    now,
+		lastSeen:     now,
+	}
 
 	log.Printf("User: %v\n", user)
 	log.Printf("User.DB: %v\n", user.DB)
@@ -1193,38 +1643,49 @@ func startSession(user *User) (authToken string, err error) {
 	configDB = getConfigConnectionString(user)
 
 	log.Println(dataDB)
-	sessions[sessionID].dbData, err = sqlx.Connect("firebirdsql", dataDB)
+	session.dbData, err = sqlx.Connect("firebirdsql", dataDB)
 	log.Printf("Client data db: %s\n", dataDB)
 	if err != nil {
-		log.Fatalln("startSession: connect to client db: ", err)
-		return "", err
+		log.Printf("openSession: connect to client db: %v", err)
+		return nil, err
+	}
+	log.Println("openSession: Client data-database connection established")
+	if cfg.StmtCache > 0 {
+		session.stmts = newStmtCache(session.dbData, cfg.StmtCache)
 	}
-	log.Println("startSession: Client data-database connection established")
-	sessions[sessionID].dbConfig, err = sqlx.Connect("firebirdsql", configDB)
+	session.dbConfig, err = sqlx.Connect("firebirdsql", configDB)
 	log.Printf("Client config db: %s\n", configDB)
 	if err != nil {
-		log.Fatalln("startSession: connect to client db: ", err)
-		return "", err
+		log.Printf("openSession: connect to client db: %v", err)
+		session.close()
+		return nil, err
 	}
-	log.Println("startSession: Client config-database connection established")
+	log.Println("openSession: Client config-database connection established")
 
-	return
+	return session, nil
 }
 
-// checkToken проверяет активность сессии и возвращает id сессии
-func checkToken(authToken string) (string, error) {
-	sessionID, err := getSessionIDByToken(authToken)
+// checkToken проверяет активность сессии и возвращает сессию
+func checkToken(sessions SessionStore, authToken string) (*Session, error) {
+	session, err := sessions.GetByToken(hashToken(authToken))
+	if err == ErrSessionExpired {
+		return nil, userError(ongrid2.ErrorCode_AUTH_EXPIRED, "Session expired")
+	}
+	if err == ErrSessionNotFound {
+		return nil, userError(ongrid2.ErrorCode_INVALID_AUTH, "Token unknown")
+	}
 	if err != nil {
-		return "", fmt.Errorf("Token unknown: %v", err)
+		return nil, err
 	}
-	return sessionID, nil
-}
 
-func getSessionIDByToken(token string) (string, error) {
-	for key, session := range sessions {
-		if session.token == token {
-			return key, nil
+	now := time.Now()
+	if session.expired(now) {
+		if _, err = sessions.Expire(session.id); err == nil {
+			session.close()
 		}
+		return nil, userError(ongrid2.ErrorCode_AUTH_EXPIRED, "Session expired")
 	}
-	return "", fmt.Errorf("Token not found")
+	session.touch(now)
+
+	return session, nil
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"ongrid-thrift/ongrid2"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// inBatch is the largest number of ids in one "in (?)" list, firebird allows
+// 1500
+const inBatch = 1000
+
+// selectIn runs query with "in (?)" for the distinct ids in batches of inBatch
+// and appends the rows to dest, a pointer to a slice
+func selectIn(ctx context.Context, q sqlx.QueryerContext, dest interface{}, query string, ids []int64) error {
+	seen := make(map[int64]bool, len(ids))
+	distinct := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			distinct = append(distinct, id)
+		}
+	}
+
+	for len(distinct) > 0 {
+		n := len(distinct)
+		if n > inBatch {
+			n = inBatch
+		}
+		batchQuery, args, err := sqlx.In(query, distinct[:n])
+		if err != nil {
+			return err
+		}
+		if err = sqlx.SelectContext(ctx, q, dest, batchQuery, args...); err != nil {
+			return err
+		}
+		distinct = distinct[n:]
+	}
+	return nil
+}
+
+// clientColumns are the columns of sys$clients read into DBClient
+const clientColumns = "id, email, name, accounttype, clienttype, registrationdate, phone, person, company"
+
+// loadClients returns the clients of sys$clients with their persons and
+// companies by id, a missing id is not in the map
+func loadClients(ctx context.Context, q sqlx.QueryerContext, ids []int64) (map[int64]*ongrid2.Client, error) {
+	var dbClients []DBClient
+	if err := selectIn(ctx, q, &dbClients, "select "+clientColumns+" from sys$clients where id in (?)", ids); err != nil {
+		log.Printf("loadClients, select from sys$clients error: %v", err)
+		return nil, err
+	}
+
+	clients, err := clientsFromDB(ctx, q, dbClients)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]*ongrid2.Client, len(clients))
+	for _, client := range clients {
+		byID[client.ID] = client
+	}
+	return byID, nil
+}
+
+// clientsFromDB converts the rows of sys$clients, the persons and companies are
+// loaded with one query each
+func clientsFromDB(ctx context.Context, q sqlx.QueryerContext, dbClients []DBClient) ([]*ongrid2.Client, error) {
+	var personIDs, companyIDs []int64
+	for _, dbClient := range dbClients {
+		if dbClient.Person.Valid {
+			personIDs = append(personIDs, dbClient.Person.Int64)
+		}
+		if dbClient.Company.Valid {
+			companyIDs = append(companyIDs, dbClient.Company.Int64)
+		}
+	}
+
+	persons, err := loadPersons(ctx, q, personIDs)
+	if err != nil {
+		return nil, err
+	}
+	companies, err := loadCompanies(ctx, q, companyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]*ongrid2.Client, 0, len(dbClients))
+	for _, dbClient := range dbClients {
+		client := &ongrid2.Client{
+			ID:          int64(dbClient.ID),
+			AccountType: ongrid2.AccountType(dbClient.AccountType),
+			ClientType:  ongrid2.ClientType(dbClient.ClientType),
+			Phone:       dbClient.Phone.String,
+		}
+		if dbClient.Email.Valid {
+			client.Email = &dbClient.Email.String
+		}
+		if dbClient.Name.Valid {
+			client.Name = &dbClient.Name.String
+		}
+		if dbClient.RegistrationDate.Valid {
+			client.RegistrationDate = dbClient.RegistrationDate.Time.Unix()
+		}
+		if dbClient.Person.Valid {
+			client.Person = persons[dbClient.Person.Int64]
+		}
+		if dbClient.Company.Valid {
+			client.Company = companies[dbClient.Company.Int64]
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// loadPersons returns the persons of sys$person by id
+func loadPersons(ctx context.Context, q sqlx.QueryerContext, ids []int64) (map[int64]*ongrid2.Person, error) {
+	persons := make(map[int64]*ongrid2.Person, len(ids))
+	if len(ids) == 0 {
+		return persons, nil
+	}
+
+	var dbPersons []DBPerson
+	if err := selectIn(ctx, q, &dbPersons, "select * from sys$person where id in (?)", ids); err != nil {
+		log.Printf("loadPersons, select from sys$person error: %v", err)
+		return nil, err
+	}
+	for _, dbPerson := range dbPersons {
+		persons[int64(dbPerson.ID)] = personFromDB(dbPerson)
+	}
+	return persons, nil
+}
+
+// personFromDB converts the row of sys$person
+func personFromDB(dbPerson DBPerson) *ongrid2.Person {
+	person := &ongrid2.Person{
+		ID:             int32(dbPerson.ID),
+		FirstName:      dbPerson.FirstName.String,
+		LastName:       dbPerson.LastName.String,
+		PassportNumber: dbPerson.PassportNumber.String,
+		PassportSeries: dbPerson.PassportSeries.String,
+		PassportDate:   dbPerson.PassportDate.String,
+		Gender:         ongrid2.GenderType(dbPerson.Gender.Int64),
+	}
+	if dbPerson.BirthDay.Valid {
+		person.BirthDay = dbPerson.BirthDay.Time.Unix()
+	}
+	return person
+}
+
+// loadCompanies returns the companies of sys$companies by id
+func loadCompanies(ctx context.Context, q sqlx.QueryerContext, ids []int64) (map[int64]*ongrid2.Company, error) {
+	companies := make(map[int64]*ongrid2.Company, len(ids))
+	if len(ids) == 0 {
+		return companies, nil
+	}
+
+	var dbCompanies []DBCompany
+	if err := selectIn(ctx, q, &dbCompanies, "select * from sys$companies where id in (?)", ids); err != nil {
+		log.Printf("loadCompanies, select from sys$companies error: %v", err)
+		return nil, err
+	}
+	for _, dbCompany := range dbCompanies {
+		companies[int64(dbCompany.ID)] = companyFromDB(dbCompany)
+	}
+	return companies, nil
+}
+
+// companyFromDB converts the row of sys$companies
+func companyFromDB(dbCompany DBCompany) *ongrid2.Company {
+	return &ongrid2.Company{
+		ID:                   int32(dbCompany.ID),
+		Servicename:          dbCompany.ServiceName.String,
+		Phone:                dbCompany.Phone.String,
+		LegalForm:            dbCompany.LegalForm.String,
+		FullName:             dbCompany.FullName.String,
+		Inn:                  dbCompany.Inn.String,
+		Kpp:                  dbCompany.Kpp.String,
+		LegalAddress:         dbCompany.LegalAddress.String,
+		BankAccountNumber:    dbCompany.BankAccountNumber.String,
+		CorrespondentAccount: dbCompany.CorrespondentAccount.String,
+		BankCode:             dbCompany.BankCode.String,
+		Bank:                 dbCompany.Bank.String,
+		Ceo:                  dbCompany.Ceo.String,
+		ChiefAccountant:      dbCompany.ChiefAccountant.String,
+		RealAddress:          dbCompany.RealAddress.String,
+	}
+}
+
+// loadCars returns the cars of sys$cars by id
+func loadCars(ctx context.Context, q sqlx.QueryerContext, ids []int64) (map[int64]*ongrid2.Car, error) {
+	cars := make(map[int64]*ongrid2.Car, len(ids))
+	if len(ids) == 0 {
+		return cars, nil
+	}
+
+	var dbCars []DBCar
+	if err := selectIn(ctx, q, &dbCars, "select * from sys$cars where id in (?)", ids); err != nil {
+		log.Printf("loadCars, select from sys$cars error: %v", err)
+		return nil, err
+	}
+	for _, dbCar := range dbCars {
+		cars[int64(dbCar.ID)] = carFromDB(dbCar)
+	}
+	return cars, nil
+}
+
+// carFromDB converts the row of sys$cars
+func carFromDB(dbCar DBCar) *ongrid2.Car {
+	car := &ongrid2.Car{
+		ID:           int64(dbCar.ID),
+		Brand:        dbCar.Brand.String,
+		Model:        dbCar.Model.String,
+		Number:       dbCar.Number.String,
+		Year:         int32(dbCar.Year.Int64),
+		Mileage:      int32(dbCar.Mileage.Int64),
+		EngineVolume: dbCar.EngineVolume.Float64,
+		EngineType:   ongrid2.EngineType(dbCar.EngineType.Int64),
+		GearType:     ongrid2.GearType(dbCar.GearType.Int64),
+		BodyType:     ongrid2.BodyType(dbCar.BodyType.Int64),
+		DriveType:    ongrid2.DriveType(dbCar.DriveType.Int64),
+		VIN:          dbCar.VIN.String,
+		CarTraider:   dbCar.CarTraider.String,
+		Color:        dbCar.Color.String,
+		Owner:        dbCar.Owner.String,
+	}
+	if dbCar.SaleDate.Valid {
+		car.SaleDate = dbCar.SaleDate.Time.Unix()
+	}
+	return car
+}
+
+// requestsFromDB converts the rows of sys$requests in order, the clients,
+// companies and cars of all requests are loaded with one query each. A request
+// that refers to a missing client, company or car returns DATA_INCORRECT: the
+// thrift struct requires them.
+func requestsFromDB(ctx context.Context, q sqlx.QueryerContext, dbRequests []DBRequest) ([]*ongrid2.Request, error) {
+	var clientIDs, carIDs []int64
+	for _, dbRequest := range dbRequests {
+		clientIDs = append(clientIDs, int64(dbRequest.User), int64(dbRequest.Company))
+		carIDs = append(carIDs, int64(dbRequest.Car))
+	}
+
+	clients, err := loadClients(ctx, q, clientIDs)
+	if err != nil {
+		return nil, err
+	}
+	cars, err := loadCars(ctx, q, carIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]*ongrid2.Request, 0, len(dbRequests))
+	for _, dbRequest := range dbRequests {
+		user, company, car := clients[int64(dbRequest.User)], clients[int64(dbRequest.Company)], cars[int64(dbRequest.Car)]
+		if user == nil || company == nil || car == nil {
+			log.Printf("requestsFromDB, request %d: user %d, company %d or car %d not found", dbRequest.ID, dbRequest.User, dbRequest.Company, dbRequest.Car)
+			return nil, userError(ongrid2.ErrorCode_DATA_INCORRECT, fmt.Sprintf("Request %d refers to a missing user %d, company %d or car %d",
+				dbRequest.ID, dbRequest.User, dbRequest.Company, dbRequest.Car))
+		}
+
+		request := &ongrid2.Request{
+			ID:                int32(dbRequest.ID),
+			User:              user,
+			Company:           company,
+			DesiredTimePeriod: int32(dbRequest.DesiredTimePeriod.Int64),
+			Phone:             dbRequest.Phone.String,
+			Email:             dbRequest.Email.String,
+			Description:       dbRequest.Description.String,
+			Car:               car,
+			Status:            ongrid2.RequestStatus(dbRequest.Status),
+			MasterInspector:   dbRequest.MasterInspector.String,
+		}
+		if dbRequest.CreatedDateTime.Valid {
+			request.CreatedDateTime = dbRequest.CreatedDateTime.Time.Unix()
+		}
+		if dbRequest.DesiredDateTime.Valid {
+			request.DesiredDateTime = dbRequest.DesiredDateTime.Time.Unix()
+		}
+		if dbRequest.CheckInDateTime.Valid {
+			request.CheckInDateTime = dbRequest.CheckInDateTime.Time.Unix()
+		}
+		if dbRequest.CheckOutDateTime.Valid {
+			request.CheckOutDateTime = dbRequest.CheckOutDateTime.Time.Unix()
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+func getRequest(ctx context.Context, db *sqlx.DB, requestID int) (*ongrid2.Request, error) {
+	var dbRequest DBRequest
+	if err := db.GetContext(ctx, &dbRequest, "select * from sys$requests where id = ?", requestID); err != nil {
+		log.Printf("getRequest, select from sys$requests error: %v", err)
+		return nil, err
+	}
+
+	requests, err := requestsFromDB(ctx, db, []DBRequest{dbRequest})
+	if err != nil {
+		return nil, err
+	}
+	return requests[0], nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"ongrid-thrift/ongrid2"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// tableDriver is a database/sql driver that answers
+// "select ... from <table> where id in (...)" from in-memory tables, the first
+// column of a table is the id, a select without arguments returns all rows. It
+// counts the queries by table and records the statements of committed
+// transactions.
+type tableDriver struct {
+	mu        sync.Mutex
+	tables    map[string]testTable
+	queries   map[string]int
+	committed []execStmt
+}
+
+// execStmt is an executed statement with its arguments
+type execStmt struct {
+	query string
+	args  []driver.Value
+}
+
+type testTable struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+var loaderDriver = &tableDriver{}
+
+func init() {
+	sql.Register("loadertest", loaderDriver)
+}
+
+func (d *tableDriver) Open(name string) (driver.Conn, error) {
+	return &tableConn{d: d}, nil
+}
+
+type tableConn struct {
+	d       *tableDriver
+	pending []execStmt // statements of the open transaction
+}
+
+func (c *tableConn) Prepare(query string) (driver.Stmt, error) {
+	return &tableStmt{c: c, query: query}, nil
+}
+
+func (c *tableConn) Close() error { return nil }
+
+func (c *tableConn) Begin() (driver.Tx, error) {
+	c.pending = nil
+	return c, nil
+}
+
+func (c *tableConn) Commit() error {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.committed = append(c.d.committed, c.pending...)
+	c.pending = nil
+	return nil
+}
+
+func (c *tableConn) Rollback() error {
+	c.pending = nil
+	return nil
+}
+
+type tableStmt struct {
+	c     *tableConn
+	query string
+}
+
+func (s *tableStmt) Close() error { return nil }
+
+func (s *tableStmt) NumInput() int { return -1 }
+
+// Exec records the statement, it updates one row
+func (s *tableStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.c.pending = append(s.c.pending, execStmt{query: s.query, args: args})
+	return driver.RowsAffected(1), nil
+}
+
+func (s *tableStmt) Query(args []driver.Value) (driver.Rows, error) {
+	from := strings.Index(s.query, " from ")
+	name := strings.Fields(s.query[from+len(" from "):])[0]
+
+	d := s.c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries[name]++
+	table, ok := d.tables[name]
+	if !ok {
+		return nil, errors.New("unknown table " + name)
+	}
+
+	// the selected columns if all of them are columns of the table
+	index := make(map[string]int, len(table.columns))
+	for i, column := range table.columns {
+		index[column] = i
+	}
+	columns := table.columns
+	var selected []int
+	for _, column := range strings.Split(s.query[len("select "):from], ",") {
+		i, ok := index[strings.ToUpper(strings.TrimSpace(column))]
+		if !ok {
+			selected = nil
+			break
+		}
+		selected = append(selected, i)
+	}
+	if selected != nil {
+		columns = make([]string, 0, len(selected))
+		for _, i := range selected {
+			columns = append(columns, table.columns[i])
+		}
+	}
+
+	rows := &tableRows{columns: columns}
+	for _, row := range table.rows {
+		match := len(args) == 0
+		for _, arg := range args {
+			match = match || row[0] == arg
+		}
+		if !match {
+			continue
+		}
+		if selected != nil {
+			values := make([]driver.Value, 0, len(selected))
+			for _, i := range selected {
+				values = append(values, row[i])
+			}
+			row = values
+		}
+		rows.rows = append(rows.rows, row)
+	}
+	return rows, nil
+}
+
+type tableRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *tableRows) Columns() []string { return r.columns }
+func (r *tableRows) Close() error      { return nil }
+
+func (r *tableRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// newLoaderDB returns a database with the tables, the query counts start at 0
+func newLoaderDB(t *testing.T, tables map[string]testTable) *sqlx.DB {
+	t.Helper()
+	loaderDriver.mu.Lock()
+	loaderDriver.tables = tables
+	loaderDriver.queries = make(map[string]int)
+	loaderDriver.committed = nil
+	loaderDriver.mu.Unlock()
+
+	db, err := sqlx.Open("loadertest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func loaderQueries(table string) int {
+	loaderDriver.mu.Lock()
+	defer loaderDriver.mu.Unlock()
+	return loaderDriver.queries[table]
+}
+
+// loaderCommitted returns and forgets the statements of committed transactions
+func loaderCommitted() []execStmt {
+	loaderDriver.mu.Lock()
+	defer loaderDriver.mu.Unlock()
+	committed := loaderDriver.committed
+	loaderDriver.committed = nil
+	return committed
+}
+
+// loaderTables has the clients 1 (a person) and 2 (a company) and the car 100
+func loaderTables() map[string]testTable {
+	return map[string]testTable{
+		"sys$clients": {
+			columns: []string{"ID", "EMAIL", "NAME", "ACCOUNTTYPE", "CLIENTTYPE", "REGISTRATIONDATE", "PHONE", "PERSON", "COMPANY"},
+			rows: [][]driver.Value{
+				{int64(1), "ivan@example.com", "Ivan", int64(0), int64(ongrid2.ClientType_PERSON), nil, "+79990000001", int64(10), nil},
+				{int64(2), nil, "Service", int64(0), int64(ongrid2.ClientType_COMPANY), nil, "+79990000002", nil, int64(20)},
+			},
+		},
+		"sys$person": {
+			columns: []string{"ID", "FIRSTNAME", "LASTNAME"},
+			rows:    [][]driver.Value{{int64(10), "Ivan", "Petrov"}},
+		},
+		"sys$companies": {
+			columns: []string{"ID", "SERVICENAME", "INN"},
+			rows:    [][]driver.Value{{int64(20), "Service", "7707083893"}},
+		},
+		"sys$cars": {
+			columns: []string{"ID", "BRAND", "VIN"},
+			rows:    [][]driver.Value{{int64(100), "Lada", "XTA21099043456789"}},
+		},
+	}
+}
+
+func TestRequestsFromDB(t *testing.T) {
+	db := newLoaderDB(t, loaderTables())
+
+	dbRequests := []DBRequest{
+		{ID: 1, User: 1, Company: 2, Car: 100},
+		{ID: 2, User: 1, Company: 2, Car: 100},
+		{ID: 3, User: 1, Company: 2, Car: 100},
+	}
+	requests, err := requestsFromDB(context.Background(), db, dbRequests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != len(dbRequests) {
+		t.Fatalf("%d requests, want %d", len(requests), len(dbRequests))
+	}
+	for i, request := range requests {
+		if request.ID != int32(dbRequests[i].ID) {
+			t.Errorf("request %d: id %d", i, request.ID)
+		}
+		if request.User.Person == nil || request.User.Person.FirstName != "Ivan" {
+			t.Errorf("request %d: user %+v", i, request.User)
+		}
+		if request.Company.Company == nil || request.Company.Company.Inn != "7707083893" {
+			t.Errorf("request %d: company %+v", i, request.Company)
+		}
+		if request.Car.VIN != "XTA21099043456789" {
+			t.Errorf("request %d: car %+v", i, request.Car)
+		}
+	}
+
+	// one query per table for all requests
+	for _, table := range []string{"sys$clients", "sys$person", "sys$companies", "sys$cars"} {
+		if got := loaderQueries(table); got != 1 {
+			t.Errorf("%s: %d queries, want 1", table, got)
+		}
+	}
+}
+
+func TestRequestsFromDBMissingReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		request DBRequest
+	}{
+		{"user", DBRequest{ID: 1, User: 3, Company: 2, Car: 100}},
+		{"company", DBRequest{ID: 1, User: 1, Company: 3, Car: 100}},
+		{"car", DBRequest{ID: 1, User: 1, Company: 2, Car: 101}},
+	}
+
+	for _, tt := range tests {
+		db := newLoaderDB(t, loaderTables())
+		requests, err := requestsFromDB(context.Background(), db, []DBRequest{{ID: 2, User: 1, Company: 2, Car: 100}, tt.request})
+		if !isDataIncorrect(err) {
+			t.Errorf("missing %s: got %v, %v, want DATA_INCORRECT", tt.name, requests, err)
+		}
+	}
+}
+
+func TestSelectInBatches(t *testing.T) {
+	db := newLoaderDB(t, loaderTables())
+
+	ids := make([]int64, 0, 2*inBatch+2)
+	for id := int64(1); id <= 2*inBatch+1; id++ {
+		ids = append(ids, id)
+	}
+	ids = append(ids, 1) // duplicates are selected once
+
+	cars, err := loadCars(context.Background(), db, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaderQueries("sys$cars"); got != 3 {
+		t.Errorf("%d queries, want 3", got)
+	}
+	if len(cars) != 1 || cars[100] == nil {
+		t.Errorf("cars %v", cars)
+	}
+}
@@ -17,45 +17,40 @@ func Usage() {
 
 func main() {
 	flag.Usage = Usage
-	protocol := flag.String("P", "binary", "Specify the protocol (binary, compact, json, simplejson)")
-	framed := flag.Bool("framed", false, "Use framed transport")
-	buffered := flag.Bool("buffered", false, "Use buffered transport")
-	addr := flag.String("addr", "0.0.0.0:9090", "Address to listen to")
-	secure := flag.Bool("secure", false, "Use tls secure transport")
 
-	flag.Parse()
+	cfg, err := LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	var protocolFactory thrift.TProtocolFactory
-	switch *protocol {
+	switch cfg.Protocol {
 	case "compact":
 		protocolFactory = thrift.NewTCompactProtocolFactory()
 	case "simplejson":
 		protocolFactory = thrift.NewTSimpleJSONProtocolFactory()
 	case "json":
 		protocolFactory = thrift.NewTJSONProtocolFactory()
-	case "binary", "":
-		protocolFactory = thrift.NewTBinaryProtocolFactoryDefault()
 	default:
-		fmt.Fprint(os.Stderr, "Invalid protocol specified", protocol, "\n")
-		Usage()
-		os.Exit(1)
+		protocolFactory = thrift.NewTBinaryProtocolFactoryDefault()
 	}
 
 	var transportFactory thrift.TTransportFactory
-	if *buffered {
+	if cfg.Buffered {
 		transportFactory = thrift.NewTBufferedTransportFactory(8192)
 	} else {
 		transportFactory = thrift.NewTTransportFactory()
 	}
 
-	if *framed {
+	if cfg.Framed {
 		transportFactory = thrift.NewTFramedTransportFactory(transportFactory)
 	}
 
-	go runHTTPServer(":3000")
+	go runHTTPServer(cfg.HTTPAddr)
 
 	// always run server here
-	if err := runServer(transportFactory, protocolFactory, *addr, *secure); err != nil {
+	if err := runServer(cfg, transportFactory, protocolFactory); err != nil {
 		fmt.Println("error running server:", err)
 	}
 }
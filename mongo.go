@@ -10,6 +10,12 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
+// ErrWorkplaceDisabled is returned for a disabled workplace of the client
+var ErrWorkplaceDisabled = errors.New("Workplace is disabled")
+
+// ErrDuplicateEmail is returned when a customer with the email exists
+var ErrDuplicateEmail = errors.New("Duplicate email exists")
+
 // MongoConfig contains configuration for MongoDB
 type MongoConfig struct {
 	user     string
@@ -28,13 +34,15 @@ type docClient struct {
 	Email      string          `bson:"email"`
 	Database   docDatabase     `bson:"database"`
 	WorkPlaces []docWorkPlaces `bson:"workPlaces"`
+	CompanyID  int64           `bson:"companyId,omitempty"`
 }
 
 type docWorkPlaces struct {
-	ID      bson.ObjectId `bson:"_id"`
-	WPName  string        `bson:"wpName"`
-	MacAddr string        `bson:"macAddr"`
-	Enabled bool          `bson:"enabled"`
+	ID          bson.ObjectId `bson:"_id"`
+	WPName      string        `bson:"wpName"`
+	MacAddr     string        `bson:"macAddr"`
+	Enabled     bool          `bson:"enabled"`
+	LastEventID int64         `bson:"lastEventId,omitempty"`
 }
 
 type docDatabase struct {
@@ -48,11 +56,15 @@ type docDatabase struct {
 }
 
 type docSession struct {
-	ID        bson.ObjectId `bson:"_id"`
-	Login     string        `bson:"login"`
-	Token     string        `bson:"token"`
-	CreatedAt time.Time     `bson:"created"`
-	Active    bool
+	ID        string    `bson:"_id"`
+	UserID    string    `bson:"userId"`
+	Login     string    `bson:"login"`
+	MacAddr   string    `bson:"macAddr,omitempty"`
+	TokenHash string    `bson:"tokenHash"`
+	CreatedAt time.Time `bson:"created"`
+	Active    bool      `bson:"active"`
+	Expired   bool      `bson:"expired"`
+	AppUserID int       `bson:"appUserId,omitempty"`
 }
 
 type docCustomer struct {
@@ -134,7 +146,7 @@ func (c *MongoConnection) GetUserByMacAddr(login string, macAddr string) (user U
 
 	for _, workPlace := range result.WorkPlaces {
 		if workPlace.MacAddr == macAddr && !workPlace.Enabled {
-			err = errors.New("Workplace is disabled")
+			err = ErrWorkplaceDisabled
 			return
 		}
 	}
@@ -166,6 +178,30 @@ func (c *MongoConnection) GetUserByLogin(login string) (user User, err error) {
 	return
 }
 
+// GetUserByID ...
+func (c *MongoConnection) GetUserByID(id string) (user User, err error) {
+	result := docClient{}
+	session, clientCollection, err := c.getSessionAndCollection("clients")
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	if !bson.IsObjectIdHex(id) {
+		err = fmt.Errorf("Invalid user id: %s", id)
+		return
+	}
+	err = clientCollection.FindId(bson.ObjectIdHex(id)).One(&result)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	user = fillUserFromResult(result)
+
+	return
+}
+
 func fillUserFromResult(result docClient) User {
 	var user User
 
@@ -175,6 +211,7 @@ func fillUserFromResult(result docClient) User {
 	user.FirstName = result.FirstName
 	user.LastName = result.LastName
 	user.Email = result.Email
+	user.CompanyID = result.CompanyID
 	user.DB.host = result.Database.Host
 	user.DB.port = result.Database.Port
 	user.DB.path = result.Database.Path
@@ -186,6 +223,29 @@ func fillUserFromResult(result docClient) User {
 	return user
 }
 
+// GetClientDatabases returns the databases of all clients with a data file
+func (c *MongoConnection) GetClientDatabases() ([]Database, error) {
+	session, clientCollection, err := c.getSessionAndCollection("clients")
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var result []docClient
+	err = clientCollection.Find(bson.M{"database.dataFile": bson.M{"$nin": []interface{}{nil, ""}}}).
+		Select(bson.M{"database": 1}).All(&result)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	databases := make([]Database, 0, len(result))
+	for _, client := range result {
+		databases = append(databases, fillUserFromResult(client).DB)
+	}
+	return databases, nil
+}
+
 // ClientAddWorkPlace ...
 func (c *MongoConnection) ClientAddWorkPlace(id string, wpName string, macAddr string) error {
 	session, clientCollection, err := c.getSessionAndCollection("clients")
@@ -205,6 +265,48 @@ func (c *MongoConnection) ClientAddWorkPlace(id string, wpName string, macAddr s
 	return nil
 }
 
+// GetEventCursor returns the id of the last event acknowledged by the
+// workplace of the client, 0 if it has not acknowledged any
+func (c *MongoConnection) GetEventCursor(id string, macAddr string) (int64, error) {
+	session, clientCollection, err := c.getSessionAndCollection("clients")
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	if !bson.IsObjectIdHex(id) {
+		return 0, fmt.Errorf("Invalid user id: %s", id)
+	}
+	result := docClient{}
+	err = clientCollection.Find(bson.M{"_id": bson.ObjectIdHex(id), "workPlaces.macAddr": macAddr}).Select(bson.M{"workPlaces": 1}).One(&result)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, workPlace := range result.WorkPlaces {
+		if workPlace.MacAddr == macAddr {
+			return workPlace.LastEventID, nil
+		}
+	}
+	return 0, mgo.ErrNotFound
+}
+
+// AckEvents moves the event cursor of the workplace of the client forward to
+// upToID, a smaller id leaves it unchanged
+func (c *MongoConnection) AckEvents(id string, macAddr string, upToID int64) error {
+	session, clientCollection, err := c.getSessionAndCollection("clients")
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if !bson.IsObjectIdHex(id) {
+		return fmt.Errorf("Invalid user id: %s", id)
+	}
+	query := bson.M{"_id": bson.ObjectIdHex(id), "workPlaces.macAddr": macAddr}
+	return clientCollection.Update(query, bson.M{"$max": bson.M{"workPlaces.$.lastEventId": upToID}})
+}
+
 // CreateCustomer ...
 func (c *MongoConnection) CreateCustomer(owner string, name string, email string, phone string, password string) (string, error) {
 	session, customerCollection, err := c.getSessionAndCollection("clients")
@@ -237,7 +339,7 @@ func (c *MongoConnection) CreateCustomer(owner string, name string, email string
 	)
 	if err != nil {
 		if mgo.IsDup(err) {
-			err = errors.New("Duplicate email exists")
+			err = ErrDuplicateEmail
 		}
 		return "", err
 	}
@@ -272,3 +374,50 @@ func (c *MongoConnection) GetCustomersByOwnerID(ownerID string) ([]*User, error)
 
 	return customers, nil
 }
+
+// SaveSession ...
+func (c *MongoConnection) SaveSession(doc docSession) error {
+	session, sessionCollection, err := c.getSessionAndCollection("thrift-sessions")
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	sessionCollection.EnsureIndex(mgo.Index{Key: []string{"tokenHash"}})
+
+	return sessionCollection.Insert(&doc)
+}
+
+// GetSessionByToken returns a session by hash of the auth token
+func (c *MongoConnection) GetSessionByToken(tokenHash string) (doc docSession, err error) {
+	session, sessionCollection, err := c.getSessionAndCollection("thrift-sessions")
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	err = sessionCollection.Find(bson.M{"tokenHash": tokenHash}).One(&doc)
+	return
+}
+
+// SetSessionAppUser stores the og$users user bound to the session
+func (c *MongoConnection) SetSessionAppUser(id string, appUserID int) error {
+	session, sessionCollection, err := c.getSessionAndCollection("thrift-sessions")
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return sessionCollection.UpdateId(id, bson.M{"$set": bson.M{"appUserId": appUserID}})
+}
+
+// CloseSession marks the session inactive, expired tells it was closed by ttl
+func (c *MongoConnection) CloseSession(id string, expired bool) error {
+	session, sessionCollection, err := c.getSessionAndCollection("thrift-sessions")
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return sessionCollection.UpdateId(id, bson.M{"$set": bson.M{"active": false, "expired": expired}})
+}
@@ -0,0 +1,557 @@
+namespace go ongrid2
+
+/**
+ * You can define enums, which are just 32 bit integers. Values are optional
+ * and start at 1 if not supplied, C style again.
+ */
+enum FieldType {
+  INTEGER = 1,
+  DOUBLE = 2,
+  STRING = 3,
+  DATETIME = 4,
+  BLOB = 5,
+  BIGINT = 6,
+  DECIMAL = 7,
+  BOOLEAN = 8,
+  DATE = 9,
+  TIME = 10
+}
+
+enum AccountType {
+  COMPANY = 1,
+  CAR_OWNER = 2
+}
+
+enum ClientType {
+  PERSON = 1,
+  COMPANY = 2
+}
+
+enum GenderType {
+  MALE = 1,
+  FEMALE = 2
+}
+
+enum EngineType {
+  PETROL = 1,
+  DIESEL = 2,
+  ELECTRIC = 3,
+  HYBRID = 4,
+  GAS = 5
+}
+
+enum GearType {
+  MANUAL = 1,
+  AUTOMATIC = 2,
+  DCT = 3,
+  VARIATOR = 4
+}
+
+enum BodyType {
+  SEDAN = 1,
+  HATCHBACK = 2,
+  WAGON = 3,
+  CONVERTIBLE = 4,
+  MINIVAN = 5,
+  VAN = 6,
+  PICKUP = 7,
+  BUS = 8
+}
+
+enum DriveType {
+  FRONT = 1,
+  REAR = 2,
+  FOUR = 3
+}
+
+enum RequestStatus {
+  STATUS_NEW = 1,
+  STATUS_INPROGRESS = 2,
+  STATUS_ASSIGN = 3,
+  STATUS_REASSIGN = 4,
+  STATUS_REJECTED = 5,
+  STATUS_CANCELLED = 6,
+  STATUS_DONE = 7,
+  STATUS_POSTPONED = 8
+}
+
+enum EventType {
+  REQUEST = 1,
+  NOTIFICATION = 2,
+  NOTIFICATION_RESPONSE = 3,
+  MESSAGE = 4
+}
+
+enum ErrorCode {
+  UNKNOWN = 1,
+  PERMISSION_DENIED = 2,
+  INVALID_AUTH = 3,
+  AUTH_EXPIRED = 4,
+  DATA_INCORRECT = 5,
+  SQL_ERROR = 6,
+  QUERY_TIMEOUT = 7,
+  QUERY_CANCELED = 8,
+  SCHEDULE_CONFLICT = 9
+}
+
+struct ColumnMetadata {
+  1: string name,
+  2: FieldType type,
+  3: i32 length,
+  4: i32 precision
+}
+
+struct DataField {
+  1: optional i64 integerValue,
+  2: optional double doubleValue,
+  3: optional string stringValue,
+  4: optional i64 datetimeValue,
+  5: optional bool boolValue,
+  6: optional binary blobValue,
+  7: optional bool isNull
+}
+
+struct DataRow {
+  1: list<DataField> fields
+}
+
+struct DataRowSet {
+  1: list<ColumnMetadata> columns,
+  2: list<DataRow> rows
+}
+
+struct Parameter {
+  1: optional string name,
+  2: FieldType type,
+  3: optional i32 length,
+  4: optional i32 precision,
+  5: optional i64 integerValue,
+  6: optional double doubleValue,
+  7: optional string stringValue,
+  8: optional i64 datetimeValue,
+  9: optional bool boolValue,
+  10: optional binary blobValue,
+  11: optional bool isNull
+}
+
+struct Cursor {
+  1: string id,
+  2: list<ColumnMetadata> columns
+}
+
+struct StatementCacheStats {
+  1: i64 hits,
+  2: i64 misses,
+  3: i32 size,
+  4: i32 capacity
+}
+
+/**
+ * id - client id of the running query for cancelQuery
+ */
+struct Query {
+  1: optional string name,
+  2: string sql,
+  3: list<Parameter> parameters,
+  4: optional string id
+}
+
+struct Person {
+  1: i32 id,
+  2: string firstName,
+  3: string lastName,
+  4: string passportNumber,
+  5: string passportSeries,
+  6: string passportDate,
+  7: i64 birthDay,
+  8: GenderType gender
+}
+
+struct Company {
+  1: i32 id,
+  2: string servicename,
+  3: string phone,
+  4: string legalForm,
+  5: string fullName,
+  6: string inn,
+  7: string kpp,
+  8: string legalAddress,
+  9: string bankAccountNumber,
+  10: string correspondentAccount,
+  11: string bankCode,
+  12: string bank,
+  13: string ceo,
+  14: string chiefAccountant,
+  15: string realAddress
+}
+
+struct Car {
+  1: i64 id,
+  2: string brand,
+  3: string model,
+  4: string number,
+  5: i32 year,
+  6: i32 mileage,
+  7: double engineVolume,
+  8: EngineType engineType,
+  9: GearType gearType,
+  10: BodyType bodyType,
+  11: DriveType driveType,
+  12: string VIN,
+  13: string carTraider,
+  14: i64 saleDate,
+  15: string color,
+  16: string owner
+}
+
+struct Client {
+  1: i64 id,
+  2: optional string email,
+  3: optional string name,
+  4: AccountType accountType,
+  5: ClientType clientType,
+  6: i64 registrationDate,
+  7: string phone,
+  8: optional Person person,
+  9: optional Company company
+}
+
+struct Request {
+  1: i32 id,
+  2: Client user,
+  3: Client company,
+  5: i64 createdDateTime,
+  6: i64 desiredDateTime,
+  7: i32 desiredTimePeriod,
+  8: string phone,
+  9: string email,
+  10: string description,
+  11: Car car,
+  15: i64 checkInDateTime,
+  16: i64 checkOutDateTime,
+  17: RequestStatus status,
+  18: string masterInspector
+}
+
+/**
+ * Pages of the Crm lists ordered by id
+ * total - number of rows without paging
+ */
+struct ClientList {
+  1: list<Client> clients,
+  2: i32 total
+}
+
+struct PersonList {
+  1: list<Person> persons,
+  2: i32 total
+}
+
+struct CompanyList {
+  1: list<Company> companies,
+  2: i32 total
+}
+
+struct CarList {
+  1: list<Car> cars,
+  2: i32 total
+}
+
+/**
+ * Filter of listRequests, all set conditions must hold
+ * createdFrom, createdTo - unix time range of createdDateTime, createdTo excluded
+ * offset, limit - page of the list ordered by id descending, limit 100 by default and 1000 at most
+ */
+struct RequestFilter {
+  1: optional list<RequestStatus> statuses,
+  2: optional i64 companyId,
+  3: optional i64 userId,
+  4: optional i64 createdFrom,
+  5: optional i64 createdTo,
+  6: optional i32 offset,
+  7: optional i32 limit
+}
+
+/**
+ * total - number of requests matching the filter without paging
+ */
+struct RequestList {
+  1: list<Request> requests,
+  2: i32 total
+}
+
+/**
+ * Status change of a request
+ * fromStatus - not set for the status the request was created with
+ * actor - id of the client whose session changed the status
+ * appUserId - og$users user bound by checkUser, 0 if none
+ */
+struct RequestStatusChange {
+  1: i64 id,
+  2: i32 requestId,
+  3: optional RequestStatus fromStatus,
+  4: RequestStatus toStatus,
+  5: string actor,
+  6: i32 appUserId,
+  7: i64 changedAt,
+  8: string comment
+}
+
+/**
+ * Free service bay slot of a company day
+ * start, end - unix time of the slot
+ * freeBays - number of bays not booked in the slot
+ * busyInspectors - inspectors booked in the slot
+ */
+struct Slot {
+  1: i64 start,
+  2: i64 end,
+  3: i32 freeBays,
+  4: list<string> busyInspectors
+}
+
+struct FileAttach {
+  1: string originalFilename,
+  2: string filename
+}
+
+struct Message {
+  1: i64 id,
+  2: string customer,
+  3: string body,
+  4: i64 parentId,
+  5: i32 direction,
+  6: i64 createdAt,
+  7: list<FileAttach> attachments
+}
+
+struct Event {
+  1: i64 id,
+  2: EventType type,
+  3: optional Request request,
+  4: optional Message message
+}
+
+/**
+ * types - event types to return, all types if empty
+ * maxCount - the largest number of events to return, 500 at most
+ * waitMs - how long to wait for new events when there are none yet, 0 - do not wait
+ */
+struct EventFilter {
+  1: optional list<EventType> types,
+  2: optional i32 maxCount,
+  3: optional i32 waitMs
+}
+
+/**
+ * host, port - public Centrifugo endpoint
+ * secret - not sent, the client connects with the token
+ * user, timestamp, info, token - Centrifugo connection parameters
+ * expiresAt - unix time the token should be renewed by getCentrifugoConf
+ * channels - private channels the user may subscribe to with signCentrifugoChannels
+ */
+struct CentrifugoConf {
+  1: string host,
+  2: i64 port,
+  3: string secret,
+  4: string user,
+  5: string timestamp,
+  6: string info,
+  7: string token,
+  8: i64 expiresAt,
+  9: list<string> channels
+}
+
+/**
+ * Centrifugo private channel subscription sign
+ */
+struct ChannelSign {
+  1: string channel,
+  2: string info,
+  3: string sign
+}
+
+struct ConfigObject {
+  1: i64 id,
+  2: i32 type,
+  3: string name,
+  4: string description,
+  5: i32 subtype,
+  6: optional list<ConfigObject> props,
+  7: optional list<ConfigObject> events,
+  8: optional list<ConfigObject> objects,
+  9: string value,
+  10: i32 tag,
+  11: i64 owner,
+  12: bool updated
+}
+
+struct ConfigProp {
+  1: i64 id,
+  2: i32 objectType,
+  3: i32 paramType,
+  4: i32 propType,
+  5: string pName,
+  6: string pCaption,
+  7: i32 pType,
+  8: string pValues,
+  9: string pDefault,
+  10: i32 pAction
+}
+
+struct Fields {
+  1: ColumnMetadata column,
+  2: DataField value
+}
+
+struct Catalog {
+  1: i64 id,
+  2: i64 parent,
+  3: bool isFolder,
+  4: string name,
+  5: bool deleted,
+  6: list<Fields> fields
+}
+
+struct Document {
+  1: i64 id,
+  2: i64 parent,
+  3: bool isFolder,
+  4: string docnum,
+  5: i64 date,
+  6: bool deleted,
+  7: string status
+}
+
+struct Privilege {
+  1: i64 resource,
+  2: string permission,
+  3: bool access
+}
+
+struct User {
+  1: i64 id,
+  2: string login,
+  3: string fullName
+}
+
+struct Resource {
+  1: string fileName
+}
+
+/**
+ * Structs can also be exceptions, if they are nasty.
+ * what - ErrorCode, why - error text, e.g. firebird error for SQL_ERROR
+ */
+exception InvalidOperation {
+  1: i32 what,
+  2: string why
+}
+
+exception UserException {
+  1: ErrorCode code,
+  2: string message
+}
+
+exception IntergridException {
+  1: string message
+}
+
+exception NotFoundException {
+  1: string message
+}
+
+/**
+ * The request can not move from its status fromStatus to toStatus
+ */
+exception InvalidTransition {
+  1: RequestStatus fromStatus,
+  2: RequestStatus toStatus,
+  3: string message
+}
+
+/**
+ * Ahh, now onto the cool part, defining a service. Services just need a name
+ * and can optionally inherit from another service using the extends keyword.
+ */
+service DB {
+  DataRowSet executeSelectQuery(1: string authToken, 2: Query query) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  void executeNonSelectQuery(1: string authToken, 2: Query query) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  string startBatchExecution(1: string authToken) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  void addQuery(1: string authToken, 2: string batchID, 3: Query query) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  string finishBatchExecution(1: string authToken, 2: string batchID, 3: Query condition, 4: Query onSuccess) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  string batchExecute(1: string authToken, 2: list<Query> queries, 3: Query condition, 4: Query onSuccess) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  string beginTransaction(1: string authToken) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  DataRowSet executeSelectQueryInTransaction(1: string authToken, 2: string transactionId, 3: Query query) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  void executeNonSelectQueryInTransaction(1: string authToken, 2: string transactionId, 3: Query query) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  void commit(1: string authToken, 2: string transactionId) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  void rollback(1: string authToken, 2: string transactionId) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  Cursor openCursor(1: string authToken, 2: Query query) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  DataRowSet fetchCursor(1: string authToken, 2: string cursorId, 3: i32 count) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  void closeCursor(1: string authToken, 2: string cursorId) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  StatementCacheStats getStatementCacheStats(1: string authToken) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation),
+  void cancelQuery(1: string authToken, 2: string queryId) throws (1: IntergridException intergridException, 2: UserException userException, 3: NotFoundException notFoundException, 4: InvalidOperation invalidOperation)
+}
+
+service Ongrid {
+  string connect(1: string login, 2: string macaddr) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  void disconnect(1: string authToken) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  string addWorkPlace(1: string wpname, 2: string macaddr, 3: string login, 4: string password) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Event> getEvents(1: string authToken, 2: i64 lastId, 3: EventFilter filter) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Event> waitEvents(1: string authToken, 2: i64 lastId, 3: i32 timeoutMs) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  void ackEvents(1: string authToken, 2: i64 upToId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  string postEvent(1: string authToken, 2: Event event) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation, 4: InvalidTransition invalidTransition),
+  i64 replayEvents(1: string authToken, 2: i64 fromId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  CentrifugoConf getCentrifugoConf(1: string authToken) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<ChannelSign> signCentrifugoChannels(1: string authToken, 2: string client, 3: list<string> channels) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  ConfigObject getConfiguration(1: string authToken) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<ConfigProp> getProps(1: string authToken) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  i64 login(1: string login, 2: string password) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Privilege> getUserPrivileges(1: string authToken, 2: i64 userId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<User> getUsers(1: string authToken) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  string registerCustomer(1: string authToken, 2: string email, 3: string name, 4: string phone) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  User checkUser(1: string authToken, 2: string login, 3: string password) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  i64 sendMessageToCustomer(1: string authToken, 2: string customerId, 3: string body, 4: i64 parentMessageId, 5: list<FileAttach> attachments) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  void sendMessageToAllCustomers(1: string authToken, 2: string body, 3: list<FileAttach> attachments) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Resource> getResourcesList(1: string authToken) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  string getUserID(1: string authToken) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  void ping()
+}
+
+service Requests {
+  Request getRequest(1: string authToken, 2: i32 requestId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  RequestList listRequests(1: string authToken, 2: RequestFilter filter) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Request createRequest(1: string authToken, 2: Request request) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation, 4: InvalidTransition invalidTransition),
+  Request updateRequest(1: string authToken, 2: Request request) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Request setRequestStatus(1: string authToken, 2: i32 requestId, 3: RequestStatus status, 4: string comment) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation, 4: InvalidTransition invalidTransition),
+  list<RequestStatusChange> getRequestHistory(1: string authToken, 2: i32 requestId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Slot> getAvailableSlots(1: string authToken, 2: i64 companyId, 3: i64 date) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation)
+}
+
+/**
+ * Clients, persons, companies and cars of the system database
+ */
+service Crm {
+  Client getClient(1: string authToken, 2: i64 clientId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  ClientList listClients(1: string authToken, 2: i32 offset, 3: i32 limit) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Client> searchClients(1: string authToken, 2: string query, 3: i32 limit) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Client createClient(1: string authToken, 2: Client client) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Client updateClient(1: string authToken, 2: Client client) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Person getPerson(1: string authToken, 2: i32 personId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  PersonList listPersons(1: string authToken, 2: i32 offset, 3: i32 limit) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Person> searchPersons(1: string authToken, 2: string query, 3: i32 limit) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Person createPerson(1: string authToken, 2: Person person) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Person updatePerson(1: string authToken, 2: Person person) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Company getCompany(1: string authToken, 2: i32 companyId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  CompanyList listCompanies(1: string authToken, 2: i32 offset, 3: i32 limit) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Company> searchCompanies(1: string authToken, 2: string query, 3: i32 limit) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Company createCompany(1: string authToken, 2: Company company) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Company updateCompany(1: string authToken, 2: Company company) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Car getCar(1: string authToken, 2: i64 carId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  CarList listCars(1: string authToken, 2: i32 offset, 3: i32 limit) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Car> searchCars(1: string authToken, 2: string query, 3: i32 limit) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Car createCar(1: string authToken, 2: Car car) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Car updateCar(1: string authToken, 2: Car car) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  Car setCarOwner(1: string authToken, 2: i64 carId, 3: i64 clientId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation),
+  list<Car> getOwnerCars(1: string authToken, 2: i64 clientId) throws (1: UserException userException, 2: NotFoundException notFoundException, 3: InvalidOperation invalidOperation)
+}
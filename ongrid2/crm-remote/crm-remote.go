@@ -0,0 +1,638 @@
+// Autogenerated by Thrift Compiler (0.10.0)
+// DO NOT EDIT UNLESS YOU ARE SURE THAT YOU KNOW WHAT YOU ARE DOING
+
+package main
+
+import (
+        "flag"
+        "fmt"
+        "math"
+        "net"
+        "net/url"
+        "os"
+        "strconv"
+        "strings"
+        "git.apache.org/thrift.git/lib/go/thrift"
+        "ongrid2"
+)
+
+
+func Usage() {
+  fmt.Fprintln(os.Stderr, "Usage of ", os.Args[0], " [-h host:port] [-u url] [-f[ramed]] function [arg1 [arg2...]]:")
+  flag.PrintDefaults()
+  fmt.Fprintln(os.Stderr, "\nFunctions:")
+  fmt.Fprintln(os.Stderr, "  Client getClient(string authToken, i64 clientId)")
+  fmt.Fprintln(os.Stderr, "  ClientList listClients(string authToken, i32 offset, i32 limit)")
+  fmt.Fprintln(os.Stderr, "   searchClients(string authToken, string query, i32 limit)")
+  fmt.Fprintln(os.Stderr, "  Client createClient(string authToken, Client client)")
+  fmt.Fprintln(os.Stderr, "  Client updateClient(string authToken, Client client)")
+  fmt.Fprintln(os.Stderr, "  Person getPerson(string authToken, i32 personId)")
+  fmt.Fprintln(os.Stderr, "  PersonList listPersons(string authToken, i32 offset, i32 limit)")
+  fmt.Fprintln(os.Stderr, "   searchPersons(string authToken, string query, i32 limit)")
+  fmt.Fprintln(os.Stderr, "  Person createPerson(string authToken, Person person)")
+  fmt.Fprintln(os.Stderr, "  Person updatePerson(string authToken, Person person)")
+  fmt.Fprintln(os.Stderr, "  Company getCompany(string authToken, i32 companyId)")
+  fmt.Fprintln(os.Stderr, "  CompanyList listCompanies(string authToken, i32 offset, i32 limit)")
+  fmt.Fprintln(os.Stderr, "   searchCompanies(string authToken, string query, i32 limit)")
+  fmt.Fprintln(os.Stderr, "  Company createCompany(string authToken, Company company)")
+  fmt.Fprintln(os.Stderr, "  Company updateCompany(string authToken, Company company)")
+  fmt.Fprintln(os.Stderr, "  Car getCar(string authToken, i64 carId)")
+  fmt.Fprintln(os.Stderr, "  CarList listCars(string authToken, i32 offset, i32 limit)")
+  fmt.Fprintln(os.Stderr, "   searchCars(string authToken, string query, i32 limit)")
+  fmt.Fprintln(os.Stderr, "  Car createCar(string authToken, Car car)")
+  fmt.Fprintln(os.Stderr, "  Car updateCar(string authToken, Car car)")
+  fmt.Fprintln(os.Stderr, "  Car setCarOwner(string authToken, i64 carId, i64 clientId)")
+  fmt.Fprintln(os.Stderr, "   getOwnerCars(string authToken, i64 clientId)")
+  fmt.Fprintln(os.Stderr)
+  os.Exit(0)
+}
+
+func main() {
+  flag.Usage = Usage
+  var host string
+  var port int
+  var protocol string
+  var urlString string
+  var framed bool
+  var useHttp bool
+  var parsedUrl url.URL
+  var trans thrift.TTransport
+  _ = strconv.Atoi
+  _ = math.Abs
+  flag.Usage = Usage
+  flag.StringVar(&host, "h", "localhost", "Specify host and port")
+  flag.IntVar(&port, "p", 9090, "Specify port")
+  flag.StringVar(&protocol, "P", "binary", "Specify the protocol (binary, compact, simplejson, json)")
+  flag.StringVar(&urlString, "u", "", "Specify the url")
+  flag.BoolVar(&framed, "framed", false, "Use framed transport")
+  flag.BoolVar(&useHttp, "http", false, "Use http")
+  flag.Parse()
+  
+  if len(urlString) > 0 {
+    parsedUrl, err := url.Parse(urlString)
+    if err != nil {
+      fmt.Fprintln(os.Stderr, "Error parsing URL: ", err)
+      flag.Usage()
+    }
+    host = parsedUrl.Host
+    useHttp = len(parsedUrl.Scheme) <= 0 || parsedUrl.Scheme == "http"
+  } else if useHttp {
+    _, err := url.Parse(fmt.Sprint("http://", host, ":", port))
+    if err != nil {
+      fmt.Fprintln(os.Stderr, "Error parsing URL: ", err)
+      flag.Usage()
+    }
+  }
+  
+  cmd := flag.Arg(0)
+  var err error
+  if useHttp {
+    trans, err = thrift.NewTHttpClient(parsedUrl.String())
+  } else {
+    portStr := fmt.Sprint(port)
+    if strings.Contains(host, ":") {
+           host, portStr, err = net.SplitHostPort(host)
+           if err != nil {
+                   fmt.Fprintln(os.Stderr, "error with host:", err)
+                   os.Exit(1)
+           }
+    }
+    trans, err = thrift.NewTSocket(net.JoinHostPort(host, portStr))
+    if err != nil {
+      fmt.Fprintln(os.Stderr, "error resolving address:", err)
+      os.Exit(1)
+    }
+    if framed {
+      trans = thrift.NewTFramedTransport(trans)
+    }
+  }
+  if err != nil {
+    fmt.Fprintln(os.Stderr, "Error creating transport", err)
+    os.Exit(1)
+  }
+  defer trans.Close()
+  var protocolFactory thrift.TProtocolFactory
+  switch protocol {
+  case "compact":
+    protocolFactory = thrift.NewTCompactProtocolFactory()
+    break
+  case "simplejson":
+    protocolFactory = thrift.NewTSimpleJSONProtocolFactory()
+    break
+  case "json":
+    protocolFactory = thrift.NewTJSONProtocolFactory()
+    break
+  case "binary", "":
+    protocolFactory = thrift.NewTBinaryProtocolFactoryDefault()
+    break
+  default:
+    fmt.Fprintln(os.Stderr, "Invalid protocol specified: ", protocol)
+    Usage()
+    os.Exit(1)
+  }
+  client := ongrid2.NewCrmClientFactory(trans, protocolFactory)
+  if err := trans.Open(); err != nil {
+    fmt.Fprintln(os.Stderr, "Error opening socket to ", host, ":", port, " ", err)
+    os.Exit(1)
+  }
+  
+  switch cmd {
+  case "getClient":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "GetClient requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1, err375 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err375 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.GetClient(value0, value1))
+    fmt.Print("\n")
+    break
+  case "listClients":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "ListClients requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err377 := (strconv.Atoi(flag.Arg(2)))
+    if err377 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    tmp2, err378 := (strconv.Atoi(flag.Arg(3)))
+    if err378 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.ListClients(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "searchClients":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "SearchClients requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    tmp2, err381 := (strconv.Atoi(flag.Arg(3)))
+    if err381 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.SearchClients(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "createClient":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "CreateClient requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg383 := flag.Arg(2)
+    mbTrans384 := thrift.NewTMemoryBufferLen(len(arg383))
+    defer mbTrans384.Close()
+    _, err385 := mbTrans384.WriteString(arg383)
+    if err385 != nil {
+      Usage()
+      return
+    }
+    factory386 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt387 := factory386.GetProtocol(mbTrans384)
+    argvalue1 := ongrid2.NewClient()
+    err388 := argvalue1.Read(jsProt387)
+    if err388 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.CreateClient(value0, value1))
+    fmt.Print("\n")
+    break
+  case "updateClient":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "UpdateClient requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg390 := flag.Arg(2)
+    mbTrans391 := thrift.NewTMemoryBufferLen(len(arg390))
+    defer mbTrans391.Close()
+    _, err392 := mbTrans391.WriteString(arg390)
+    if err392 != nil {
+      Usage()
+      return
+    }
+    factory393 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt394 := factory393.GetProtocol(mbTrans391)
+    argvalue1 := ongrid2.NewClient()
+    err395 := argvalue1.Read(jsProt394)
+    if err395 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.UpdateClient(value0, value1))
+    fmt.Print("\n")
+    break
+  case "getPerson":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "GetPerson requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err397 := (strconv.Atoi(flag.Arg(2)))
+    if err397 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    fmt.Print(client.GetPerson(value0, value1))
+    fmt.Print("\n")
+    break
+  case "listPersons":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "ListPersons requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err399 := (strconv.Atoi(flag.Arg(2)))
+    if err399 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    tmp2, err400 := (strconv.Atoi(flag.Arg(3)))
+    if err400 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.ListPersons(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "searchPersons":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "SearchPersons requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    tmp2, err403 := (strconv.Atoi(flag.Arg(3)))
+    if err403 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.SearchPersons(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "createPerson":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "CreatePerson requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg405 := flag.Arg(2)
+    mbTrans406 := thrift.NewTMemoryBufferLen(len(arg405))
+    defer mbTrans406.Close()
+    _, err407 := mbTrans406.WriteString(arg405)
+    if err407 != nil {
+      Usage()
+      return
+    }
+    factory408 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt409 := factory408.GetProtocol(mbTrans406)
+    argvalue1 := ongrid2.NewPerson()
+    err410 := argvalue1.Read(jsProt409)
+    if err410 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.CreatePerson(value0, value1))
+    fmt.Print("\n")
+    break
+  case "updatePerson":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "UpdatePerson requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg412 := flag.Arg(2)
+    mbTrans413 := thrift.NewTMemoryBufferLen(len(arg412))
+    defer mbTrans413.Close()
+    _, err414 := mbTrans413.WriteString(arg412)
+    if err414 != nil {
+      Usage()
+      return
+    }
+    factory415 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt416 := factory415.GetProtocol(mbTrans413)
+    argvalue1 := ongrid2.NewPerson()
+    err417 := argvalue1.Read(jsProt416)
+    if err417 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.UpdatePerson(value0, value1))
+    fmt.Print("\n")
+    break
+  case "getCompany":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "GetCompany requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err419 := (strconv.Atoi(flag.Arg(2)))
+    if err419 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    fmt.Print(client.GetCompany(value0, value1))
+    fmt.Print("\n")
+    break
+  case "listCompanies":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "ListCompanies requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err421 := (strconv.Atoi(flag.Arg(2)))
+    if err421 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    tmp2, err422 := (strconv.Atoi(flag.Arg(3)))
+    if err422 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.ListCompanies(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "searchCompanies":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "SearchCompanies requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    tmp2, err425 := (strconv.Atoi(flag.Arg(3)))
+    if err425 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.SearchCompanies(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "createCompany":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "CreateCompany requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg427 := flag.Arg(2)
+    mbTrans428 := thrift.NewTMemoryBufferLen(len(arg427))
+    defer mbTrans428.Close()
+    _, err429 := mbTrans428.WriteString(arg427)
+    if err429 != nil {
+      Usage()
+      return
+    }
+    factory430 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt431 := factory430.GetProtocol(mbTrans428)
+    argvalue1 := ongrid2.NewCompany()
+    err432 := argvalue1.Read(jsProt431)
+    if err432 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.CreateCompany(value0, value1))
+    fmt.Print("\n")
+    break
+  case "updateCompany":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "UpdateCompany requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg434 := flag.Arg(2)
+    mbTrans435 := thrift.NewTMemoryBufferLen(len(arg434))
+    defer mbTrans435.Close()
+    _, err436 := mbTrans435.WriteString(arg434)
+    if err436 != nil {
+      Usage()
+      return
+    }
+    factory437 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt438 := factory437.GetProtocol(mbTrans435)
+    argvalue1 := ongrid2.NewCompany()
+    err439 := argvalue1.Read(jsProt438)
+    if err439 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.UpdateCompany(value0, value1))
+    fmt.Print("\n")
+    break
+  case "getCar":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "GetCar requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1, err441 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err441 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.GetCar(value0, value1))
+    fmt.Print("\n")
+    break
+  case "listCars":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "ListCars requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err443 := (strconv.Atoi(flag.Arg(2)))
+    if err443 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    tmp2, err444 := (strconv.Atoi(flag.Arg(3)))
+    if err444 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.ListCars(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "searchCars":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "SearchCars requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    tmp2, err447 := (strconv.Atoi(flag.Arg(3)))
+    if err447 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.SearchCars(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "createCar":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "CreateCar requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg449 := flag.Arg(2)
+    mbTrans450 := thrift.NewTMemoryBufferLen(len(arg449))
+    defer mbTrans450.Close()
+    _, err451 := mbTrans450.WriteString(arg449)
+    if err451 != nil {
+      Usage()
+      return
+    }
+    factory452 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt453 := factory452.GetProtocol(mbTrans450)
+    argvalue1 := ongrid2.NewCar()
+    err454 := argvalue1.Read(jsProt453)
+    if err454 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.CreateCar(value0, value1))
+    fmt.Print("\n")
+    break
+  case "updateCar":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "UpdateCar requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg456 := flag.Arg(2)
+    mbTrans457 := thrift.NewTMemoryBufferLen(len(arg456))
+    defer mbTrans457.Close()
+    _, err458 := mbTrans457.WriteString(arg456)
+    if err458 != nil {
+      Usage()
+      return
+    }
+    factory459 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt460 := factory459.GetProtocol(mbTrans457)
+    argvalue1 := ongrid2.NewCar()
+    err461 := argvalue1.Read(jsProt460)
+    if err461 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.UpdateCar(value0, value1))
+    fmt.Print("\n")
+    break
+  case "setCarOwner":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "SetCarOwner requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1, err463 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err463 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    argvalue2, err464 := (strconv.ParseInt(flag.Arg(3), 10, 64))
+    if err464 != nil {
+      Usage()
+      return
+    }
+    value2 := argvalue2
+    fmt.Print(client.SetCarOwner(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "getOwnerCars":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "GetOwnerCars requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1, err466 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err466 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.GetOwnerCars(value0, value1))
+    fmt.Print("\n")
+    break
+  case "":
+    Usage()
+    break
+  default:
+    fmt.Fprintln(os.Stderr, "Invalid function ", cmd)
+  }
+}
@@ -27,6 +27,16 @@ func Usage() {
   fmt.Fprintln(os.Stderr, "  void addQuery(string authToken, string batchID, Query query)")
   fmt.Fprintln(os.Stderr, "  string finishBatchExecution(string authToken, string batchID, Query condition, Query onSuccess)")
   fmt.Fprintln(os.Stderr, "  string batchExecute(string authToken,  queries, Query condition, Query onSuccess)")
+  fmt.Fprintln(os.Stderr, "  string beginTransaction(string authToken)")
+  fmt.Fprintln(os.Stderr, "  DataRowSet executeSelectQueryInTransaction(string authToken, string transactionId, Query query)")
+  fmt.Fprintln(os.Stderr, "  void executeNonSelectQueryInTransaction(string authToken, string transactionId, Query query)")
+  fmt.Fprintln(os.Stderr, "  void commit(string authToken, string transactionId)")
+  fmt.Fprintln(os.Stderr, "  void rollback(string authToken, string transactionId)")
+  fmt.Fprintln(os.Stderr, "  Cursor openCursor(string authToken, Query query)")
+  fmt.Fprintln(os.Stderr, "  DataRowSet fetchCursor(string authToken, string cursorId, i32 count)")
+  fmt.Fprintln(os.Stderr, "  void closeCursor(string authToken, string cursorId)")
+  fmt.Fprintln(os.Stderr, "  StatementCacheStats getStatementCacheStats(string authToken)")
+  fmt.Fprintln(os.Stderr, "  void cancelQuery(string authToken, string queryId)")
   fmt.Fprintln(os.Stderr)
   os.Exit(0)
 }
@@ -128,19 +138,19 @@ func main() {
     }
     argvalue0 := flag.Arg(1)
     value0 := argvalue0
-    arg25 := flag.Arg(2)
-    mbTrans26 := thrift.NewTMemoryBufferLen(len(arg25))
-    defer mbTrans26.Close()
-    _, err27 := mbTrans26.WriteString(arg25)
-    if err27 != nil {
+    arg55 := flag.Arg(2)
+    mbTrans56 := thrift.NewTMemoryBufferLen(len(arg55))
+    defer mbTrans56.Close()
+    _, err57 := mbTrans56.WriteString(arg55)
+    if err57 != nil {
       Usage()
       return
     }
-    factory28 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt29 := factory28.GetProtocol(mbTrans26)
+    factory58 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt59 := factory58.GetProtocol(mbTrans56)
     argvalue1 := ongrid2.NewQuery()
-    err30 := argvalue1.Read(jsProt29)
-    if err30 != nil {
+    err60 := argvalue1.Read(jsProt59)
+    if err60 != nil {
       Usage()
       return
     }
@@ -155,19 +165,19 @@ func main() {
     }
     argvalue0 := flag.Arg(1)
     value0 := argvalue0
-    arg32 := flag.Arg(2)
-    mbTrans33 := thrift.NewTMemoryBufferLen(len(arg32))
-    defer mbTrans33.Close()
-    _, err34 := mbTrans33.WriteString(arg32)
-    if err34 != nil {
+    arg62 := flag.Arg(2)
+    mbTrans63 := thrift.NewTMemoryBufferLen(len(arg62))
+    defer mbTrans63.Close()
+    _, err64 := mbTrans63.WriteString(arg62)
+    if err64 != nil {
       Usage()
       return
     }
-    factory35 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt36 := factory35.GetProtocol(mbTrans33)
+    factory65 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt66 := factory65.GetProtocol(mbTrans63)
     argvalue1 := ongrid2.NewQuery()
-    err37 := argvalue1.Read(jsProt36)
-    if err37 != nil {
+    err67 := argvalue1.Read(jsProt66)
+    if err67 != nil {
       Usage()
       return
     }
@@ -194,19 +204,19 @@ func main() {
     value0 := argvalue0
     argvalue1 := flag.Arg(2)
     value1 := argvalue1
-    arg41 := flag.Arg(3)
-    mbTrans42 := thrift.NewTMemoryBufferLen(len(arg41))
-    defer mbTrans42.Close()
-    _, err43 := mbTrans42.WriteString(arg41)
-    if err43 != nil {
+    arg71 := flag.Arg(3)
+    mbTrans72 := thrift.NewTMemoryBufferLen(len(arg71))
+    defer mbTrans72.Close()
+    _, err73 := mbTrans72.WriteString(arg71)
+    if err73 != nil {
       Usage()
       return
     }
-    factory44 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt45 := factory44.GetProtocol(mbTrans42)
+    factory74 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt75 := factory74.GetProtocol(mbTrans72)
     argvalue2 := ongrid2.NewQuery()
-    err46 := argvalue2.Read(jsProt45)
-    if err46 != nil {
+    err76 := argvalue2.Read(jsProt75)
+    if err76 != nil {
       Usage()
       return
     }
@@ -223,36 +233,36 @@ func main() {
     value0 := argvalue0
     argvalue1 := flag.Arg(2)
     value1 := argvalue1
-    arg49 := flag.Arg(3)
-    mbTrans50 := thrift.NewTMemoryBufferLen(len(arg49))
-    defer mbTrans50.Close()
-    _, err51 := mbTrans50.WriteString(arg49)
-    if err51 != nil {
+    arg79 := flag.Arg(3)
+    mbTrans80 := thrift.NewTMemoryBufferLen(len(arg79))
+    defer mbTrans80.Close()
+    _, err81 := mbTrans80.WriteString(arg79)
+    if err81 != nil {
       Usage()
       return
     }
-    factory52 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt53 := factory52.GetProtocol(mbTrans50)
+    factory82 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt83 := factory82.GetProtocol(mbTrans80)
     argvalue2 := ongrid2.NewQuery()
-    err54 := argvalue2.Read(jsProt53)
-    if err54 != nil {
+    err84 := argvalue2.Read(jsProt83)
+    if err84 != nil {
       Usage()
       return
     }
     value2 := argvalue2
-    arg55 := flag.Arg(4)
-    mbTrans56 := thrift.NewTMemoryBufferLen(len(arg55))
-    defer mbTrans56.Close()
-    _, err57 := mbTrans56.WriteString(arg55)
-    if err57 != nil {
+    arg85 := flag.Arg(4)
+    mbTrans86 := thrift.NewTMemoryBufferLen(len(arg85))
+    defer mbTrans86.Close()
+    _, err87 := mbTrans86.WriteString(arg85)
+    if err87 != nil {
       Usage()
       return
     }
-    factory58 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt59 := factory58.GetProtocol(mbTrans56)
+    factory88 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt89 := factory88.GetProtocol(mbTrans86)
     argvalue3 := ongrid2.NewQuery()
-    err60 := argvalue3.Read(jsProt59)
-    if err60 != nil {
+    err90 := argvalue3.Read(jsProt89)
+    if err90 != nil {
       Usage()
       return
     }
@@ -267,54 +277,54 @@ func main() {
     }
     argvalue0 := flag.Arg(1)
     value0 := argvalue0
-    arg62 := flag.Arg(2)
-    mbTrans63 := thrift.NewTMemoryBufferLen(len(arg62))
-    defer mbTrans63.Close()
-    _, err64 := mbTrans63.WriteString(arg62)
-    if err64 != nil { 
+    arg92 := flag.Arg(2)
+    mbTrans93 := thrift.NewTMemoryBufferLen(len(arg92))
+    defer mbTrans93.Close()
+    _, err94 := mbTrans93.WriteString(arg92)
+    if err94 != nil { 
       Usage()
       return
     }
-    factory65 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt66 := factory65.GetProtocol(mbTrans63)
+    factory95 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt96 := factory95.GetProtocol(mbTrans93)
     containerStruct1 := ongrid2.NewDBBatchExecuteArgs()
-    err67 := containerStruct1.ReadField2(jsProt66)
-    if err67 != nil {
+    err97 := containerStruct1.ReadField2(jsProt96)
+    if err97 != nil {
       Usage()
       return
     }
     argvalue1 := containerStruct1.Queries
     value1 := argvalue1
-    arg68 := flag.Arg(3)
-    mbTrans69 := thrift.NewTMemoryBufferLen(len(arg68))
-    defer mbTrans69.Close()
-    _, err70 := mbTrans69.WriteString(arg68)
-    if err70 != nil {
+    arg98 := flag.Arg(3)
+    mbTrans99 := thrift.NewTMemoryBufferLen(len(arg98))
+    defer mbTrans99.Close()
+    _, err100 := mbTrans99.WriteString(arg98)
+    if err100 != nil {
       Usage()
       return
     }
-    factory71 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt72 := factory71.GetProtocol(mbTrans69)
+    factory101 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt102 := factory101.GetProtocol(mbTrans99)
     argvalue2 := ongrid2.NewQuery()
-    err73 := argvalue2.Read(jsProt72)
-    if err73 != nil {
+    err103 := argvalue2.Read(jsProt102)
+    if err103 != nil {
       Usage()
       return
     }
     value2 := argvalue2
-    arg74 := flag.Arg(4)
-    mbTrans75 := thrift.NewTMemoryBufferLen(len(arg74))
-    defer mbTrans75.Close()
-    _, err76 := mbTrans75.WriteString(arg74)
-    if err76 != nil {
+    arg104 := flag.Arg(4)
+    mbTrans105 := thrift.NewTMemoryBufferLen(len(arg104))
+    defer mbTrans105.Close()
+    _, err106 := mbTrans105.WriteString(arg104)
+    if err106 != nil {
       Usage()
       return
     }
-    factory77 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt78 := factory77.GetProtocol(mbTrans75)
+    factory107 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt108 := factory107.GetProtocol(mbTrans105)
     argvalue3 := ongrid2.NewQuery()
-    err79 := argvalue3.Read(jsProt78)
-    if err79 != nil {
+    err109 := argvalue3.Read(jsProt108)
+    if err109 != nil {
       Usage()
       return
     }
@@ -322,6 +332,178 @@ func main() {
     fmt.Print(client.BatchExecute(value0, value1, value2, value3))
     fmt.Print("\n")
     break
+  case "beginTransaction":
+    if flag.NArg() - 1 != 1 {
+      fmt.Fprintln(os.Stderr, "BeginTransaction requires 1 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    fmt.Print(client.BeginTransaction(value0))
+    fmt.Print("\n")
+    break
+  case "executeSelectQueryInTransaction":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "ExecuteSelectQueryInTransaction requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    arg113 := flag.Arg(3)
+    mbTrans114 := thrift.NewTMemoryBufferLen(len(arg113))
+    defer mbTrans114.Close()
+    _, err115 := mbTrans114.WriteString(arg113)
+    if err115 != nil {
+      Usage()
+      return
+    }
+    factory116 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt117 := factory116.GetProtocol(mbTrans114)
+    argvalue2 := ongrid2.NewQuery()
+    err118 := argvalue2.Read(jsProt117)
+    if err118 != nil {
+      Usage()
+      return
+    }
+    value2 := argvalue2
+    fmt.Print(client.ExecuteSelectQueryInTransaction(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "executeNonSelectQueryInTransaction":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "ExecuteNonSelectQueryInTransaction requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    arg121 := flag.Arg(3)
+    mbTrans122 := thrift.NewTMemoryBufferLen(len(arg121))
+    defer mbTrans122.Close()
+    _, err123 := mbTrans122.WriteString(arg121)
+    if err123 != nil {
+      Usage()
+      return
+    }
+    factory124 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt125 := factory124.GetProtocol(mbTrans122)
+    argvalue2 := ongrid2.NewQuery()
+    err126 := argvalue2.Read(jsProt125)
+    if err126 != nil {
+      Usage()
+      return
+    }
+    value2 := argvalue2
+    fmt.Print(client.ExecuteNonSelectQueryInTransaction(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "commit":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "Commit requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    fmt.Print(client.Commit(value0, value1))
+    fmt.Print("\n")
+    break
+  case "rollback":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "Rollback requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    fmt.Print(client.Rollback(value0, value1))
+    fmt.Print("\n")
+    break
+  case "openCursor":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "OpenCursor requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg132 := flag.Arg(2)
+    mbTrans133 := thrift.NewTMemoryBufferLen(len(arg132))
+    defer mbTrans133.Close()
+    _, err134 := mbTrans133.WriteString(arg132)
+    if err134 != nil {
+      Usage()
+      return
+    }
+    factory135 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt136 := factory135.GetProtocol(mbTrans133)
+    argvalue1 := ongrid2.NewQuery()
+    err137 := argvalue1.Read(jsProt136)
+    if err137 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.OpenCursor(value0, value1))
+    fmt.Print("\n")
+    break
+  case "fetchCursor":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "FetchCursor requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    tmp2, err140 := (strconv.Atoi(flag.Arg(3)))
+    if err140 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.FetchCursor(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "closeCursor":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "CloseCursor requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    fmt.Print(client.CloseCursor(value0, value1))
+    fmt.Print("\n")
+    break
+  case "getStatementCacheStats":
+    if flag.NArg() - 1 != 1 {
+      fmt.Fprintln(os.Stderr, "GetStatementCacheStats requires 1 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    fmt.Print(client.GetStatementCacheStats(value0))
+    fmt.Print("\n")
+    break
+  case "cancelQuery":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "CancelQuery requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    fmt.Print(client.CancelQuery(value0, value1))
+    fmt.Print("\n")
+    break
   case "":
     Usage()
     break
@@ -24,9 +24,13 @@ func Usage() {
   fmt.Fprintln(os.Stderr, "  string connect(string login, string macaddr)")
   fmt.Fprintln(os.Stderr, "  void disconnect(string authToken)")
   fmt.Fprintln(os.Stderr, "  string addWorkPlace(string wpname, string macaddr, string login, string password)")
-  fmt.Fprintln(os.Stderr, "   getEvents(string authToken, i64 lastId)")
+  fmt.Fprintln(os.Stderr, "   getEvents(string authToken, i64 lastId, EventFilter filter)")
+  fmt.Fprintln(os.Stderr, "   waitEvents(string authToken, i64 lastId, i32 timeoutMs)")
+  fmt.Fprintln(os.Stderr, "  void ackEvents(string authToken, i64 upToId)")
   fmt.Fprintln(os.Stderr, "  string postEvent(string authToken, Event event)")
+  fmt.Fprintln(os.Stderr, "  i64 replayEvents(string authToken, i64 fromId)")
   fmt.Fprintln(os.Stderr, "  CentrifugoConf getCentrifugoConf(string authToken)")
+  fmt.Fprintln(os.Stderr, "   signCentrifugoChannels(string authToken, string client,  channels)")
   fmt.Fprintln(os.Stderr, "  ConfigObject getConfiguration(string authToken)")
   fmt.Fprintln(os.Stderr, "   getProps(string authToken)")
   fmt.Fprintln(os.Stderr, "  i64 login(string login, string password)")
@@ -172,19 +176,75 @@ func main() {
     fmt.Print("\n")
     break
   case "getEvents":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "GetEvents requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1, err210 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err210 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    arg211 := flag.Arg(3)
+    mbTrans212 := thrift.NewTMemoryBufferLen(len(arg211))
+    defer mbTrans212.Close()
+    _, err213 := mbTrans212.WriteString(arg211)
+    if err213 != nil {
+      Usage()
+      return
+    }
+    factory214 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt215 := factory214.GetProtocol(mbTrans212)
+    argvalue2 := ongrid2.NewEventFilter()
+    err216 := argvalue2.Read(jsProt215)
+    if err216 != nil {
+      Usage()
+      return
+    }
+    value2 := argvalue2
+    fmt.Print(client.GetEvents(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "waitEvents":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "WaitEvents requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1, err218 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err218 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    tmp2, err219 := (strconv.Atoi(flag.Arg(3)))
+    if err219 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := int32(tmp2)
+    value2 := argvalue2
+    fmt.Print(client.WaitEvents(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "ackEvents":
     if flag.NArg() - 1 != 2 {
-      fmt.Fprintln(os.Stderr, "GetEvents requires 2 args")
+      fmt.Fprintln(os.Stderr, "AckEvents requires 2 args")
       flag.Usage()
     }
     argvalue0 := flag.Arg(1)
     value0 := argvalue0
-    argvalue1, err133 := (strconv.ParseInt(flag.Arg(2), 10, 64))
-    if err133 != nil {
+    argvalue1, err221 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err221 != nil {
       Usage()
       return
     }
     value1 := argvalue1
-    fmt.Print(client.GetEvents(value0, value1))
+    fmt.Print(client.AckEvents(value0, value1))
     fmt.Print("\n")
     break
   case "postEvent":
@@ -194,19 +254,19 @@ func main() {
     }
     argvalue0 := flag.Arg(1)
     value0 := argvalue0
-    arg135 := flag.Arg(2)
-    mbTrans136 := thrift.NewTMemoryBufferLen(len(arg135))
-    defer mbTrans136.Close()
-    _, err137 := mbTrans136.WriteString(arg135)
-    if err137 != nil {
+    arg223 := flag.Arg(2)
+    mbTrans224 := thrift.NewTMemoryBufferLen(len(arg223))
+    defer mbTrans224.Close()
+    _, err225 := mbTrans224.WriteString(arg223)
+    if err225 != nil {
       Usage()
       return
     }
-    factory138 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt139 := factory138.GetProtocol(mbTrans136)
+    factory226 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt227 := factory226.GetProtocol(mbTrans224)
     argvalue1 := ongrid2.NewEvent()
-    err140 := argvalue1.Read(jsProt139)
-    if err140 != nil {
+    err228 := argvalue1.Read(jsProt227)
+    if err228 != nil {
       Usage()
       return
     }
@@ -214,6 +274,22 @@ func main() {
     fmt.Print(client.PostEvent(value0, value1))
     fmt.Print("\n")
     break
+  case "replayEvents":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "ReplayEvents requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1, err230 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err230 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.ReplayEvents(value0, value1))
+    fmt.Print("\n")
+    break
   case "getCentrifugoConf":
     if flag.NArg() - 1 != 1 {
       fmt.Fprintln(os.Stderr, "GetCentrifugoConf requires 1 args")
@@ -224,6 +300,36 @@ func main() {
     fmt.Print(client.GetCentrifugoConf(value0))
     fmt.Print("\n")
     break
+  case "signCentrifugoChannels":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "SignCentrifugoChannels requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1 := flag.Arg(2)
+    value1 := argvalue1
+    arg234 := flag.Arg(3)
+    mbTrans235 := thrift.NewTMemoryBufferLen(len(arg234))
+    defer mbTrans235.Close()
+    _, err236 := mbTrans235.WriteString(arg234)
+    if err236 != nil { 
+      Usage()
+      return
+    }
+    factory237 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt238 := factory237.GetProtocol(mbTrans235)
+    containerStruct2 := ongrid2.NewOngridSignCentrifugoChannelsArgs()
+    err239 := containerStruct2.ReadField3(jsProt238)
+    if err239 != nil {
+      Usage()
+      return
+    }
+    argvalue2 := containerStruct2.Channels
+    value2 := argvalue2
+    fmt.Print(client.SignCentrifugoChannels(value0, value1, value2))
+    fmt.Print("\n")
+    break
   case "getConfiguration":
     if flag.NArg() - 1 != 1 {
       fmt.Fprintln(os.Stderr, "GetConfiguration requires 1 args")
@@ -263,8 +369,8 @@ func main() {
     }
     argvalue0 := flag.Arg(1)
     value0 := argvalue0
-    argvalue1, err147 := (strconv.ParseInt(flag.Arg(2), 10, 64))
-    if err147 != nil {
+    argvalue1, err245 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err245 != nil {
       Usage()
       return
     }
@@ -323,25 +429,25 @@ func main() {
     value1 := argvalue1
     argvalue2 := flag.Arg(3)
     value2 := argvalue2
-    argvalue3, err159 := (strconv.ParseInt(flag.Arg(4), 10, 64))
-    if err159 != nil {
+    argvalue3, err257 := (strconv.ParseInt(flag.Arg(4), 10, 64))
+    if err257 != nil {
       Usage()
       return
     }
     value3 := argvalue3
-    arg160 := flag.Arg(5)
-    mbTrans161 := thrift.NewTMemoryBufferLen(len(arg160))
-    defer mbTrans161.Close()
-    _, err162 := mbTrans161.WriteString(arg160)
-    if err162 != nil { 
+    arg258 := flag.Arg(5)
+    mbTrans259 := thrift.NewTMemoryBufferLen(len(arg258))
+    defer mbTrans259.Close()
+    _, err260 := mbTrans259.WriteString(arg258)
+    if err260 != nil { 
       Usage()
       return
     }
-    factory163 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt164 := factory163.GetProtocol(mbTrans161)
+    factory261 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt262 := factory261.GetProtocol(mbTrans259)
     containerStruct4 := ongrid2.NewOngridSendMessageToCustomerArgs()
-    err165 := containerStruct4.ReadField5(jsProt164)
-    if err165 != nil {
+    err263 := containerStruct4.ReadField5(jsProt262)
+    if err263 != nil {
       Usage()
       return
     }
@@ -359,19 +465,19 @@ func main() {
     value0 := argvalue0
     argvalue1 := flag.Arg(2)
     value1 := argvalue1
-    arg168 := flag.Arg(3)
-    mbTrans169 := thrift.NewTMemoryBufferLen(len(arg168))
-    defer mbTrans169.Close()
-    _, err170 := mbTrans169.WriteString(arg168)
-    if err170 != nil { 
+    arg266 := flag.Arg(3)
+    mbTrans267 := thrift.NewTMemoryBufferLen(len(arg266))
+    defer mbTrans267.Close()
+    _, err268 := mbTrans267.WriteString(arg266)
+    if err268 != nil { 
       Usage()
       return
     }
-    factory171 := thrift.NewTSimpleJSONProtocolFactory()
-    jsProt172 := factory171.GetProtocol(mbTrans169)
+    factory269 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt270 := factory269.GetProtocol(mbTrans267)
     containerStruct2 := ongrid2.NewOngridSendMessageToAllCustomersArgs()
-    err173 := containerStruct2.ReadField3(jsProt172)
-    if err173 != nil {
+    err271 := containerStruct2.ReadField3(jsProt270)
+    if err271 != nil {
       Usage()
       return
     }
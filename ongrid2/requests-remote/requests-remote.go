@@ -0,0 +1,294 @@
+// Autogenerated by Thrift Compiler (0.10.0)
+// DO NOT EDIT UNLESS YOU ARE SURE THAT YOU KNOW WHAT YOU ARE DOING
+
+package main
+
+import (
+        "flag"
+        "fmt"
+        "math"
+        "net"
+        "net/url"
+        "os"
+        "strconv"
+        "strings"
+        "git.apache.org/thrift.git/lib/go/thrift"
+        "ongrid2"
+)
+
+
+func Usage() {
+  fmt.Fprintln(os.Stderr, "Usage of ", os.Args[0], " [-h host:port] [-u url] [-f[ramed]] function [arg1 [arg2...]]:")
+  flag.PrintDefaults()
+  fmt.Fprintln(os.Stderr, "\nFunctions:")
+  fmt.Fprintln(os.Stderr, "  Request getRequest(string authToken, i32 requestId)")
+  fmt.Fprintln(os.Stderr, "  RequestList listRequests(string authToken, RequestFilter filter)")
+  fmt.Fprintln(os.Stderr, "  Request createRequest(string authToken, Request request)")
+  fmt.Fprintln(os.Stderr, "  Request updateRequest(string authToken, Request request)")
+  fmt.Fprintln(os.Stderr, "  Request setRequestStatus(string authToken, i32 requestId, RequestStatus status, string comment)")
+  fmt.Fprintln(os.Stderr, "   getRequestHistory(string authToken, i32 requestId)")
+  fmt.Fprintln(os.Stderr, "   getAvailableSlots(string authToken, i64 companyId, i64 date)")
+  fmt.Fprintln(os.Stderr)
+  os.Exit(0)
+}
+
+func main() {
+  flag.Usage = Usage
+  var host string
+  var port int
+  var protocol string
+  var urlString string
+  var framed bool
+  var useHttp bool
+  var parsedUrl url.URL
+  var trans thrift.TTransport
+  _ = strconv.Atoi
+  _ = math.Abs
+  flag.Usage = Usage
+  flag.StringVar(&host, "h", "localhost", "Specify host and port")
+  flag.IntVar(&port, "p", 9090, "Specify port")
+  flag.StringVar(&protocol, "P", "binary", "Specify the protocol (binary, compact, simplejson, json)")
+  flag.StringVar(&urlString, "u", "", "Specify the url")
+  flag.BoolVar(&framed, "framed", false, "Use framed transport")
+  flag.BoolVar(&useHttp, "http", false, "Use http")
+  flag.Parse()
+  
+  if len(urlString) > 0 {
+    parsedUrl, err := url.Parse(urlString)
+    if err != nil {
+      fmt.Fprintln(os.Stderr, "Error parsing URL: ", err)
+      flag.Usage()
+    }
+    host = parsedUrl.Host
+    useHttp = len(parsedUrl.Scheme) <= 0 || parsedUrl.Scheme == "http"
+  } else if useHttp {
+    _, err := url.Parse(fmt.Sprint("http://", host, ":", port))
+    if err != nil {
+      fmt.Fprintln(os.Stderr, "Error parsing URL: ", err)
+      flag.Usage()
+    }
+  }
+  
+  cmd := flag.Arg(0)
+  var err error
+  if useHttp {
+    trans, err = thrift.NewTHttpClient(parsedUrl.String())
+  } else {
+    portStr := fmt.Sprint(port)
+    if strings.Contains(host, ":") {
+           host, portStr, err = net.SplitHostPort(host)
+           if err != nil {
+                   fmt.Fprintln(os.Stderr, "error with host:", err)
+                   os.Exit(1)
+           }
+    }
+    trans, err = thrift.NewTSocket(net.JoinHostPort(host, portStr))
+    if err != nil {
+      fmt.Fprintln(os.Stderr, "error resolving address:", err)
+      os.Exit(1)
+    }
+    if framed {
+      trans = thrift.NewTFramedTransport(trans)
+    }
+  }
+  if err != nil {
+    fmt.Fprintln(os.Stderr, "Error creating transport", err)
+    os.Exit(1)
+  }
+  defer trans.Close()
+  var protocolFactory thrift.TProtocolFactory
+  switch protocol {
+  case "compact":
+    protocolFactory = thrift.NewTCompactProtocolFactory()
+    break
+  case "simplejson":
+    protocolFactory = thrift.NewTSimpleJSONProtocolFactory()
+    break
+  case "json":
+    protocolFactory = thrift.NewTJSONProtocolFactory()
+    break
+  case "binary", "":
+    protocolFactory = thrift.NewTBinaryProtocolFactoryDefault()
+    break
+  default:
+    fmt.Fprintln(os.Stderr, "Invalid protocol specified: ", protocol)
+    Usage()
+    os.Exit(1)
+  }
+  client := ongrid2.NewRequestsClientFactory(trans, protocolFactory)
+  if err := trans.Open(); err != nil {
+    fmt.Fprintln(os.Stderr, "Error opening socket to ", host, ":", port, " ", err)
+    os.Exit(1)
+  }
+  
+  switch cmd {
+  case "getRequest":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "GetRequest requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err293 := (strconv.Atoi(flag.Arg(2)))
+    if err293 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    fmt.Print(client.GetRequest(value0, value1))
+    fmt.Print("\n")
+    break
+  case "listRequests":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "ListRequests requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg295 := flag.Arg(2)
+    mbTrans296 := thrift.NewTMemoryBufferLen(len(arg295))
+    defer mbTrans296.Close()
+    _, err297 := mbTrans296.WriteString(arg295)
+    if err297 != nil {
+      Usage()
+      return
+    }
+    factory298 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt299 := factory298.GetProtocol(mbTrans296)
+    argvalue1 := ongrid2.NewRequestFilter()
+    err300 := argvalue1.Read(jsProt299)
+    if err300 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.ListRequests(value0, value1))
+    fmt.Print("\n")
+    break
+  case "createRequest":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "CreateRequest requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg302 := flag.Arg(2)
+    mbTrans303 := thrift.NewTMemoryBufferLen(len(arg302))
+    defer mbTrans303.Close()
+    _, err304 := mbTrans303.WriteString(arg302)
+    if err304 != nil {
+      Usage()
+      return
+    }
+    factory305 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt306 := factory305.GetProtocol(mbTrans303)
+    argvalue1 := ongrid2.NewRequest()
+    err307 := argvalue1.Read(jsProt306)
+    if err307 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.CreateRequest(value0, value1))
+    fmt.Print("\n")
+    break
+  case "updateRequest":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "UpdateRequest requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    arg309 := flag.Arg(2)
+    mbTrans310 := thrift.NewTMemoryBufferLen(len(arg309))
+    defer mbTrans310.Close()
+    _, err311 := mbTrans310.WriteString(arg309)
+    if err311 != nil {
+      Usage()
+      return
+    }
+    factory312 := thrift.NewTSimpleJSONProtocolFactory()
+    jsProt313 := factory312.GetProtocol(mbTrans310)
+    argvalue1 := ongrid2.NewRequest()
+    err314 := argvalue1.Read(jsProt313)
+    if err314 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    fmt.Print(client.UpdateRequest(value0, value1))
+    fmt.Print("\n")
+    break
+  case "setRequestStatus":
+    if flag.NArg() - 1 != 4 {
+      fmt.Fprintln(os.Stderr, "SetRequestStatus requires 4 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err316 := (strconv.Atoi(flag.Arg(2)))
+    if err316 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    tmp2, err := (strconv.Atoi(flag.Arg(3)))
+    if err != nil {
+      Usage()
+     return
+    }
+    argvalue2 := ongrid2.RequestStatus(tmp2)
+    value2 := argvalue2
+    argvalue3 := flag.Arg(4)
+    value3 := argvalue3
+    fmt.Print(client.SetRequestStatus(value0, value1, value2, value3))
+    fmt.Print("\n")
+    break
+  case "getRequestHistory":
+    if flag.NArg() - 1 != 2 {
+      fmt.Fprintln(os.Stderr, "GetRequestHistory requires 2 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    tmp1, err319 := (strconv.Atoi(flag.Arg(2)))
+    if err319 != nil {
+      Usage()
+      return
+    }
+    argvalue1 := int32(tmp1)
+    value1 := argvalue1
+    fmt.Print(client.GetRequestHistory(value0, value1))
+    fmt.Print("\n")
+    break
+  case "getAvailableSlots":
+    if flag.NArg() - 1 != 3 {
+      fmt.Fprintln(os.Stderr, "GetAvailableSlots requires 3 args")
+      flag.Usage()
+    }
+    argvalue0 := flag.Arg(1)
+    value0 := argvalue0
+    argvalue1, err321 := (strconv.ParseInt(flag.Arg(2), 10, 64))
+    if err321 != nil {
+      Usage()
+      return
+    }
+    value1 := argvalue1
+    argvalue2, err322 := (strconv.ParseInt(flag.Arg(3), 10, 64))
+    if err322 != nil {
+      Usage()
+      return
+    }
+    value2 := argvalue2
+    fmt.Print(client.GetAvailableSlots(value0, value1, value2))
+    fmt.Print("\n")
+    break
+  case "":
+    Usage()
+    break
+  default:
+    fmt.Fprintln(os.Stderr, "Invalid function ", cmd)
+  }
+}
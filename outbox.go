@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Outbox dispatch: rows published per database and pass, the delay before a
+// failed row is retried, doubling up to outboxMaxBackoff, how often the list
+// of client databases is reloaded and how many databases are dispatched at once
+const (
+	outboxBatch      = 100
+	outboxBackoff    = time.Second
+	outboxMaxBackoff = 5 * time.Minute
+	outboxRefresh    = time.Minute
+	outboxWorkers    = 8
+)
+
+// outboxPublishError is returned by dispatchOutbox when the publisher failed,
+// unlike a database error it affects all databases
+type outboxPublishError struct {
+	id  int64
+	err error
+}
+
+func (e *outboxPublishError) Error() string {
+	return fmt.Sprintf("outbox row %d: %v", e.id, e.err)
+}
+
+// outboxRow is a row of igo$outbox
+type outboxRow struct {
+	ID       int64  `db:"ID"`
+	Channels string `db:"CHANNELS"`
+	Payload  []byte `db:"PAYLOAD"`
+	Attempts int    `db:"ATTEMPTS"`
+}
+
+// enqueue writes the realtime message to the outbox in the transaction of the
+// domain change, it is published by the OutboxDispatcher after commit
+func enqueue(ctx context.Context, tx *sqlx.Tx, channels []string, payload interface{}, now time.Time) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return -1, err
+	}
+
+	var id int64
+	if err = tx.GetContext(ctx, &id, "select gen_id(gen_igo$outbox_id, 1) from rdb$database"); err != nil {
+		log.Printf("enqueue, select gen_id: %v", err)
+		return -1, err
+	}
+	_, err = tx.ExecContext(ctx, "insert into igo$outbox (id, channels, payload, created_at, attempts, next_at) values (?, ?, ?, ?, 0, ?)",
+		id, strings.Join(channels, ","), string(data), now, now)
+	if err != nil {
+		log.Printf("enqueue, insert into igo$outbox: %v", err)
+		return -1, err
+	}
+	return id, nil
+}
+
+// enqueueMessage writes the customer message to the outbox for the channels
+// of its owner and customer
+func enqueueMessage(ctx context.Context, tx *sqlx.Tx, payload messagePayload, now time.Time) error {
+	channels := []string{ownerChannel(payload.Owner), customerChannel(payload.Customer)}
+	_, err := enqueue(ctx, tx, channels, payload, now)
+	return err
+}
+
+// OutboxDispatcher publishes pending rows of igo$outbox of all client
+// databases configured in mongo and marks them delivered. It uses its own
+// connections, so rows of a client without an open session are delivered too.
+// Delivery is at least once: a row is published again if marking it fails, and
+// rows may be delivered out of order after a failure.
+type OutboxDispatcher struct {
+	conns     *ConnectionManager
+	publisher Publisher
+	cfg       *Config
+	interval  time.Duration
+	wake      chan struct{}
+
+	// used only by the Run goroutine: client databases by connection string,
+	// the list is reloaded every outboxRefresh
+	databases   map[string]Database
+	refreshedAt time.Time
+
+	mu  sync.Mutex
+	dbs map[string]*sqlx.DB
+}
+
+// NewOutboxDispatcher ...
+func NewOutboxDispatcher(conns *ConnectionManager, publisher Publisher, cfg *Config, interval time.Duration) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		conns:     conns,
+		publisher: publisher,
+		cfg:       cfg,
+		interval:  interval,
+		wake:      make(chan struct{}, 1),
+		dbs:       make(map[string]*sqlx.DB),
+	}
+}
+
+// Notify wakes the dispatcher up after a commit with outbox rows
+func (d *OutboxDispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches every interval and on Notify, it returns when stop is closed
+// and closes the connections of the dispatcher
+func (d *OutboxDispatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	defer d.closeDBs()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		d.dispatch(time.Now())
+	}
+}
+
+// dispatch publishes the outbox of every client database once, a database
+// of several clients is dispatched once. Up to outboxWorkers databases are
+// dispatched at once. After a failed publish the publisher is likely down, the
+// databases not started yet are left to the next pass.
+func (d *OutboxDispatcher) dispatch(now time.Time) {
+	d.refresh(now)
+
+	conns := make(chan string)
+	var failed int32
+	var wg sync.WaitGroup
+	for i := 0; i < outboxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for conn := range conns {
+				if atomic.LoadInt32(&failed) == 0 && d.dispatchDB(conn, now) {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+
+	for conn := range d.databases {
+		if atomic.LoadInt32(&failed) != 0 {
+			break
+		}
+		conns <- conn
+	}
+	close(conns)
+	wg.Wait()
+}
+
+// dispatchDB publishes the outbox of one client database, it reports whether
+// a publish failed
+func (d *OutboxDispatcher) dispatchDB(conn string, now time.Time) (publishFailed bool) {
+	db, err := d.db(conn)
+	if err != nil {
+		database := d.databases[conn]
+		log.Printf("OutboxDispatcher: connect to %s:%d/%s/%s: %v", database.host, database.port, database.path, database.dataDB, err)
+		return false
+	}
+
+	ctx, cancel := callContext(d.cfg.QueryTimeout)
+	defer cancel()
+	sent, err := dispatchOutbox(ctx, db, d.publisher, now)
+	if err != nil {
+		log.Printf("OutboxDispatcher: %d published, %v", sent, err)
+	}
+	_, publishFailed = err.(*outboxPublishError)
+	return publishFailed
+}
+
+// refresh reloads the client databases from mongo every outboxRefresh and
+// closes the connections of removed databases. On error the old list is kept.
+func (d *OutboxDispatcher) refresh(now time.Time) {
+	if d.databases != nil && now.Sub(d.refreshedAt) < outboxRefresh {
+		return
+	}
+	d.refreshedAt = now
+
+	mongo, err := d.conns.Mongo()
+	if err != nil {
+		log.Printf("OutboxDispatcher: %v", err)
+		return
+	}
+	list, err := mongo.GetClientDatabases()
+	if err != nil {
+		log.Printf("OutboxDispatcher: load client databases: %v", err)
+		return
+	}
+
+	databases := make(map[string]Database, len(list))
+	for _, database := range list {
+		databases[getDataConnectionString(&User{DB: database})] = database
+	}
+	d.mu.Lock()
+	for conn, db := range d.dbs {
+		if _, ok := databases[conn]; !ok {
+			db.Close()
+			delete(d.dbs, conn)
+		}
+	}
+	d.mu.Unlock()
+	d.databases = databases
+}
+
+// db returns the connection of the dispatcher to the client database,
+// connecting if needed. A database is dispatched by one worker in a pass, so
+// it connects without the lock.
+func (d *OutboxDispatcher) db(conn string) (*sqlx.DB, error) {
+	d.mu.Lock()
+	db, ok := d.dbs[conn]
+	d.mu.Unlock()
+	if ok {
+		return db, nil
+	}
+
+	db, err := sqlx.Connect("firebirdsql", conn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	d.mu.Lock()
+	d.dbs[conn] = db
+	d.mu.Unlock()
+	return db, nil
+}
+
+// closeDBs closes the connections of the dispatcher
+func (d *OutboxDispatcher) closeDBs() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for conn, db := range d.dbs {
+		db.Close()
+		delete(d.dbs, conn)
+	}
+}
+
+// dispatchOutbox publishes the pending rows of the database in id order. It
+// stops at the first failed publish, the row is retried after a backoff.
+func dispatchOutbox(ctx context.Context, db *sqlx.DB, pub Publisher, now time.Time) (sent int, err error) {
+	var rows []outboxRow
+	err = db.SelectContext(ctx, &rows, fmt.Sprintf("select first %d id, channels, payload, attempts from igo$outbox"+
+		" where delivered_at is null and next_at <= ? order by id", outboxBatch), now)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range rows {
+		attempts := row.Attempts + 1
+		if err = pub.Publish(strings.Split(row.Channels, ","), row.Payload); err != nil {
+			_, e := db.ExecContext(ctx, "update igo$outbox set attempts = ?, next_at = ? where id = ?",
+				attempts, now.Add(outboxRetryDelay(attempts)), row.ID)
+			if e != nil {
+				log.Printf("dispatchOutbox, update igo$outbox %d: %v", row.ID, e)
+			}
+			return sent, &outboxPublishError{id: row.ID, err: err}
+		}
+		_, err = db.ExecContext(ctx, "update igo$outbox set attempts = ?, delivered_at = ? where id = ?", attempts, now, row.ID)
+		if err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// outboxRetryDelay is the delay after the failed attempt
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := outboxBackoff
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxBackoff {
+		delay = outboxMaxBackoff
+	}
+	return delay
+}
+
+// replayOutbox marks the rows with id >= fromID undelivered, the dispatcher
+// publishes them again. It returns the number of rows.
+func replayOutbox(ctx context.Context, db *sqlx.DB, fromID int64, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, "update igo$outbox set delivered_at = null, attempts = 0, next_at = ? where id >= ?", now, fromID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
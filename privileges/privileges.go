@@ -229,8 +229,53 @@ func (s *ACLService) GetACL(userID int) (privileges []*ongrid2.Privilege, err er
 	return
 }
 
+// Permissions checked by the server
+const (
+	// PermissionEventsReplay allows the user to replay events of the outbox
+	PermissionEventsReplay = "events.replay"
+	// PermissionRequestsRead allows the user to read requests of his company
+	PermissionRequestsRead = "requests.read"
+	// PermissionRequestsWrite allows the user to create and change requests of
+	// his company
+	PermissionRequestsWrite = "requests.write"
+	// PermissionCrmRead allows the user to read clients, persons, companies
+	// and cars of the Crm service
+	PermissionCrmRead = "crm.read"
+	// PermissionCrmWrite allows the user to create and change them
+	PermissionCrmWrite = "crm.write"
+)
+
+// HasPermission reports whether a role of the user allows the permission and
+// no role denies it
+func (s *ACLService) HasPermission(userID int, name string) bool {
+	user, ok := s.users[userID]
+	if !ok {
+		return false
+	}
+
+	allowed := false
+	for _, role := range user.getAllRoles() {
+		if role == nil {
+			continue
+		}
+		for _, permission := range role.getPermissions() {
+			if permission == nil || permission.Name != name {
+				continue
+			}
+			if permission.PermissionType != 1 {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
 func (u *User) getAllRoles() []*Role {
-	roles := u.Group.getAllRoles()
+	var roles []*Role
+	if u.Group != nil {
+		roles = append(roles, u.Group.getAllRoles()...)
+	}
 	if u.Role != nil {
 		roles = append(roles, u.Role)
 	}
@@ -0,0 +1,190 @@
+package privileges
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StatementClass is the kind of an sql statement the policy is checked for
+type StatementClass int
+
+// Statement classes
+const (
+	StatementUnknown StatementClass = iota
+	StatementSelect
+	StatementDML
+	StatementDDL
+	StatementExecute
+	StatementExecuteBlock
+)
+
+func (c StatementClass) String() string {
+	switch c {
+	case StatementSelect:
+		return "SELECT"
+	case StatementDML:
+		return "DML"
+	case StatementDDL:
+		return "DDL"
+	case StatementExecute:
+		return "EXECUTE PROCEDURE"
+	case StatementExecuteBlock:
+		return "EXECUTE BLOCK"
+	}
+	return "UNKNOWN"
+}
+
+// Permission names for sql statements in og$permissions. PERMISSION_TYPE 1
+// allows the statement class, any other value denies it.
+const (
+	PermissionSQLSelect  = "sql.select"
+	PermissionSQLDML     = "sql.dml"
+	PermissionSQLDDL     = "sql.ddl"
+	PermissionSQLExecute = "sql.execute"
+)
+
+var statementPermissions = map[string]StatementClass{
+	PermissionSQLSelect:  StatementSelect,
+	PermissionSQLDML:     StatementDML,
+	PermissionSQLDDL:     StatementDDL,
+	PermissionSQLExecute: StatementExecute,
+}
+
+var statementKeywords = map[string]StatementClass{
+	"select":    StatementSelect,
+	"with":      StatementSelect,
+	"insert":    StatementDML,
+	"update":    StatementDML,
+	"delete":    StatementDML,
+	"merge":     StatementDML,
+	"create":    StatementDDL,
+	"alter":     StatementDDL,
+	"drop":      StatementDDL,
+	"recreate":  StatementDDL,
+	"declare":   StatementDDL,
+	"grant":     StatementDDL,
+	"revoke":    StatementDDL,
+	"comment":   StatementDDL,
+	"set":       StatementDDL,
+	"reconnect": StatementDDL,
+}
+
+// ClassifyStatement returns the class of the statement by its first keyword,
+// leading comments are skipped. "update or insert" is DML. "execute" is
+// classified by its second keyword: "execute procedure" is EXECUTE PROCEDURE,
+// "execute block" is EXECUTE BLOCK, which can run any statement with
+// "execute statement".
+func ClassifyStatement(sql string) StatementClass {
+	keyword, rest := nextKeyword(sql)
+	if keyword == "execute" {
+		switch second, _ := nextKeyword(rest); second {
+		case "procedure":
+			return StatementExecute
+		case "block":
+			return StatementExecuteBlock
+		}
+		return StatementUnknown
+	}
+
+	if class, ok := statementKeywords[keyword]; ok {
+		return class
+	}
+	return StatementUnknown
+}
+
+// nextKeyword returns the lower case first word of s after comments and the
+// rest of s
+func nextKeyword(s string) (string, string) {
+	s = skipComments(s)
+
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if end < 0 {
+		end = len(s)
+	}
+	return strings.ToLower(s[:end]), s[end:]
+}
+
+func skipComments(s string) string {
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, "--"):
+			i := strings.IndexByte(s, '\n')
+			if i < 0 {
+				return ""
+			}
+			s = s[i+1:]
+		case strings.HasPrefix(s, "/*"):
+			i := strings.Index(s, "*/")
+			if i < 0 {
+				return ""
+			}
+			s = s[i+2:]
+		default:
+			return s
+		}
+	}
+}
+
+// SQLPolicy tells which statement classes a user may run
+type SQLPolicy struct {
+	allowed map[StatementClass]bool
+}
+
+// DefaultSQLPolicy allows SELECT, DML and EXECUTE PROCEDURE and denies DDL and
+// EXECUTE BLOCK
+func DefaultSQLPolicy() *SQLPolicy {
+	return &SQLPolicy{allowed: map[StatementClass]bool{
+		StatementSelect:  true,
+		StatementDML:     true,
+		StatementExecute: true,
+	}}
+}
+
+// Allowed reports whether the statement class is allowed. EXECUTE BLOCK is
+// allowed with DDL, sql.ddl permits both.
+func (p *SQLPolicy) Allowed(class StatementClass) bool {
+	if class == StatementExecuteBlock {
+		return p.allowed[StatementDDL]
+	}
+	return p.allowed[class]
+}
+
+// GetSQLPolicy builds the sql policy of the user: the default policy changed
+// by sql.* permissions of all user roles. A deny in any role wins.
+func (s *ACLService) GetSQLPolicy(userID int) (*SQLPolicy, error) {
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("User not found, userID: %d", userID)
+	}
+
+	policy := DefaultSQLPolicy()
+	denied := make(map[StatementClass]bool)
+
+	for _, role := range user.getAllRoles() {
+		if role == nil {
+			continue
+		}
+		for _, permission := range role.getPermissions() {
+			if permission == nil {
+				continue
+			}
+			class, ok := statementPermissions[permission.Name]
+			if !ok {
+				continue
+			}
+			if permission.PermissionType == 1 {
+				policy.allowed[class] = true
+			} else {
+				denied[class] = true
+			}
+		}
+	}
+	for class := range denied {
+		policy.allowed[class] = false
+	}
+
+	return policy, nil
+}
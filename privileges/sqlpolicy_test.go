@@ -0,0 +1,115 @@
+package privileges
+
+import "testing"
+
+func TestClassifyStatement(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want StatementClass
+	}{
+		{"select * from sys$clients", StatementSelect},
+		{"  SELECT 1 from rdb$database", StatementSelect},
+		{"with t as (select 1 x from rdb$database) select x from t", StatementSelect},
+		{"-- comment\nselect 1 from rdb$database", StatementSelect},
+		{"/* a */ /* b */ select 1 from rdb$database", StatementSelect},
+		{"insert into t (a) values (1)", StatementDML},
+		{"update t set a = 1", StatementDML},
+		{"update or insert into t (a) values (1) matching (a)", StatementDML},
+		{"delete from t", StatementDML},
+		{"merge into t using s on t.a = s.a when matched then delete", StatementDML},
+		{"create table t (a integer)", StatementDDL},
+		{"alter table t add b integer", StatementDDL},
+		{"drop table t", StatementDDL},
+		{"recreate table t (a integer)", StatementDDL},
+		{"grant select on t to u", StatementDDL},
+		{"set generator g to 0", StatementDDL},
+		{"execute procedure p(1)", StatementExecute},
+		{"EXECUTE  PROCEDURE p", StatementExecute},
+		{"execute /* x */ procedure p", StatementExecute},
+		{"execute block as begin execute statement 'drop table t'; end", StatementExecuteBlock},
+		{"Execute\nBlock returns (a integer) as begin suspend; end", StatementExecuteBlock},
+		{"execute statement 'drop table t'", StatementUnknown},
+		{"execute", StatementUnknown},
+		{"", StatementUnknown},
+		{"-- only a comment", StatementUnknown},
+		{"/* unterminated select", StatementUnknown},
+		{"commit", StatementUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyStatement(tt.sql); got != tt.want {
+			t.Errorf("ClassifyStatement(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultSQLPolicy(t *testing.T) {
+	policy := DefaultSQLPolicy()
+	want := map[StatementClass]bool{
+		StatementUnknown:      false,
+		StatementSelect:       true,
+		StatementDML:          true,
+		StatementDDL:          false,
+		StatementExecute:      true,
+		StatementExecuteBlock: false,
+	}
+	for class, allowed := range want {
+		if got := policy.Allowed(class); got != allowed {
+			t.Errorf("Allowed(%v) = %v, want %v", class, got, allowed)
+		}
+	}
+}
+
+func TestGetSQLPolicy(t *testing.T) {
+	permission := func(name string, allow bool) *Permission {
+		p := &Permission{Name: name}
+		if allow {
+			p.PermissionType = 1
+		}
+		return p
+	}
+	admin := &Role{Name: "admin", Permissions: []*Permission{permission(PermissionSQLDDL, true)}}
+	readOnly := &Role{Name: "readonly", Permissions: []*Permission{
+		permission(PermissionSQLDML, false),
+		permission(PermissionSQLExecute, false),
+	}}
+
+	s := &ACLService{users: map[int]*User{
+		1: {ID: 1},
+		2: {ID: 2, Role: admin},
+		3: {ID: 3, Role: readOnly},
+		// a deny in any role wins over an allow
+		4: {ID: 4, Role: admin, Group: &Group{Roles: []*Role{{Permissions: []*Permission{permission(PermissionSQLDDL, false)}}}}},
+	}}
+
+	tests := []struct {
+		userID  int
+		allowed []StatementClass
+	}{
+		{1, []StatementClass{StatementSelect, StatementDML, StatementExecute}},
+		{2, []StatementClass{StatementSelect, StatementDML, StatementDDL, StatementExecute, StatementExecuteBlock}},
+		{3, []StatementClass{StatementSelect}},
+		{4, []StatementClass{StatementSelect, StatementDML, StatementExecute}},
+	}
+	classes := []StatementClass{StatementUnknown, StatementSelect, StatementDML, StatementDDL, StatementExecute, StatementExecuteBlock}
+
+	for _, tt := range tests {
+		policy, err := s.GetSQLPolicy(tt.userID)
+		if err != nil {
+			t.Fatalf("user %d: %v", tt.userID, err)
+		}
+		for _, class := range classes {
+			want := false
+			for _, c := range tt.allowed {
+				want = want || c == class
+			}
+			if got := policy.Allowed(class); got != want {
+				t.Errorf("user %d: Allowed(%v) = %v, want %v", tt.userID, class, got, want)
+			}
+		}
+	}
+
+	if _, err := s.GetSQLPolicy(5); err == nil {
+		t.Error("unknown user: no error")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// Publisher delivers realtime messages to the subscribers of channels
+type Publisher interface {
+	// Publish sends data to every channel
+	Publish(channels []string, data []byte) error
+}
+
+// Message is data published to a channel
+type Message struct {
+	Channel string
+	Data    []byte
+}
+
+// newPublisher returns the publisher of the server: messages always go to the
+// in-process hub and to Centrifugo when ckey is set
+func newPublisher(cfg *Config, hub *LocalPublisher) Publisher {
+	if cfg.Centrifugo.secret == "" {
+		log.Println("Centrifugo is not configured, realtime messages are delivered in-process only")
+		return hub
+	}
+	return multiPublisher{hub, NewCentrifugoPublisher(cfg.Centrifugo)}
+}
+
+// multiPublisher publishes to every publisher in order, the first error is returned
+type multiPublisher []Publisher
+
+func (m multiPublisher) Publish(channels []string, data []byte) (err error) {
+	for _, p := range m {
+		if e := p.Publish(channels, data); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// localBufferSize is the number of undelivered messages a local subscription
+// keeps, later messages are dropped until the subscriber reads
+const localBufferSize = 16
+
+// LocalPublisher is an in-process pub/sub. It backs WaitEvents, so desktops
+// without websocket access get updates over thrift. Delivery never blocks the
+// publisher: a slow subscriber loses messages beyond localBufferSize.
+type LocalPublisher struct {
+	mu     sync.Mutex
+	subs   map[string]map[*Subscription]struct{}
+	closed bool
+}
+
+// NewLocalPublisher ...
+func NewLocalPublisher() *LocalPublisher {
+	return &LocalPublisher{subs: make(map[string]map[*Subscription]struct{})}
+}
+
+// Subscription receives messages of its channels on C until Unsubscribe or
+// the publisher Close, C is closed then
+type Subscription struct {
+	C <-chan Message
+
+	c        chan Message
+	hub      *LocalPublisher
+	channels []string
+	closed   bool
+}
+
+// Subscribe subscribes to the channels
+func (h *LocalPublisher) Subscribe(channels ...string) *Subscription {
+	c := make(chan Message, localBufferSize)
+	sub := &Subscription{C: c, c: c, hub: h, channels: channels}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		sub.closed = true
+		close(c)
+		return sub
+	}
+	for _, channel := range channels {
+		if h.subs[channel] == nil {
+			h.subs[channel] = make(map[*Subscription]struct{})
+		}
+		h.subs[channel][sub] = struct{}{}
+	}
+	return sub
+}
+
+// Unsubscribe ...
+func (s *Subscription) Unsubscribe() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
+
+// remove unsubscribes and closes the subscription, h.mu must be held
+func (h *LocalPublisher) remove(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	for _, channel := range sub.channels {
+		delete(h.subs[channel], sub)
+		if len(h.subs[channel]) == 0 {
+			delete(h.subs, channel)
+		}
+	}
+	sub.closed = true
+	close(sub.c)
+}
+
+// Publish ...
+func (h *LocalPublisher) Publish(channels []string, data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, channel := range channels {
+		for sub := range h.subs[channel] {
+			select {
+			case sub.c <- Message{Channel: channel, Data: data}:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes all subscriptions, later subscriptions are closed at once. It
+// wakes up waiting WaitEvents calls on server stop.
+func (h *LocalPublisher) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
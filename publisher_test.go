@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPublisher records published messages and returns err
+type recordingPublisher struct {
+	err error
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (r *recordingPublisher) Publish(channels []string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, channel := range channels {
+		r.messages = append(r.messages, Message{Channel: channel, Data: data})
+	}
+	return r.err
+}
+
+func receive(t *testing.T, sub *Subscription) Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+	return Message{}
+}
+
+func TestLocalPublisherFanOut(t *testing.T) {
+	hub := NewLocalPublisher()
+	a := hub.Subscribe("$messages:1", "$customer:2")
+	b := hub.Subscribe("$customer:2")
+	other := hub.Subscribe("$customer:3")
+
+	if err := hub.Publish([]string{"$customer:2"}, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []*Subscription{a, b} {
+		if msg := receive(t, sub); msg.Channel != "$customer:2" || string(msg.Data) != "hello" {
+			t.Errorf("got %s %q", msg.Channel, msg.Data)
+		}
+	}
+	select {
+	case msg := <-other.C:
+		t.Errorf("other channel got %s", msg.Channel)
+	default:
+	}
+
+	b.Unsubscribe()
+	if _, ok := <-b.C; ok {
+		t.Error("unsubscribed channel is open")
+	}
+	hub.Publish([]string{"$messages:1"}, []byte("again"))
+	if msg := receive(t, a); string(msg.Data) != "again" {
+		t.Errorf("got %q", msg.Data)
+	}
+}
+
+func TestLocalPublisherDropsForSlowSubscriber(t *testing.T) {
+	hub := NewLocalPublisher()
+	sub := hub.Subscribe("c")
+
+	for i := 0; i < localBufferSize+5; i++ {
+		hub.Publish([]string{"c"}, []byte{byte(i)})
+	}
+	if n := len(sub.C); n != localBufferSize {
+		t.Errorf("buffered %d, want %d", n, localBufferSize)
+	}
+}
+
+func TestLocalPublisherCloseWakesSubscribers(t *testing.T) {
+	hub := NewLocalPublisher()
+	sub := hub.Subscribe("c")
+
+	done := make(chan bool)
+	go func() {
+		_, ok := <-sub.C
+		done <- ok
+	}()
+	hub.Close()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("got a message instead of close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber not woken up")
+	}
+	if _, ok := <-hub.Subscribe("c").C; ok {
+		t.Error("subscription after Close is open")
+	}
+}
+
+func TestMultiPublisherPublishesToAll(t *testing.T) {
+	failing := &recordingPublisher{err: errors.New("down")}
+	ok := &recordingPublisher{}
+
+	err := multiPublisher{failing, ok}.Publish([]string{"a", "b"}, []byte("x"))
+	if err == nil || err.Error() != "down" {
+		t.Errorf("err = %v", err)
+	}
+	if len(failing.messages) != 2 || len(ok.messages) != 2 {
+		t.Errorf("published %d and %d messages, want 2 and 2", len(failing.messages), len(ok.messages))
+	}
+}
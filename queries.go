@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"ongrid-thrift/ongrid2"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueryNotFound is returned by CancelQuery for an unknown or finished query id
+var ErrQueryNotFound = errors.New("Query not found")
+
+// callContext returns a context with the timeout deadline for database calls
+// outside of a session, 0 timeout disables the deadline
+func callContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// runningQuery is a database call the client can cancel by id
+type runningQuery struct {
+	cancel context.CancelFunc
+}
+
+// queryContext returns the context a database call runs under. It has the
+// querytimeout deadline and is registered in the session under the given
+// client query ids for CancelQuery. The returned func must be called when the
+// call is done.
+func (s *Session) queryContext(ids ...string) (context.Context, func()) {
+	ctx, cancel := callContext(s.cfg.QueryTimeout)
+	q := s.registerQuery(cancel, ids...)
+	return ctx, func() {
+		s.unregisterQuery(q, ids...)
+		cancel()
+	}
+}
+
+// registerQuery adds the call under the non empty ids, a running call with
+// the same id can no longer be cancelled
+func (s *Session) registerQuery(cancel context.CancelFunc, ids ...string) *runningQuery {
+	q := &runningQuery{cancel: cancel}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if id != "" {
+			s.running[id] = q
+		}
+	}
+	return q
+}
+
+func (s *Session) unregisterQuery(q *runningQuery, ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if s.running[id] == q {
+			delete(s.running, id)
+		}
+	}
+}
+
+// queryIDs returns the client ids of the queries, nil queries are skipped
+func queryIDs(queries ...*ongrid2.Query) []string {
+	var ids []string
+	for _, query := range queries {
+		if query != nil {
+			ids = append(ids, query.GetID())
+		}
+	}
+	return ids
+}
+
+// cancelQuery cancels the running call registered under the id
+func (s *Session) cancelQuery(id string) error {
+	s.mu.Lock()
+	q, ok := s.running[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrQueryNotFound
+	}
+	q.cancel()
+	return nil
+}
+
+// cancelQueries cancels all running calls of the session
+func (s *Session) cancelQueries() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, q := range s.running {
+		delete(s.running, id)
+		q.cancel()
+	}
+}
+
+// callTimer cancels a context that outlives one call, like the context of a
+// cursor, when the call runs longer than timeout
+type callTimer struct {
+	t     *time.Timer
+	fired int32
+}
+
+func startCallTimer(timeout time.Duration, cancel context.CancelFunc) *callTimer {
+	ct := &callTimer{}
+	if timeout > 0 {
+		ct.t = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&ct.fired, 1)
+			cancel()
+		})
+	}
+	return ct
+}
+
+// stop stops the timer and reports whether the call timed out
+func (ct *callTimer) stop() bool {
+	if ct.t != nil {
+		ct.t.Stop()
+	}
+	return atomic.LoadInt32(&ct.fired) == 1
+}
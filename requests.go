@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"ongrid-thrift/privileges"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ListRequests paging: the default and the largest page size
+const (
+	defaultRequestsPage = 100
+	maxRequestsPage     = 1000
+)
+
+// RequestsHandler serves the Requests service, service requests of customers
+// stored in sys$requests of the system database
+type RequestsHandler struct {
+	conns    *ConnectionManager
+	cfg      *Config
+	sessions SessionStore
+}
+
+// NewRequestsHandler ...
+func NewRequestsHandler(conns *ConnectionManager, cfg *Config, sessions SessionStore) *RequestsHandler {
+	return &RequestsHandler{conns: conns, cfg: cfg, sessions: sessions}
+}
+
+// GetRequest возвращает заявку компании сессии по id
+func (p *RequestsHandler) GetRequest(authToken string, requestID int32) (_ *ongrid2.Request, err error) {
+	defer mapError(&err)
+
+	_, companyID, err := p.requestsAccess(authToken, privileges.PermissionRequestsRead)
+	if err != nil {
+		return nil, err
+	}
+	db, err := p.conns.SystemDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := callContext(p.cfg.QueryTimeout)
+	defer cancel()
+
+	if err = checkRequestCompany(ctx, db, int(requestID), companyID, false); err != nil {
+		return nil, err
+	}
+	return loadRequest(ctx, db, int(requestID))
+}
+
+// ListRequests возвращает страницу заявок компании сессии, отобранных filter,
+// от новых к старым
+func (p *RequestsHandler) ListRequests(authToken string, filter *ongrid2.RequestFilter) (_ *ongrid2.RequestList, err error) {
+	defer mapError(&err)
+
+	_, companyID, err := p.requestsAccess(authToken, privileges.PermissionRequestsRead)
+	if err != nil {
+		return nil, err
+	}
+	db, err := p.conns.SystemDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := callContext(p.cfg.QueryTimeout)
+	defer cancel()
+
+	where, args, err := requestsWhere(companyID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &ongrid2.RequestList{Requests: []*ongrid2.Request{}}
+	if err = db.GetContext(ctx, &list.Total, "select count(*) from sys$requests"+where, args...); err != nil {
+		log.Printf("ListRequests, count sys$requests error: %v", err)
+		return nil, sqlError(err)
+	}
+
+	limit, offset := defaultRequestsPage, 0
+	if filter != nil {
+		if n := int(filter.GetLimit()); n > 0 {
+			limit = n
+		}
+		if n := int(filter.GetOffset()); n > 0 {
+			offset = n
+		}
+	}
+	if limit > maxRequestsPage {
+		limit = maxRequestsPage
+	}
+
+	var dbRequests []DBRequest
+	err = db.SelectContext(ctx, &dbRequests, fmt.Sprintf("select first %d skip %d * from sys$requests%s order by id desc", limit, offset, where), args...)
+	if err != nil {
+		log.Printf("ListRequests, select from sys$requests error: %v", err)
+		return nil, sqlError(err)
+	}
+	if list.Requests, err = requestsFromDB(ctx, db, dbRequests); err != nil {
+		return nil, sqlError(err)
+	}
+
+	return list, nil
+}
+
+// CreateRequest создает заявку компании сессии и событие REQUEST о ней. Если
+// статус не задан, заявка создается в статусе STATUS_NEW, время создания по
+// умолчанию - текущее. Начальный статус пишется в историю.
+func (p *RequestsHandler) CreateRequest(authToken string, request *ongrid2.Request) (_ *ongrid2.Request, err error) {
+	defer mapError(&err)
+
+	session, companyID, err := p.requestsAccess(authToken, privileges.PermissionRequestsWrite)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, userError(ongrid2.ErrorCode_DATA_INCORRECT, "Request is empty")
+	}
+	if err = checkCompany(request, companyID); err != nil {
+		return nil, err
+	}
+	if request.Status == 0 {
+		request.Status = ongrid2.RequestStatus_STATUS_NEW
+	}
+	if request.CreatedDateTime == 0 {
+		request.CreatedDateTime = time.Now().Unix()
+	}
+
+	if err = checkInitialStatus(request.Status); err != nil {
+		return nil, err
+	}
+
+	var requestID int
+	return p.writeRequest(func(ctx context.Context, tx *sqlx.Tx) (err error) {
+		if requestID, err = insertRequest(ctx, tx, p.cfg.Location(), request); err != nil {
+			return err
+		}
+		return addRequestHistory(ctx, tx, requestID, sql.NullInt64{}, request.Status, sessionActor(session), "")
+	}, &requestID)
+}
+
+// UpdateRequest изменяет заявку request.id компании сессии и создает событие
+// REQUEST о ней. Статус не меняется, для этого есть SetRequestStatus.
+func (p *RequestsHandler) UpdateRequest(authToken string, request *ongrid2.Request) (_ *ongrid2.Request, err error) {
+	defer mapError(&err)
+
+	_, companyID, err := p.requestsAccess(authToken, privileges.PermissionRequestsWrite)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, userError(ongrid2.ErrorCode_DATA_INCORRECT, "Request is empty")
+	}
+	if err = checkCompany(request, companyID); err != nil {
+		return nil, err
+	}
+
+	requestID := int(request.ID)
+	return p.writeRequest(func(ctx context.Context, tx *sqlx.Tx) error {
+		if err := checkRequestCompany(ctx, tx, requestID, companyID, true); err != nil {
+			return err
+		}
+		return updateRequest(ctx, tx, p.cfg.Location(), requestID, request)
+	}, &requestID)
+}
+
+// SetRequestStatus переводит заявку компании сессии в статус status и создает
+// событие REQUEST о ней. Недопустимый переход возвращает InvalidTransition,
+// переход пишется в историю с комментарием comment.
+func (p *RequestsHandler) SetRequestStatus(authToken string, requestID int32, status ongrid2.RequestStatus, comment string) (_ *ongrid2.Request, err error) {
+	defer mapError(&err)
+
+	session, companyID, err := p.requestsAccess(authToken, privileges.PermissionRequestsWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	id := int(requestID)
+	return p.writeRequest(func(ctx context.Context, tx *sqlx.Tx) error {
+		if err := checkRequestCompany(ctx, tx, id, companyID, true); err != nil {
+			return err
+		}
+		return changeRequestStatus(ctx, tx, p.cfg.Location(), id, status, sessionActor(session), comment)
+	}, &id)
+}
+
+// GetRequestHistory возвращает историю статусов заявки компании сессии
+func (p *RequestsHandler) GetRequestHistory(authToken string, requestID int32) (_ []*ongrid2.RequestStatusChange, err error) {
+	defer mapError(&err)
+
+	_, companyID, err := p.requestsAccess(authToken, privileges.PermissionRequestsRead)
+	if err != nil {
+		return nil, err
+	}
+	db, err := p.conns.SystemDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := callContext(p.cfg.QueryTimeout)
+	defer cancel()
+
+	if err = checkRequestCompany(ctx, db, int(requestID), companyID, false); err != nil {
+		return nil, err
+	}
+	history, err := getRequestHistory(ctx, db, int(requestID))
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return history, nil
+}
+
+// GetAvailableSlots возвращает слоты рабочего дня компании сессии, в которых
+// есть свободные посты. date - любое время дня, пустой список если компания в
+// этот день не работает.
+func (p *RequestsHandler) GetAvailableSlots(authToken string, companyID int64, date int64) (_ []*ongrid2.Slot, err error) {
+	defer mapError(&err)
+
+	_, sessionCompanyID, err := p.requestsAccess(authToken, privileges.PermissionRequestsRead)
+	if err != nil {
+		return nil, err
+	}
+	if companyID != sessionCompanyID {
+		return nil, userError(ongrid2.ErrorCode_PERMISSION_DENIED, fmt.Sprintf("Company %d is not the company of the session", companyID))
+	}
+	db, err := p.conns.SystemDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := callContext(p.cfg.QueryTimeout)
+	defer cancel()
+
+	slots, err := availableSlots(ctx, db, p.cfg.Location(), companyID, time.Unix(date, 0))
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return slots, nil
+}
+
+// requestsAccess checks the token and the permission of the og$users user
+// bound by CheckUser. It returns the session and the sys$clients id of its
+// company, a session sees only the requests of its company.
+func (p *RequestsHandler) requestsAccess(authToken string, permission string) (*Session, int64, error) {
+	session, err := checkToken(p.sessions, authToken)
+	if err != nil {
+		return nil, 0, err
+	}
+	companyID, err := sessionCompany(session, permission)
+	if err != nil {
+		return nil, 0, err
+	}
+	return session, companyID, nil
+}
+
+// sessionCompany checks the permission of the session and returns the
+// sys$clients id of its company
+func sessionCompany(session *Session, permission string) (int64, error) {
+	if !session.hasPermission(permission) {
+		return 0, userError(ongrid2.ErrorCode_PERMISSION_DENIED, fmt.Sprintf("Permission %s is required", permission))
+	}
+	if session.user.CompanyID == 0 {
+		return 0, userError(ongrid2.ErrorCode_PERMISSION_DENIED, "Client is not bound to a company")
+	}
+	return session.user.CompanyID, nil
+}
+
+// checkCompany returns PERMISSION_DENIED when the request is written for
+// another company
+func checkCompany(request *ongrid2.Request, companyID int64) error {
+	if request.Company != nil && request.Company.ID != companyID {
+		return userError(ongrid2.ErrorCode_PERMISSION_DENIED, fmt.Sprintf("Company %d is not the company of the session", request.Company.ID))
+	}
+	return nil
+}
+
+// checkRequestCompany returns NotFoundException when the request does not
+// exist or belongs to another company. With lock the request row stays locked
+// until the end of the transaction.
+func checkRequestCompany(ctx context.Context, q sqlx.QueryerContext, requestID int, companyID int64, lock bool) error {
+	query := "select company from sys$requests where id = ?"
+	if lock {
+		query += " with lock"
+	}
+
+	var company int64
+	err := sqlx.GetContext(ctx, q, &company, query, requestID)
+	if err == sql.ErrNoRows || err == nil && company != companyID {
+		return notFoundError(fmt.Sprintf("Request %d not found", requestID))
+	}
+	if err != nil {
+		log.Printf("checkRequestCompany, select from sys$requests error: %v", err)
+		return sqlError(err)
+	}
+	return nil
+}
+
+// writeRequest runs write and the REQUEST event of the request in one
+// transaction of the system database and returns the request after commit.
+// write sets *requestID when it creates the request.
+func (p *RequestsHandler) writeRequest(write func(ctx context.Context, tx *sqlx.Tx) error, requestID *int) (*ongrid2.Request, error) {
+	db, err := p.conns.SystemDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := callContext(p.cfg.QueryTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	defer tx.Rollback()
+
+	if err = write(ctx, tx); err != nil {
+		return nil, err
+	}
+	if _, err = postRequestEvent(ctx, tx, *requestID); err != nil {
+		return nil, sqlError(err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, sqlError(err)
+	}
+
+	return loadRequest(ctx, db, *requestID)
+}
+
+// loadRequest returns the request or NotFoundException
+func loadRequest(ctx context.Context, db *sqlx.DB, requestID int) (*ongrid2.Request, error) {
+	request, err := getRequest(ctx, db, requestID)
+	if err == sql.ErrNoRows {
+		return nil, notFoundError(fmt.Sprintf("Request %d not found", requestID))
+	}
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return request, nil
+}
+
+// requestsWhere returns the where clause of sys$requests of the company for
+// the filter
+func requestsWhere(companyID int64, filter *ongrid2.RequestFilter) (string, []interface{}, error) {
+	conds := []string{"company = ?"}
+	args := []interface{}{companyID}
+	if filter == nil {
+		return " where " + conds[0], args, nil
+	}
+
+	if len(filter.Statuses) > 0 {
+		statuses := make([]int, 0, len(filter.Statuses))
+		for _, status := range filter.Statuses {
+			statuses = append(statuses, int(status))
+		}
+		conds = append(conds, "status in (?)")
+		args = append(args, statuses)
+	}
+	if filter.IsSetCompanyId() {
+		conds = append(conds, "company = ?")
+		args = append(args, filter.GetCompanyId())
+	}
+	if filter.IsSetUserId() {
+		conds = append(conds, "userid = ?")
+		args = append(args, filter.GetUserId())
+	}
+	if filter.IsSetCreatedFrom() {
+		conds = append(conds, "createddatetime >= ?")
+		args = append(args, time.Unix(filter.GetCreatedFrom(), 0))
+	}
+	if filter.IsSetCreatedTo() {
+		conds = append(conds, "createddatetime < ?")
+		args = append(args, time.Unix(filter.GetCreatedTo(), 0))
+	}
+	return sqlx.In(" where "+strings.Join(conds, " and "), args...)
+}
+
+// requestParams returns the bind values of the writable columns of
+// sys$requests, the client, company and car are required
+func requestParams(request *ongrid2.Request) (map[string]interface{}, error) {
+	if request.User == nil || request.Company == nil || request.Car == nil {
+		return nil, userError(ongrid2.ErrorCode_DATA_INCORRECT, "Request user, company and car are required")
+	}
+	return map[string]interface{}{
+		"user":          request.User.ID,
+		"company":       request.Company.ID,
+		"createdat":     unixTime(request.CreatedDateTime),
+		"desired":       unixTime(request.DesiredDateTime),
+		"desiredperiod": request.DesiredTimePeriod,
+		"phone":         request.Phone,
+		"email":         request.Email,
+		"descr":         request.Description,
+		"car":           request.Car.ID,
+		"status":        request.Status,
+		"master":        nullString(request.MasterInspector),
+	}, nil
+}
+
+// unixTime returns the time of unix seconds, 0 is NULL
+func unixTime(sec int64) interface{} {
+	if sec == 0 {
+		return nil
+	}
+	return time.Unix(sec, 0)
+}
+
+// nullString returns the string, "" is NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// insertRequest inserts the request with a new id and returns the id, loc is
+// the zone of company working hours
+func insertRequest(ctx context.Context, tx *sqlx.Tx, loc *time.Location, request *ongrid2.Request) (int, error) {
+	params, err := requestParams(request)
+	if err != nil {
+		return 0, err
+	}
+	if err = checkSchedule(ctx, tx, loc, 0, request); err != nil {
+		return 0, err
+	}
+
+	var requestID int
+	err = tx.QueryRowxContext(ctx, "select gen_id(gen_sys$requests_id, 1) from rdb$database").Scan(&requestID)
+	if err != nil {
+		log.Printf("insertRequest, select gen_id error: %v", err)
+		return 0, sqlError(err)
+	}
+	params["id"] = requestID
+
+	_, err = tx.NamedExecContext(ctx, "insert into sys$requests (id, userid, company, createddatetime, desireddatetime, desiredtimeperiod, phone, email, description, car, status, master) "+
+		"values (:id, :user, :company, :createdat, :desired, :desiredperiod, :phone, :email, :descr, :car, :status, :master)", params)
+	if err != nil {
+		log.Printf("insertRequest, insert into sys$requests error: %v", err)
+		return 0, sqlError(err)
+	}
+	return requestID, nil
+}
+
+// updateRequest updates all writable columns of the request but the status,
+// a rescheduled request is checked against the schedule of its company in loc
+func updateRequest(ctx context.Context, tx *sqlx.Tx, loc *time.Location, requestID int, request *ongrid2.Request) error {
+	params, err := requestParams(request)
+	if err != nil {
+		return err
+	}
+	moved, err := rescheduled(ctx, tx, requestID, request)
+	if err != nil {
+		return err
+	}
+	if moved {
+		if err = checkSchedule(ctx, tx, loc, requestID, request); err != nil {
+			return err
+		}
+	}
+	params["reqid"] = requestID
+
+	res, err := tx.NamedExecContext(ctx, "update sys$requests set userid = :user, company = :company, createddatetime = :createdat, desireddatetime = :desired, "+
+		"desiredtimeperiod = :desiredperiod, phone = :phone, email = :email, description = :descr, car = :car, master = :master where id = :reqid", params)
+	if err != nil {
+		log.Printf("updateRequest, update sys$requests error: %v", err)
+		return sqlError(err)
+	}
+	return requestUpdated(res, requestID)
+}
+
+// setRequestStatus updates the status of the request
+func setRequestStatus(ctx context.Context, tx *sqlx.Tx, requestID int, status ongrid2.RequestStatus) error {
+	res, err := tx.ExecContext(ctx, "update sys$requests set status = ? where id = ?", int(status), requestID)
+	if err != nil {
+		log.Printf("setRequestStatus, update sys$requests error: %v", err)
+		return sqlError(err)
+	}
+	return requestUpdated(res, requestID)
+}
+
+// requestUpdated returns NotFoundException when no row was updated
+func requestUpdated(res sql.Result, requestID int) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return sqlError(err)
+	}
+	if n == 0 {
+		return notFoundError(fmt.Sprintf("Request %d not found", requestID))
+	}
+	return nil
+}
+
+// postRequestEvent inserts the REQUEST event of the request into sys$events
+// and returns its id
+func postRequestEvent(ctx context.Context, tx *sqlx.Tx, requestID int) (string, error) {
+	var hexUUID string
+	err := tx.QueryRowxContext(ctx, "select hex_uuid from get_hex_uuid").Scan(&hexUUID)
+	if err != nil {
+		log.Printf("select hex_uuid from get_hex_uuid error: %v", err)
+		return "", err
+	}
+
+	_, err = tx.NamedExecContext(ctx, "insert into sys$events (id, type, objectid) values (:id, :type, :objid)",
+		map[string]interface{}{
+			"id":    hexUUID,
+			"type":  int(ongrid2.EventType_REQUEST),
+			"objid": requestID,
+		})
+	if err != nil {
+		log.Printf("insert into sys$events error: %v", err)
+		return "", err
+	}
+	return hexUUID, nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"ongrid-thrift/ongrid2"
+	"ongrid-thrift/privileges"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func isPermissionDenied(err error) bool {
+	e, ok := err.(*ongrid2.UserException)
+	return ok && e.Code == ongrid2.ErrorCode_PERMISSION_DENIED
+}
+
+func isNotFound(err error) bool {
+	_, ok := err.(*ongrid2.NotFoundException)
+	return ok
+}
+
+func TestRequestsWhere(t *testing.T) {
+	from, to := time.Unix(1709500000, 0), time.Unix(1709600000, 0)
+	companyID, userID := int64(7), int64(3)
+
+	tests := []struct {
+		name   string
+		filter *ongrid2.RequestFilter
+		where  string
+		args   []interface{}
+	}{
+		{"no filter", nil, " where company = ?", []interface{}{int64(5)}},
+		{"empty filter", &ongrid2.RequestFilter{}, " where company = ?", []interface{}{int64(5)}},
+		{"statuses", &ongrid2.RequestFilter{Statuses: []ongrid2.RequestStatus{ongrid2.RequestStatus_STATUS_NEW, ongrid2.RequestStatus_STATUS_DONE}},
+			" where company = ? and status in (?, ?)", []interface{}{int64(5), int(ongrid2.RequestStatus_STATUS_NEW), int(ongrid2.RequestStatus_STATUS_DONE)}},
+		// another company narrows the session company to nothing
+		{"company", &ongrid2.RequestFilter{CompanyId: &companyID}, " where company = ? and company = ?", []interface{}{int64(5), int64(7)}},
+		{"user and created", &ongrid2.RequestFilter{UserId: &userID, CreatedFrom: int64Ptr(from.Unix()), CreatedTo: int64Ptr(to.Unix())},
+			" where company = ? and userid = ? and createddatetime >= ? and createddatetime < ?", []interface{}{int64(5), int64(3), from, to}},
+	}
+
+	for _, tt := range tests {
+		where, args, err := requestsWhere(5, tt.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: %q %v, want %q %v", tt.name, where, args, tt.where, tt.args)
+		}
+	}
+}
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestRequestParams(t *testing.T) {
+	request := &ongrid2.Request{
+		User:              &ongrid2.Client{ID: 1},
+		Company:           &ongrid2.Client{ID: 2},
+		Car:               &ongrid2.Car{ID: 100},
+		CreatedDateTime:   1709500000,
+		DesiredTimePeriod: 90,
+		Phone:             "+79990000001",
+		Status:            ongrid2.RequestStatus_STATUS_NEW,
+	}
+	params, err := requestParams(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"user":          int64(1),
+		"company":       int64(2),
+		"createdat":     time.Unix(1709500000, 0),
+		"desired":       nil, // not scheduled
+		"desiredperiod": int32(90),
+		"phone":         "+79990000001",
+		"email":         "",
+		"descr":         "",
+		"car":           int64(100),
+		"status":        ongrid2.RequestStatus_STATUS_NEW,
+		"master":        nil,
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params %v, want %v", params, want)
+	}
+
+	for name, missing := range map[string]func(r *ongrid2.Request){
+		"user":    func(r *ongrid2.Request) { r.User = nil },
+		"company": func(r *ongrid2.Request) { r.Company = nil },
+		"car":     func(r *ongrid2.Request) { r.Car = nil },
+	} {
+		r := *request
+		missing(&r)
+		if _, err = requestParams(&r); !isDataIncorrect(err) {
+			t.Errorf("no %s: got %v, want DATA_INCORRECT", name, err)
+		}
+	}
+}
+
+func TestCheckCompany(t *testing.T) {
+	tests := []struct {
+		name    string
+		request *ongrid2.Request
+		allowed bool
+	}{
+		{"no company", &ongrid2.Request{}, true},
+		{"session company", &ongrid2.Request{Company: &ongrid2.Client{ID: 5}}, true},
+		{"another company", &ongrid2.Request{Company: &ongrid2.Client{ID: 6}}, false},
+	}
+
+	for _, tt := range tests {
+		err := checkCompany(tt.request, 5)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.allowed && !isPermissionDenied(err) {
+			t.Errorf("%s: got %v, want PERMISSION_DENIED", tt.name, err)
+		}
+	}
+}
+
+// requestsTables adds to loaderTables the og$users 1 with requests.read and 2
+// with requests.read and requests.write, the request 7 of the company 2 and the
+// request 8 of the company 3
+func requestsTables() map[string]testTable {
+	tables := loaderTables()
+	tables["og$users"] = testTable{
+		columns: []string{"ID", "LOGIN", "ROLE_ID"},
+		rows:    [][]driver.Value{{int64(1), "reader", int64(10)}, {int64(2), "writer", int64(20)}},
+	}
+	tables["og$groups"] = testTable{columns: []string{"ID", "NAME"}}
+	tables["og$roles"] = testTable{
+		columns: []string{"ID", "NAME"},
+		rows:    [][]driver.Value{{int64(10), "reader"}, {int64(20), "writer"}},
+	}
+	tables["og$permissions"] = testTable{
+		columns: []string{"ID", "NAME", "PERMISSION_TYPE"},
+		rows: [][]driver.Value{
+			{int64(100), privileges.PermissionRequestsRead, int64(1)},
+			{int64(101), privileges.PermissionRequestsWrite, int64(1)},
+		},
+	}
+	tables["og$group_role"] = testTable{columns: []string{"GROUP_ID", "ROLE_ID"}}
+	tables["og$role_permission"] = testTable{
+		columns: []string{"ROLE_ID", "PERMISSION_ID"},
+		rows:    [][]driver.Value{{int64(10), int64(100)}, {int64(20), int64(100)}, {int64(20), int64(101)}},
+	}
+	tables["sys$requests"] = testTable{
+		columns: []string{"ID", "USERID", "COMPANY", "CAR", "STATUS"},
+		rows: [][]driver.Value{
+			{int64(7), int64(1), int64(2), int64(100), int64(ongrid2.RequestStatus_STATUS_NEW)},
+			{int64(8), int64(1), int64(3), int64(100), int64(ongrid2.RequestStatus_STATUS_NEW)},
+		},
+	}
+	tables["rdb$database"] = testTable{columns: []string{"GEN_ID"}, rows: [][]driver.Value{{int64(7)}}}
+	tables["get_hex_uuid"] = testTable{columns: []string{"HEX_UUID"}, rows: [][]driver.Value{{"0123456789ABCDEF"}}}
+	return tables
+}
+
+// newRequestsSession returns the session of the token bound to the og$users
+// user appUserID, companyID is the company of its client
+func newRequestsSession(t *testing.T, db *sqlx.DB, store SessionStore, token string, appUserID int, companyID int64) *Session {
+	t.Helper()
+	now := time.Now()
+	session := &Session{
+		id:        token,
+		tokenHash: hashToken(token),
+		user:      &User{ID: "5a0000000000000000000001", CompanyID: companyID},
+		cfg:       DefaultConfig(),
+		dbConfig:  db,
+		createdAt: now,
+		lastSeen:  now,
+	}
+	if appUserID != 0 {
+		if err := session.bindAppUser(appUserID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Add(session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestSessionCompany(t *testing.T) {
+	db := newLoaderDB(t, requestsTables())
+	store := NewMemorySessionStore()
+
+	tests := []struct {
+		name       string
+		appUserID  int
+		companyID  int64
+		permission string
+		allowed    bool
+	}{
+		{"not bound by CheckUser", 0, 2, privileges.PermissionRequestsRead, false},
+		{"read", 1, 2, privileges.PermissionRequestsRead, true},
+		{"write without permission", 1, 2, privileges.PermissionRequestsWrite, false},
+		{"write", 2, 2, privileges.PermissionRequestsWrite, true},
+		{"no company", 2, 0, privileges.PermissionRequestsRead, false},
+	}
+
+	for i, tt := range tests {
+		session := newRequestsSession(t, db, store, "token"+string(rune('a'+i)), tt.appUserID, tt.companyID)
+		companyID, err := sessionCompany(session, tt.permission)
+		if !tt.allowed {
+			if !isPermissionDenied(err) {
+				t.Errorf("%s: got %d, %v, want PERMISSION_DENIED", tt.name, companyID, err)
+			}
+			continue
+		}
+		if err != nil || companyID != tt.companyID {
+			t.Errorf("%s: got %d, %v, want %d", tt.name, companyID, err, tt.companyID)
+		}
+	}
+}
+
+func TestCheckRequestCompany(t *testing.T) {
+	db := newLoaderDB(t, requestsTables())
+
+	tests := []struct {
+		requestID int
+		companyID int64
+		found     bool
+	}{
+		{7, 2, true},
+		{7, 3, false}, // the request of another company
+		{9, 2, false}, // no request
+	}
+	for _, tt := range tests {
+		for _, lock := range []bool{false, true} {
+			err := checkRequestCompany(context.Background(), db, tt.requestID, tt.companyID, lock)
+			if tt.found && err != nil {
+				t.Errorf("request %d, company %d: %v", tt.requestID, tt.companyID, err)
+			}
+			if !tt.found && !isNotFound(err) {
+				t.Errorf("request %d, company %d: got %v, want NotFoundException", tt.requestID, tt.companyID, err)
+			}
+		}
+	}
+}
+
+// requestEvents returns the request ids of the REQUEST events in statements
+func requestEvents(statements []execStmt) []int64 {
+	var ids []int64
+	for _, stmt := range statements {
+		if strings.HasPrefix(stmt.query, "insert into sys$events") && stmt.args[1] == int64(ongrid2.EventType_REQUEST) {
+			ids = append(ids, stmt.args[2].(int64))
+		}
+	}
+	return ids
+}
+
+func TestRequestWritesPostEvent(t *testing.T) {
+	db := newLoaderDB(t, requestsTables())
+	store := NewMemorySessionStore()
+	newRequestsSession(t, db, store, "reader", 1, 2)
+	newRequestsSession(t, db, store, "writer", 2, 2)
+	newRequestsSession(t, db, store, "other", 2, 3)
+	conns := &ConnectionManager{db: db}
+	requests := NewRequestsHandler(conns, DefaultConfig(), store)
+	ongrid := NewOngridHandler(conns, DefaultConfig(), store, nil, nil)
+
+	newRequest := func(id int32) *ongrid2.Request {
+		return &ongrid2.Request{ID: id, User: &ongrid2.Client{ID: 1}, Company: &ongrid2.Client{ID: 2}, Car: &ongrid2.Car{ID: 100},
+			Status: ongrid2.RequestStatus_STATUS_NEW}
+	}
+	update := func(token string, request *ongrid2.Request) error {
+		_, err := requests.UpdateRequest(token, request)
+		return err
+	}
+	post := func(token string, request *ongrid2.Request) error {
+		_, err := ongrid.PostEvent(token, &ongrid2.Event{Type: ongrid2.EventType_REQUEST, Request: request})
+		return err
+	}
+
+	tests := []struct {
+		name  string
+		write func() error
+		check func(error) bool // nil for success
+	}{
+		{"create", func() error { _, err := requests.CreateRequest("writer", newRequest(0)); return err }, nil},
+		{"update", func() error { return update("writer", newRequest(7)) }, nil},
+		{"set status", func() error {
+			_, err := requests.SetRequestStatus("writer", 7, ongrid2.RequestStatus_STATUS_INPROGRESS, "")
+			return err
+		}, nil},
+		{"post new", func() error { return post("writer", newRequest(0)) }, nil},
+		{"post update", func() error { return post("writer", newRequest(7)) }, nil},
+
+		{"create without requests.write", func() error { _, err := requests.CreateRequest("reader", newRequest(0)); return err }, isPermissionDenied},
+		{"create for another company", func() error { _, err := requests.CreateRequest("other", newRequest(0)); return err }, isPermissionDenied},
+		{"move to another company", func() error {
+			request := newRequest(7)
+			request.Company.ID = 3
+			return update("writer", request)
+		}, isPermissionDenied},
+		// the request 8 of the company 3 is not visible to the company 2
+		{"update of another company", func() error { return update("writer", newRequest(8)) }, isNotFound},
+		{"status of another company", func() error {
+			_, err := requests.SetRequestStatus("other", 7, ongrid2.RequestStatus_STATUS_INPROGRESS, "")
+			return err
+		}, isNotFound},
+		{"post without requests.write", func() error { return post("reader", newRequest(0)) }, isPermissionDenied},
+		{"post for another company", func() error { return post("other", newRequest(0)) }, isPermissionDenied},
+		{"post over another company", func() error { return post("writer", newRequest(8)) }, isNotFound},
+	}
+
+	for _, tt := range tests {
+		loaderCommitted()
+		err := tt.write()
+		events := requestEvents(loaderCommitted())
+
+		if tt.check != nil {
+			if !tt.check(err) {
+				t.Errorf("%s: got %v", tt.name, err)
+			}
+			if len(events) != 0 {
+				t.Errorf("%s: events %v committed", tt.name, events)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		// one REQUEST event of the request in the same transaction
+		if !reflect.DeepEqual(events, []int64{7}) {
+			t.Errorf("%s: events %v, want one event of request 7", tt.name, events)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// requestTransitions lists the statuses a request may move to from its status.
+// REJECTED, CANCELLED and DONE are final.
+var requestTransitions = map[ongrid2.RequestStatus][]ongrid2.RequestStatus{
+	ongrid2.RequestStatus_STATUS_NEW: {
+		ongrid2.RequestStatus_STATUS_INPROGRESS,
+		ongrid2.RequestStatus_STATUS_ASSIGN,
+		ongrid2.RequestStatus_STATUS_POSTPONED,
+		ongrid2.RequestStatus_STATUS_REJECTED,
+		ongrid2.RequestStatus_STATUS_CANCELLED,
+	},
+	ongrid2.RequestStatus_STATUS_INPROGRESS: {
+		ongrid2.RequestStatus_STATUS_ASSIGN,
+		ongrid2.RequestStatus_STATUS_POSTPONED,
+		ongrid2.RequestStatus_STATUS_REJECTED,
+		ongrid2.RequestStatus_STATUS_CANCELLED,
+		ongrid2.RequestStatus_STATUS_DONE,
+	},
+	ongrid2.RequestStatus_STATUS_ASSIGN: {
+		ongrid2.RequestStatus_STATUS_REASSIGN,
+		ongrid2.RequestStatus_STATUS_POSTPONED,
+		ongrid2.RequestStatus_STATUS_CANCELLED,
+		ongrid2.RequestStatus_STATUS_DONE,
+	},
+	ongrid2.RequestStatus_STATUS_REASSIGN: {
+		ongrid2.RequestStatus_STATUS_REASSIGN,
+		ongrid2.RequestStatus_STATUS_POSTPONED,
+		ongrid2.RequestStatus_STATUS_CANCELLED,
+		ongrid2.RequestStatus_STATUS_DONE,
+	},
+	ongrid2.RequestStatus_STATUS_POSTPONED: {
+		ongrid2.RequestStatus_STATUS_INPROGRESS,
+		ongrid2.RequestStatus_STATUS_ASSIGN,
+		ongrid2.RequestStatus_STATUS_CANCELLED,
+	},
+}
+
+// requestInitialStatuses are the statuses a request may be created with
+var requestInitialStatuses = []ongrid2.RequestStatus{
+	ongrid2.RequestStatus_STATUS_NEW,
+	ongrid2.RequestStatus_STATUS_ASSIGN,
+}
+
+func containsStatus(list []ongrid2.RequestStatus, status ongrid2.RequestStatus) bool {
+	for _, s := range list {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition returns InvalidTransition when the request may not move from
+// status from to status to
+func checkTransition(from, to ongrid2.RequestStatus) error {
+	if containsStatus(requestTransitions[from], to) {
+		return nil
+	}
+	return &ongrid2.InvalidTransition{
+		FromStatus: from,
+		ToStatus:   to,
+		Message:    fmt.Sprintf("Request status can not change from %v to %v", from, to),
+	}
+}
+
+// checkInitialStatus returns InvalidTransition for a status a request may not
+// be created with
+func checkInitialStatus(status ongrid2.RequestStatus) error {
+	if containsStatus(requestInitialStatuses, status) {
+		return nil
+	}
+	return &ongrid2.InvalidTransition{
+		ToStatus: status,
+		Message:  fmt.Sprintf("Request can not be created with status %v", status),
+	}
+}
+
+// requestActor is who changes a request: the client of the session and the
+// og$users user bound by CheckUser
+type requestActor struct {
+	clientID  string
+	appUserID int
+}
+
+func sessionActor(session *Session) requestActor {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	return requestActor{clientID: session.user.ID, appUserID: session.appUserID}
+}
+
+// changeRequestStatus moves the request to the status if the transition is
+// allowed and writes it to the history. A request that takes a bay again, for
+// example POSTPONED to INPROGRESS, is checked against the schedule. The request
+// row is locked until the end of the transaction, so concurrent changes are
+// checked one by one. loc is the zone of company working hours.
+func changeRequestStatus(ctx context.Context, tx *sqlx.Tx, loc *time.Location, requestID int, to ongrid2.RequestStatus, actor requestActor, comment string) error {
+	var current DBRequest
+	err := tx.GetContext(ctx, &current, "select * from sys$requests where id = ? with lock", requestID)
+	if err == sql.ErrNoRows {
+		return notFoundError(fmt.Sprintf("Request %d not found", requestID))
+	}
+	if err != nil {
+		log.Printf("changeRequestStatus, select from sys$requests error: %v", err)
+		return sqlError(err)
+	}
+
+	from := current.Status
+	if err = checkTransition(ongrid2.RequestStatus(from), to); err != nil {
+		return err
+	}
+	if !isActiveStatus(from) && isActiveStatus(int(to)) {
+		if err = checkSchedule(ctx, tx, loc, requestID, scheduledRequest(current)); err != nil {
+			return err
+		}
+	}
+	if err = setRequestStatus(ctx, tx, requestID, to); err != nil {
+		return err
+	}
+	return addRequestHistory(ctx, tx, requestID, sql.NullInt64{Int64: int64(from), Valid: true}, to, actor, comment)
+}
+
+// scheduledRequest returns the fields of the row checkSchedule needs
+func scheduledRequest(dbRequest DBRequest) *ongrid2.Request {
+	request := &ongrid2.Request{
+		Company:           &ongrid2.Client{ID: int64(dbRequest.Company)},
+		DesiredTimePeriod: int32(dbRequest.DesiredTimePeriod.Int64),
+		MasterInspector:   dbRequest.MasterInspector.String,
+	}
+	if dbRequest.DesiredDateTime.Valid {
+		request.DesiredDateTime = dbRequest.DesiredDateTime.Time.Unix()
+	}
+	return request
+}
+
+// DBRequestStatusChange ...
+type DBRequestStatusChange struct {
+	ID         int64          `db:"ID"`
+	RequestID  int            `db:"REQUESTID"`
+	FromStatus sql.NullInt64  `db:"FROMSTATUS"`
+	ToStatus   int            `db:"TOSTATUS"`
+	Actor      string         `db:"ACTOR"`
+	AppUserID  sql.NullInt64  `db:"APPUSERID"`
+	ChangedAt  time.Time      `db:"CHANGED_AT"`
+	Note       sql.NullString `db:"NOTE"`
+}
+
+// addRequestHistory writes the status change to sys$request_history, from is
+// NULL for the status the request was created with
+func addRequestHistory(ctx context.Context, tx *sqlx.Tx, requestID int, from sql.NullInt64, to ongrid2.RequestStatus, actor requestActor, comment string) error {
+	var appUserID interface{}
+	if actor.appUserID != 0 {
+		appUserID = actor.appUserID
+	}
+	var fromStatus interface{}
+	if from.Valid {
+		fromStatus = from.Int64
+	}
+
+	_, err := tx.ExecContext(ctx, "insert into sys$request_history (id, requestid, fromstatus, tostatus, actor, appuserid, changed_at, note)"+
+		" values (gen_id(gen_sys$request_history_id, 1), ?, ?, ?, ?, ?, ?, ?)",
+		requestID, fromStatus, int(to), actor.clientID, appUserID, time.Now(), comment)
+	if err != nil {
+		log.Printf("addRequestHistory, insert into sys$request_history error: %v", err)
+		return sqlError(err)
+	}
+	return nil
+}
+
+// getRequestHistory returns the status changes of the request in order
+func getRequestHistory(ctx context.Context, db *sqlx.DB, requestID int) ([]*ongrid2.RequestStatusChange, error) {
+	var rows []DBRequestStatusChange
+	err := db.SelectContext(ctx, &rows, "select id, requestid, fromstatus, tostatus, actor, appuserid, changed_at, note"+
+		" from sys$request_history where requestid = ? order by id", requestID)
+	if err != nil {
+		log.Printf("getRequestHistory, select from sys$request_history error: %v", err)
+		return nil, err
+	}
+
+	history := make([]*ongrid2.RequestStatusChange, 0, len(rows))
+	for _, row := range rows {
+		change := &ongrid2.RequestStatusChange{
+			ID:        row.ID,
+			RequestId: int32(row.RequestID),
+			ToStatus:  ongrid2.RequestStatus(row.ToStatus),
+			Actor:     row.Actor,
+			AppUserId: int32(row.AppUserID.Int64),
+			ChangedAt: row.ChangedAt.Unix(),
+			Comment:   row.Note.String,
+		}
+		if row.FromStatus.Valid {
+			from := ongrid2.RequestStatus(row.FromStatus.Int64)
+			change.FromStatus = &from
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"ongrid-thrift/ongrid2"
+	"testing"
+)
+
+var allStatuses = []ongrid2.RequestStatus{
+	ongrid2.RequestStatus_STATUS_NEW,
+	ongrid2.RequestStatus_STATUS_INPROGRESS,
+	ongrid2.RequestStatus_STATUS_ASSIGN,
+	ongrid2.RequestStatus_STATUS_REASSIGN,
+	ongrid2.RequestStatus_STATUS_REJECTED,
+	ongrid2.RequestStatus_STATUS_CANCELLED,
+	ongrid2.RequestStatus_STATUS_DONE,
+	ongrid2.RequestStatus_STATUS_POSTPONED,
+}
+
+func TestCheckTransition(t *testing.T) {
+	const (
+		statusNew  = ongrid2.RequestStatus_STATUS_NEW
+		inProgress = ongrid2.RequestStatus_STATUS_INPROGRESS
+		assign     = ongrid2.RequestStatus_STATUS_ASSIGN
+		reassign   = ongrid2.RequestStatus_STATUS_REASSIGN
+		rejected   = ongrid2.RequestStatus_STATUS_REJECTED
+		cancelled  = ongrid2.RequestStatus_STATUS_CANCELLED
+		done       = ongrid2.RequestStatus_STATUS_DONE
+		postponed  = ongrid2.RequestStatus_STATUS_POSTPONED
+	)
+	// the whole matrix, a transition not listed is not allowed
+	allowed := map[ongrid2.RequestStatus][]ongrid2.RequestStatus{
+		statusNew:  {inProgress, assign, postponed, rejected, cancelled},
+		inProgress: {assign, postponed, rejected, cancelled, done},
+		assign:     {reassign, postponed, cancelled, done},
+		reassign:   {reassign, postponed, cancelled, done},
+		postponed:  {inProgress, assign, cancelled},
+	}
+
+	for _, from := range allStatuses {
+		for _, to := range allStatuses {
+			want := containsStatus(allowed[from], to)
+			err := checkTransition(from, to)
+			if want {
+				if err != nil {
+					t.Errorf("%v -> %v: %v", from, to, err)
+				}
+				continue
+			}
+
+			invalid, ok := err.(*ongrid2.InvalidTransition)
+			if !ok {
+				t.Errorf("%v -> %v: got %v, want InvalidTransition", from, to, err)
+				continue
+			}
+			if invalid.FromStatus != from || invalid.ToStatus != to {
+				t.Errorf("%v -> %v: InvalidTransition from %v to %v", from, to, invalid.FromStatus, invalid.ToStatus)
+			}
+		}
+	}
+}
+
+func TestCheckInitialStatus(t *testing.T) {
+	for _, status := range allStatuses {
+		want := status == ongrid2.RequestStatus_STATUS_NEW || status == ongrid2.RequestStatus_STATUS_ASSIGN
+		err := checkInitialStatus(status)
+		if want && err != nil {
+			t.Errorf("%v: %v", status, err)
+		}
+		if !want {
+			if invalid, ok := err.(*ongrid2.InvalidTransition); !ok || invalid.ToStatus != status {
+				t.Errorf("%v: got %v, want InvalidTransition", status, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// defaultSlotMinutes is the slot length of a company without slotminutes and
+// the length of a request without desiredTimePeriod
+const defaultSlotMinutes = 60
+
+// companySchedule is the working day of a company from sys$company_schedule
+type companySchedule struct {
+	Opens   int `db:"OPENSAT"`  // minutes from midnight
+	Closes  int `db:"CLOSESAT"` // minutes from midnight
+	SlotMin int `db:"SLOTMINUTES"`
+	Bays    int `db:"BAYS"`
+}
+
+func (s *companySchedule) slot() time.Duration {
+	if s.SlotMin > 0 {
+		return time.Duration(s.SlotMin) * time.Minute
+	}
+	return defaultSlotMinutes * time.Minute
+}
+
+// isoWeekday returns 1 for monday ... 7 for sunday
+func isoWeekday(t time.Time) int {
+	if wd := int(t.Weekday()); wd != 0 {
+		return wd
+	}
+	return 7
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// workingHours returns the opening and closing time of the day of t in the
+// location of t
+func (s *companySchedule) workingHours(t time.Time) (opens, closes time.Time) {
+	day := startOfDay(t)
+	return day.Add(time.Duration(s.Opens) * time.Minute), day.Add(time.Duration(s.Closes) * time.Minute)
+}
+
+// loadSchedule returns the schedule of the company for the day of t in the
+// location of t, nil if the company does not work that day
+func loadSchedule(ctx context.Context, q sqlx.QueryerContext, companyID int64, t time.Time) (*companySchedule, error) {
+	var schedule companySchedule
+	err := sqlx.GetContext(ctx, q, &schedule, "select opensat, closesat, slotminutes, bays from sys$company_schedule where company = ? and weekday = ?",
+		companyID, isoWeekday(t))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("loadSchedule, select from sys$company_schedule error: %v", err)
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// lockCompany locks the sys$clients row of the company until the end of the
+// transaction, so bookings of the company are checked one by one whether it
+// has a schedule or not
+func lockCompany(ctx context.Context, tx *sqlx.Tx, companyID int64) error {
+	var id int64
+	err := tx.GetContext(ctx, &id, "select id from sys$clients where id = ? with lock", companyID)
+	if err == sql.ErrNoRows {
+		return userError(ongrid2.ErrorCode_DATA_INCORRECT, fmt.Sprintf("Company %d not found", companyID))
+	}
+	if err != nil {
+		log.Printf("lockCompany, select from sys$clients error: %v", err)
+		return sqlError(err)
+	}
+	return nil
+}
+
+// hasSchedule reports whether working hours are set for the company
+func hasSchedule(ctx context.Context, q sqlx.QueryerContext, companyID int64) (bool, error) {
+	var n int
+	err := sqlx.GetContext(ctx, q, &n, "select count(*) from sys$company_schedule where company = ?", companyID)
+	return n > 0, err
+}
+
+// booking is the time of an active request
+type booking struct {
+	ID        int            `db:"ID"`
+	Start     time.Time      `db:"DESIREDDATETIME"`
+	Period    sql.NullInt64  `db:"DESIREDTIMEPERIOD"`
+	Inspector sql.NullString `db:"MASTER"`
+}
+
+func (b booking) end() time.Time {
+	return b.Start.Add(requestDuration(int32(b.Period.Int64)))
+}
+
+// requestDuration is desiredTimePeriod in minutes, defaultSlotMinutes if not set
+func requestDuration(period int32) time.Duration {
+	if period > 0 {
+		return time.Duration(period) * time.Minute
+	}
+	return defaultSlotMinutes * time.Minute
+}
+
+// activeStatuses are the statuses of requests that take a bay. A POSTPONED
+// request frees its bay and is checked again when it is resumed.
+var activeStatuses = []int{
+	int(ongrid2.RequestStatus_STATUS_NEW),
+	int(ongrid2.RequestStatus_STATUS_INPROGRESS),
+	int(ongrid2.RequestStatus_STATUS_ASSIGN),
+	int(ongrid2.RequestStatus_STATUS_REASSIGN),
+}
+
+func isActiveStatus(status int) bool {
+	for _, s := range activeStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBookings returns the active requests of the company that overlap
+// [start, end), including those that started earlier, for example the day
+// before. excludeID is skipped.
+func loadBookings(ctx context.Context, q sqlx.QueryerContext, companyID int64, start, end time.Time, excludeID int) ([]booking, error) {
+	query, args, err := sqlx.In(fmt.Sprintf("select id, desireddatetime, desiredtimeperiod, master from sys$requests"+
+		" where company = ? and id <> ? and status in (?) and desireddatetime < ?"+
+		" and dateadd(minute, iif(desiredtimeperiod > 0, desiredtimeperiod, %d), desireddatetime) > ?", defaultSlotMinutes),
+		companyID, excludeID, activeStatuses, end, start)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookings []booking
+	if err = sqlx.SelectContext(ctx, q, &bookings, query, args...); err != nil {
+		log.Printf("loadBookings, select from sys$requests error: %v", err)
+		return nil, err
+	}
+	return bookings, nil
+}
+
+func (b booking) overlaps(start, end time.Time) bool {
+	return b.Start.Before(end) && b.end().After(start)
+}
+
+// peakBookings returns the largest number of bookings that take a bay at the
+// same moment of [start, end). The number only grows at start and at the
+// start of a booking, so only these moments are counted.
+func peakBookings(bookings []booking, start, end time.Time) int {
+	peak := 0
+	for _, at := range bookings {
+		t := at.Start
+		if t.Before(start) {
+			t = start
+		}
+		if !t.Before(end) {
+			continue
+		}
+		n := 0
+		for _, b := range bookings {
+			if !b.Start.After(t) && b.end().After(t) {
+				n++
+			}
+		}
+		if n > peak {
+			peak = n
+		}
+	}
+	return peak
+}
+
+func scheduleConflict(format string, args ...interface{}) error {
+	return userError(ongrid2.ErrorCode_SCHEDULE_CONFLICT, fmt.Sprintf(format, args...))
+}
+
+// checkSchedule checks that the request fits the working hours and free bays
+// of its company and that its inspector is not booked at the same time.
+// requestID is the id of a rescheduled request, 0 for a new one. A request
+// without desiredDateTime is not scheduled; a company without working hours
+// has no hours and bays checks. Working days and hours are in loc, the zone
+// of the company.
+func checkSchedule(ctx context.Context, tx *sqlx.Tx, loc *time.Location, requestID int, request *ongrid2.Request) error {
+	if request.DesiredDateTime == 0 || request.Company == nil {
+		return nil
+	}
+	companyID := request.Company.ID
+	start := time.Unix(request.DesiredDateTime, 0).In(loc)
+	end := start.Add(requestDuration(request.DesiredTimePeriod))
+
+	if err := lockCompany(ctx, tx, companyID); err != nil {
+		return err
+	}
+	schedule, err := loadSchedule(ctx, tx, companyID, start)
+	if err != nil {
+		return sqlError(err)
+	}
+	if schedule == nil {
+		scheduled, err := hasSchedule(ctx, tx, companyID)
+		if err != nil {
+			return sqlError(err)
+		}
+		if scheduled {
+			return scheduleConflict("Company %d does not work on %s", companyID, start.Format("2006-01-02"))
+		}
+	} else {
+		opens, closes := schedule.workingHours(start)
+		if start.Before(opens) || end.After(closes) {
+			return scheduleConflict("Request %s-%s is out of working hours %s-%s",
+				start.Format("15:04"), end.Format("15:04"), opens.Format("15:04"), closes.Format("15:04"))
+		}
+	}
+
+	bookings, err := loadBookings(ctx, tx, companyID, start, end, requestID)
+	if err != nil {
+		return sqlError(err)
+	}
+
+	for _, b := range bookings {
+		if b.overlaps(start, end) && request.MasterInspector != "" && b.Inspector.String == request.MasterInspector {
+			return scheduleConflict("Inspector %s is booked by request %d at %s", request.MasterInspector, b.ID, b.Start.In(loc).Format("15:04"))
+		}
+	}
+	if schedule != nil {
+		if busy := peakBookings(bookings, start, end); busy >= schedule.Bays {
+			return scheduleConflict("No free bays at %s-%s, %d of %d busy", start.Format("15:04"), end.Format("15:04"), busy, schedule.Bays)
+		}
+	}
+	return nil
+}
+
+// rescheduled reports whether the update moves an active request to another
+// time, company or inspector. The request row is locked until the end of the
+// transaction.
+func rescheduled(ctx context.Context, tx *sqlx.Tx, requestID int, request *ongrid2.Request) (bool, error) {
+	var current DBRequest
+	err := tx.GetContext(ctx, &current, "select * from sys$requests where id = ? with lock", requestID)
+	if err == sql.ErrNoRows {
+		return false, notFoundError(fmt.Sprintf("Request %d not found", requestID))
+	}
+	if err != nil {
+		log.Printf("rescheduled, select from sys$requests error: %v", err)
+		return false, sqlError(err)
+	}
+
+	active := isActiveStatus(current.Status)
+	var desired int64
+	if current.DesiredDateTime.Valid {
+		desired = current.DesiredDateTime.Time.Unix()
+	}
+	return active && (desired != request.DesiredDateTime ||
+		int32(current.DesiredTimePeriod.Int64) != request.DesiredTimePeriod ||
+		current.MasterInspector.String != request.MasterInspector ||
+		int64(current.Company) != request.Company.ID), nil
+}
+
+// availableSlots returns the slots with a free bay of the company day, the day
+// of t in loc, the zone of the company
+func availableSlots(ctx context.Context, db *sqlx.DB, loc *time.Location, companyID int64, t time.Time) ([]*ongrid2.Slot, error) {
+	slots := []*ongrid2.Slot{}
+
+	day := t.In(loc)
+	schedule, err := loadSchedule(ctx, db, companyID, day)
+	if err != nil || schedule == nil {
+		return slots, err
+	}
+	opens, closes := schedule.workingHours(day)
+	bookings, err := loadBookings(ctx, db, companyID, opens, closes, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := opens; !start.Add(schedule.slot()).After(closes); start = start.Add(schedule.slot()) {
+		end := start.Add(schedule.slot())
+
+		slot := &ongrid2.Slot{Start: start.Unix(), End: end.Unix(), BusyInspectors: []string{}}
+		for _, b := range bookings {
+			if b.overlaps(start, end) && b.Inspector.String != "" {
+				slot.BusyInspectors = append(slot.BusyInspectors, b.Inspector.String)
+			}
+		}
+		if busy := peakBookings(bookings, start, end); busy < schedule.Bays {
+			slot.FreeBays = int32(schedule.Bays - busy)
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestPeakBookings(t *testing.T) {
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	at := func(hour, min int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute)
+	}
+	book := func(hour, min, minutes int) booking {
+		return booking{Start: at(hour, min), Period: sql.NullInt64{Int64: int64(minutes), Valid: true}}
+	}
+
+	tests := []struct {
+		name       string
+		bookings   []booking
+		start, end time.Time
+		want       int
+	}{
+		{"none", nil, at(9, 0), at(12, 0), 0},
+		{"one inside", []booking{book(10, 0, 60)}, at(9, 0), at(12, 0), 1},
+		// 9-10 and 11-12 never overlap, the span needs one bay
+		{"one after another", []booking{book(9, 0, 60), book(11, 0, 60)}, at(9, 0), at(12, 0), 1},
+		{"back to back", []booking{book(9, 0, 60), book(10, 0, 60), book(11, 0, 60)}, at(9, 0), at(12, 0), 1},
+		{"two at once", []booking{book(9, 0, 120), book(10, 0, 60), book(11, 30, 30)}, at(9, 0), at(12, 0), 2},
+		{"started before the span", []booking{book(8, 0, 90), book(9, 0, 60)}, at(9, 0), at(10, 0), 2},
+		{"ended before the span", []booking{book(7, 0, 60), book(9, 0, 60)}, at(9, 0), at(10, 0), 1},
+		{"starts at the end", []booking{book(9, 0, 60), book(10, 0, 60)}, at(9, 0), at(10, 0), 1},
+		{"from the day before", []booking{{Start: day.Add(-time.Hour), Period: sql.NullInt64{Int64: 180, Valid: true}}}, at(1, 0), at(2, 0), 1},
+		{"default duration", []booking{{Start: at(9, 0)}, book(9, 30, 10)}, at(9, 0), at(12, 0), 2},
+	}
+
+	for _, tt := range tests {
+		if got := peakBookings(tt.bookings, tt.start, tt.end); got != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWorkingHoursInCompanyZone(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	schedule := &companySchedule{Opens: 9 * 60, Closes: 18 * 60}
+
+	// sunday 22:30 UTC is monday 01:30 in Moscow
+	at := time.Date(2024, 3, 3, 22, 30, 0, 0, time.UTC).In(msk)
+	if got := isoWeekday(at); got != 1 {
+		t.Errorf("weekday %d, want 1", got)
+	}
+	opens, closes := schedule.workingHours(at)
+	wantOpens := time.Date(2024, 3, 4, 9, 0, 0, 0, msk)
+	wantCloses := time.Date(2024, 3, 4, 18, 0, 0, 0, msk)
+	if !opens.Equal(wantOpens) || !closes.Equal(wantCloses) {
+		t.Errorf("working hours %v-%v, want %v-%v", opens, closes, wantOpens, wantCloses)
+	}
+	// 09:00 in Moscow is 06:00 UTC
+	if got := opens.UTC().Hour(); got != 6 {
+		t.Errorf("opens at %d UTC, want 6", got)
+	}
+}
@@ -3,40 +3,83 @@ package main
 import (
 	"crypto/tls"
 	"fmt"
+	"log"
 	"ongrid-thrift/ongrid2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 )
 
-func runServer(transportFactory thrift.TTransportFactory, protocolFactory thrift.TProtocolFactory, addr string, secure bool) error {
+func runServer(cfg *Config, transportFactory thrift.TTransportFactory, protocolFactory thrift.TProtocolFactory) error {
 	var transport thrift.TServerTransport
 	var err error
-	if secure {
-		cfg := new(tls.Config)
-		if cert, err := tls.LoadX509KeyPair("keys/server.crt", "keys/server.key"); err == nil {
-			cfg.Certificates = append(cfg.Certificates, cert)
+	if cfg.Secure {
+		tlsConfig := new(tls.Config)
+		if cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey); err == nil {
+			tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
 		} else {
 			return err
 		}
-		transport, err = thrift.NewTSSLServerSocket(addr, cfg)
+		transport, err = thrift.NewTSSLServerSocketTimeout(cfg.Addr, tlsConfig, cfg.ClientTimeout)
 	} else {
-		transport, err = thrift.NewTServerSocket(addr)
+		transport, err = thrift.NewTServerSocketTimeout(cfg.Addr, cfg.ClientTimeout)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	hDB := NewDBHandler()
-	hOngrid := NewOngridHandler()
+	conns := NewConnectionManager(cfg.DB, cfg.Mongo)
+	defer conns.Close()
+
+	sessions := newSessionStore(cfg, conns)
+	stopReaper := make(chan struct{})
+	defer close(stopReaper)
+	go runSessionReaper(sessions, time.Minute, stopReaper)
+
+	hDB := NewDBHandler(cfg, sessions)
+	hub := NewLocalPublisher()
+	outbox := NewOutboxDispatcher(conns, newPublisher(cfg, hub), cfg, time.Second)
+	stopOutbox := make(chan struct{})
+	outboxDone := make(chan struct{})
+	go func() {
+		outbox.Run(stopOutbox)
+		close(outboxDone)
+	}()
+
+	hOngrid := NewOngridHandler(conns, cfg, sessions, hub, outbox)
 	//processor := ongrid2.NewIntergridProcessor(handler)
 	dbProcessor := ongrid2.NewDBProcessor(hDB)
 	ongridProcessor := ongrid2.NewOngridProcessor(hOngrid)
 	processor := thrift.NewTMultiplexedProcessor()
-	server := thrift.NewTSimpleServer4(processor, transport, transportFactory, protocolFactory)
+	server := NewThriftServer(processor, transport, transportFactory, protocolFactory, cfg.MaxConns)
 	processor.RegisterProcessor("DB", dbProcessor)
 	processor.RegisterProcessor("Ongrid", ongridProcessor)
+	processor.RegisterProcessor("Requests", ongrid2.NewRequestsProcessor(NewRequestsHandler(conns, cfg, sessions)))
+	processor.RegisterProcessor("Crm", ongrid2.NewCrmProcessor(NewCrmHandler(conns, cfg, sessions)))
+
+	// on SIGTERM stop accepting, wake up waiting WaitEvents calls, let running
+	// calls finish and close the databases of all sessions
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	go func() {
+		if sig, ok := <-signals; ok {
+			log.Printf("%v received, stopping the server", sig)
+			server.Stop()
+			hub.Close()
+		}
+	}()
+
+	fmt.Println("Starting the ongrid-thrift server ver 0.1.3 on ", cfg.Addr)
+	err = server.Serve()
 
-	fmt.Println("Starting the ongrid-thrift server ver 0.1.3 on ", addr)
-	return server.Serve()
+	close(stopOutbox)
+	<-outboxDone
+	closeSessions(sessions)
+	log.Println("Server stopped")
+	return err
 }
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned by a SessionStore when there is no active
+// session for the given id or token
+var ErrSessionNotFound = errors.New("Session not found")
+
+// ErrSessionExpired is returned by GetByToken for a session that was expired
+var ErrSessionExpired = errors.New("Session expired")
+
+// expiredTokensKeep is how long a store remembers tokens of expired sessions,
+// afterwards they are reported as unknown
+const expiredTokensKeep = 24 * time.Hour
+
+// authTokenBytes is the entropy of an auth token, 256 bits
+const authTokenBytes = 32
+
+// newAuthToken returns a random hex encoded auth token
+func newAuthToken() (string, error) {
+	b := make([]byte, authTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the sha256 of the token, stores keep only this hash so a
+// memory or database dump does not expose live tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SessionStore keeps client sessions. Implementations must be safe for
+// concurrent use, every thrift call looks up its session by token.
+type SessionStore interface {
+	// Add registers a new session, session.id and session.tokenHash must be set
+	Add(session *Session) error
+	// Get returns the session by id
+	Get(id string) (*Session, error)
+	// GetByToken returns the session by hash of the auth token
+	GetByToken(tokenHash string) (*Session, error)
+	// Remove deletes the session and returns it, the caller closes it
+	Remove(id string) (*Session, error)
+	// Expire deletes the session like Remove, but its token is reported
+	// with ErrSessionExpired afterwards
+	Expire(id string) (*Session, error)
+	// SetAppUser remembers the og$users user bound to the session by CheckUser
+	SetAppUser(id string, appUserID int) error
+	// Range calls fn for every live session until fn returns false
+	Range(fn func(session *Session) bool)
+}
+
+// MemorySessionStore keeps sessions in process memory, indexed both by id and
+// by token. Sessions are lost on restart.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	tokens   map[string]string    // token hash -> session id
+	expired  map[string]time.Time // token hash -> expire time
+}
+
+// NewMemorySessionStore ...
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*Session),
+		tokens:   make(map[string]string),
+		expired:  make(map[string]time.Time),
+	}
+}
+
+// Add ...
+func (s *MemorySessionStore) Add(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[session.id]; ok {
+		return errors.New("Session already exists")
+	}
+	s.sessions[session.id] = session
+	s.tokens[session.tokenHash] = session.id
+	return nil
+}
+
+// Get ...
+func (s *MemorySessionStore) Get(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// GetByToken ...
+func (s *MemorySessionStore) GetByToken(tokenHash string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.tokens[tokenHash]
+	if !ok {
+		if _, ok = s.expired[tokenHash]; ok {
+			return nil, ErrSessionExpired
+		}
+		return nil, ErrSessionNotFound
+	}
+	return s.sessions[id], nil
+}
+
+// Remove ...
+func (s *MemorySessionStore) Remove(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	delete(s.sessions, id)
+	delete(s.tokens, session.tokenHash)
+	return session, nil
+}
+
+// Expire ...
+func (s *MemorySessionStore) Expire(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	delete(s.sessions, id)
+	delete(s.tokens, session.tokenHash)
+
+	now := time.Now()
+	for token, at := range s.expired {
+		if now.Sub(at) > expiredTokensKeep {
+			delete(s.expired, token)
+		}
+	}
+	s.expired[session.tokenHash] = now
+
+	return session, nil
+}
+
+// SetAppUser does nothing, the session in memory keeps its user
+func (s *MemorySessionStore) SetAppUser(id string, appUserID int) error {
+	return nil
+}
+
+// Range ...
+func (s *MemorySessionStore) Range(fn func(session *Session) bool) {
+	s.mu.RLock()
+	list := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		list = append(list, session)
+	}
+	s.mu.RUnlock()
+
+	for _, session := range list {
+		if !fn(session) {
+			return
+		}
+	}
+}
+
+// MongoSessionStore persists sessions in the thrift-sessions collection so
+// that clients keep their tokens across a server restart. Live sessions with
+// open databases are cached in memory; a token that is missing from the cache
+// but active in mongo is restored by reopening the client databases.
+type MongoSessionStore struct {
+	live  *MemorySessionStore
+	conns *ConnectionManager
+	cfg   *Config
+
+	restoreMu sync.Mutex
+}
+
+// NewMongoSessionStore ...
+func NewMongoSessionStore(conns *ConnectionManager, cfg *Config) *MongoSessionStore {
+	return &MongoSessionStore{live: NewMemorySessionStore(), conns: conns, cfg: cfg}
+}
+
+// Add ...
+func (s *MongoSessionStore) Add(session *Session) error {
+	mongo, err := s.conns.Mongo()
+	if err != nil {
+		return err
+	}
+	err = mongo.SaveSession(docSession{
+		ID:        session.id,
+		UserID:    session.user.ID,
+		Login:     session.user.Login,
+		MacAddr:   session.macAddr,
+		TokenHash: session.tokenHash,
+		CreatedAt: session.createdAt,
+		Active:    true,
+	})
+	if err != nil {
+		return err
+	}
+	return s.live.Add(session)
+}
+
+// Get ...
+func (s *MongoSessionStore) Get(id string) (*Session, error) {
+	return s.live.Get(id)
+}
+
+// GetByToken ...
+func (s *MongoSessionStore) GetByToken(tokenHash string) (*Session, error) {
+	session, err := s.live.GetByToken(tokenHash)
+	if err != ErrSessionNotFound {
+		return session, err
+	}
+
+	// Only one caller reopens the databases for a token
+	s.restoreMu.Lock()
+	defer s.restoreMu.Unlock()
+
+	if session, err = s.live.GetByToken(tokenHash); err != ErrSessionNotFound {
+		return session, err
+	}
+
+	mongo, err := s.conns.Mongo()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := mongo.GetSessionByToken(tokenHash)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	if doc.Expired {
+		return nil, ErrSessionExpired
+	}
+	if !doc.Active {
+		return nil, ErrSessionNotFound
+	}
+	user, err := mongo.GetUserByID(doc.UserID)
+	if err != nil {
+		log.Printf("MongoSessionStore: restore session %s: %v", doc.ID, err)
+		return nil, ErrSessionNotFound
+	}
+
+	session, err = openSession(s.cfg, doc.ID, doc.TokenHash, &user)
+	if err != nil {
+		return nil, err
+	}
+	session.createdAt = doc.CreatedAt
+	session.macAddr = doc.MacAddr
+	// without the og$users user the session would fall back to the default
+	// sql policy and no permissions
+	if doc.AppUserID != 0 {
+		if err = session.bindAppUser(doc.AppUserID); err != nil {
+			log.Printf("MongoSessionStore: restore session %s, user %d: %v", doc.ID, doc.AppUserID, err)
+			session.close()
+			return nil, err
+		}
+	}
+	if err = s.live.Add(session); err != nil {
+		session.close()
+		return nil, err
+	}
+	log.Printf("MongoSessionStore: Session %s restored", doc.ID)
+
+	return session, nil
+}
+
+// Remove ...
+func (s *MongoSessionStore) Remove(id string) (*Session, error) {
+	session, err := s.live.Remove(id)
+	if err != nil {
+		return nil, err
+	}
+	s.closeDoc(id, false)
+	return session, nil
+}
+
+// Expire ...
+func (s *MongoSessionStore) Expire(id string) (*Session, error) {
+	session, err := s.live.Expire(id)
+	if err != nil {
+		return nil, err
+	}
+	s.closeDoc(id, true)
+	return session, nil
+}
+
+// SetAppUser ...
+func (s *MongoSessionStore) SetAppUser(id string, appUserID int) error {
+	mongo, err := s.conns.Mongo()
+	if err != nil {
+		return err
+	}
+	return mongo.SetSessionAppUser(id, appUserID)
+}
+
+func (s *MongoSessionStore) closeDoc(id string, expired bool) {
+	mongo, err := s.conns.Mongo()
+	if err == nil {
+		err = mongo.CloseSession(id, expired)
+	}
+	if err != nil {
+		log.Printf("MongoSessionStore: close session %s: %v", id, err)
+	}
+}
+
+// Range ...
+func (s *MongoSessionStore) Range(fn func(session *Session) bool) {
+	s.live.Range(fn)
+}
+
+// newSessionStore returns the store selected by the sessionstore config key
+func newSessionStore(cfg *Config, conns *ConnectionManager) SessionStore {
+	switch cfg.SessionStore {
+	case "mongo":
+		log.Println("Sessions are stored in mongo")
+		return NewMongoSessionStore(conns, cfg)
+	default:
+		return NewMemorySessionStore()
+	}
+}
+
+// runSessionReaper closes sessions that outlived their ttl, rolls back
+// abandoned transactions and closes idle cursors every interval, it returns
+// when stop is closed
+func runSessionReaper(store SessionStore, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			reapSessions(store, now)
+		}
+	}
+}
+
+func reapSessions(store SessionStore, now time.Time) {
+	var expired []*Session
+	store.Range(func(session *Session) bool {
+		if session.expired(now) {
+			expired = append(expired, session)
+		} else {
+			session.rollbackTransactions(now, true)
+			session.closeIdleCursors(now)
+		}
+		return true
+	})
+
+	for _, session := range expired {
+		if _, err := store.Expire(session.id); err != nil {
+			continue
+		}
+		session.close()
+		log.Printf("Session %s expired, databases connections closed", session.id)
+	}
+}
+
+// closeSessions closes the databases of all live sessions on server stop. The
+// sessions stay in the store, a mongo store restores them after a restart.
+func closeSessions(store SessionStore) {
+	var list []*Session
+	store.Range(func(session *Session) bool {
+		list = append(list, session)
+		return true
+	})
+
+	for _, session := range list {
+		session.close()
+	}
+	log.Printf("%d sessions closed", len(list))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"ongrid-thrift/privileges"
+)
+
+// bindAppUser sets the og$users user of the session and loads his sql policy
+// and permissions from the privileges tables of the config database
+func (s *Session) bindAppUser(userID int) error {
+	aclService := privileges.ACLService{}
+	if err := aclService.Load(s.dbConfig); err != nil {
+		return err
+	}
+	policy, err := aclService.GetSQLPolicy(userID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.appUserID = userID
+	s.policy = policy
+	s.acl = &aclService
+	s.mu.Unlock()
+
+	return nil
+}
+
+// hasPermission reports whether the og$users user of the session has the
+// permission, a session without a bound user has none
+func (s *Session) hasPermission(name string) bool {
+	s.mu.Lock()
+	acl := s.acl
+	userID := s.appUserID
+	s.mu.Unlock()
+
+	return acl != nil && acl.HasPermission(userID, name)
+}
+
+// authorizeStatement checks the statement against the sql policy of the
+// session. Until a user is bound by CheckUser the default policy is used.
+func (s *Session) authorizeStatement(query *ongrid2.Query) error {
+	class := privileges.ClassifyStatement(query.Sql)
+
+	s.mu.Lock()
+	policy := s.policy
+	userID := s.appUserID
+	s.mu.Unlock()
+
+	if policy == nil {
+		policy = privileges.DefaultSQLPolicy()
+	}
+	if policy.Allowed(class) {
+		return nil
+	}
+
+	log.Printf("SQL policy: %s statement denied, session %s, client %s, user %d: %s", class, s.id, s.user.ID, userID, query.Sql)
+	return userError(ongrid2.ErrorCode_PERMISSION_DENIED, fmt.Sprintf("%s statements are not allowed", class))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"log"
+	"ongrid-thrift/ongrid2"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// stmtCache is an LRU cache of prepared named statements keyed by sql text.
+// database/sql prepares a cached statement again on every pool connection it
+// is used on, so one entry serves all connections of the session.
+type stmtCache struct {
+	db       *sqlx.DB
+	capacity int
+
+	mu     sync.Mutex
+	ll     *list.List // front is the most recently used
+	items  map[string]*list.Element
+	hits   int64
+	misses int64
+}
+
+// stmtEntry is a cached statement. refs counts the calls using it, an entry
+// evicted while in use is closed by the last release.
+type stmtEntry struct {
+	sql     string
+	stmt    *sqlx.NamedStmt
+	refs    int
+	evicted bool
+}
+
+func newStmtCache(db *sqlx.DB, capacity int) *stmtCache {
+	return &stmtCache{
+		db:       db,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the prepared statement for the sql, preparing it on a miss. The
+// caller must call release when it has executed the statement.
+func (c *stmtCache) get(ctx context.Context, query string) (stmt *sqlx.NamedStmt, release func(), err error) {
+	c.mu.Lock()
+	if e, ok := c.items[query]; ok {
+		c.ll.MoveToFront(e)
+		c.hits++
+		defer c.mu.Unlock()
+		return c.acquire(e.Value.(*stmtEntry))
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	stmt, err = c.db.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// prepared concurrently by another call
+	if e, ok := c.items[query]; ok {
+		stmt.Close()
+		c.ll.MoveToFront(e)
+		return c.acquire(e.Value.(*stmtEntry))
+	}
+
+	entry := &stmtEntry{sql: query, stmt: stmt}
+	c.items[query] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.capacity {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		evicted := e.Value.(*stmtEntry)
+		delete(c.items, evicted.sql)
+		evicted.evicted = true
+		c.closeUnused(evicted)
+	}
+
+	return c.acquire(entry)
+}
+
+// acquire takes a reference to the entry, c.mu must be held
+func (c *stmtCache) acquire(entry *stmtEntry) (*sqlx.NamedStmt, func(), error) {
+	entry.refs++
+
+	var once sync.Once
+	return entry.stmt, func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			entry.refs--
+			c.closeUnused(entry)
+		})
+	}, nil
+}
+
+// closeUnused closes the evicted entry when no call uses it, c.mu must be held.
+// Statements with open rows are finalized when the rows are closed.
+func (c *stmtCache) closeUnused(entry *stmtEntry) {
+	if entry.evicted && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// stats returns the cache counters
+func (c *stmtCache) stats() *ongrid2.StatementCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &ongrid2.StatementCacheStats{
+		Hits:     c.hits,
+		Misses:   c.misses,
+		Size:     int32(c.ll.Len()),
+		Capacity: int32(c.capacity),
+	}
+}
+
+// close closes all cached statements, a statement in use is closed when it is
+// released
+func (c *stmtCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*stmtEntry)
+		entry.evicted = true
+		c.closeUnused(entry)
+	}
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// namedQuery checks the sql policy and runs the select through the statement
+// cache of the session under ctx, in the transaction if tx is not nil
+func (s *Session) namedQuery(ctx context.Context, tx *sqlx.Tx, query *ongrid2.Query) (*sqlx.Rows, error) {
+	if err := s.authorizeStatement(query); err != nil {
+		return nil, err
+	}
+	if s.stmts == nil {
+		if tx != nil {
+			return sqlx.NamedQueryContext(ctx, tx, query.Sql, getParams(query))
+		}
+		return s.dbData.NamedQueryContext(ctx, query.Sql, getParams(query))
+	}
+
+	stmt, release, err := s.stmts.get(ctx, query.Sql)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if tx != nil {
+		stmt = tx.NamedStmtContext(ctx, stmt)
+	}
+	return stmt.QueryxContext(ctx, getParams(query))
+}
+
+// namedExec checks the sql policy and runs the statement through the statement
+// cache of the session under ctx, in the transaction if tx is not nil
+func (s *Session) namedExec(ctx context.Context, tx *sqlx.Tx, query *ongrid2.Query) (sql.Result, error) {
+	if err := s.authorizeStatement(query); err != nil {
+		return nil, err
+	}
+	if s.stmts == nil {
+		if tx != nil {
+			return tx.NamedExecContext(ctx, query.Sql, getParams(query))
+		}
+		return s.dbData.NamedExecContext(ctx, query.Sql, getParams(query))
+	}
+
+	stmt, release, err := s.stmts.get(ctx, query.Sql)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if tx != nil {
+		stmt = tx.NamedStmtContext(ctx, stmt)
+	}
+	return stmt.ExecContext(ctx, getParams(query))
+}
+
+// logStmtCacheStats logs the counters of the session statement cache
+func (s *Session) logStmtCacheStats() {
+	if s.stmts == nil {
+		return
+	}
+	stats := s.stmts.stats()
+	log.Printf("Session %s: statement cache hits %d, misses %d, size %d/%d", s.id, stats.Hits, stats.Misses, stats.Size, stats.Capacity)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// countingDriver is a database/sql driver that counts the open prepared
+// statements by sql text
+type countingDriver struct {
+	mu   sync.Mutex
+	open map[string]int
+}
+
+var testDriver = &countingDriver{open: make(map[string]int)}
+
+func init() {
+	sql.Register("stmtcachetest", testDriver)
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	return &countingConn{d: d}, nil
+}
+
+func (d *countingDriver) prepared(query string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.open[query]
+}
+
+type countingConn struct {
+	d *countingDriver
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.open[query]++
+	return &countingStmt{d: c.d, query: query}, nil
+}
+
+func (c *countingConn) Close() error { return nil }
+
+func (c *countingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type countingStmt struct {
+	d     *countingDriver
+	query string
+}
+
+func (s *countingStmt) Close() error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.open[s.query]--
+	return nil
+}
+
+func (s *countingStmt) NumInput() int { return -1 }
+
+func (s *countingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (s *countingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return []string{"A"} }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newTestStmtCache(t *testing.T, capacity int) *stmtCache {
+	t.Helper()
+	db, err := sqlx.Open("stmtcachetest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return newStmtCache(db, capacity)
+}
+
+// use gets the statement, executes it and releases it
+func use(t *testing.T, c *stmtCache, query string) {
+	t.Helper()
+	stmt, release, err := c.get(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, err = stmt.Exec(map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStmtCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestStmtCache(t, 2)
+	a, b, d := "select 1 a from rdb$database", "select 1 b from rdb$database", "select 1 d from rdb$database"
+
+	use(t, c, a)
+	use(t, c, b)
+	use(t, c, a) // b is the least recently used now
+	use(t, c, d)
+
+	for query, want := range map[string]int{a: 1, b: 0, d: 1} {
+		if got := testDriver.prepared(query); got != want {
+			t.Errorf("%s: %d open statements, want %d", query, got, want)
+		}
+	}
+	stats := c.stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Size != 2 || stats.Capacity != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	c.close()
+	for _, query := range []string{a, b, d} {
+		if got := testDriver.prepared(query); got != 0 {
+			t.Errorf("%s: %d open statements after close", query, got)
+		}
+	}
+}
+
+func TestStmtCacheClosesEvictedStatementAfterRelease(t *testing.T) {
+	c := newTestStmtCache(t, 1)
+	held, other := "select 2 held from rdb$database", "select 2 other from rdb$database"
+
+	stmt, release, err := c.get(context.Background(), held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	use(t, c, other) // evicts held while it is in use
+
+	if _, err = stmt.Exec(map[string]interface{}{}); err != nil {
+		t.Fatalf("evicted statement in use: %v", err)
+	}
+	if got := testDriver.prepared(held); got != 1 {
+		t.Errorf("evicted statement in use: %d open, want 1", got)
+	}
+
+	release()
+	release() // a second release is ignored
+	if got := testDriver.prepared(held); got != 0 {
+		t.Errorf("released evicted statement: %d open, want 0", got)
+	}
+	if got := testDriver.prepared(other); got != 1 {
+		t.Errorf("cached statement: %d open, want 1", got)
+	}
+}
+
+func TestStmtCacheCloseWaitsForRelease(t *testing.T) {
+	c := newTestStmtCache(t, 4)
+	held := "select 3 held from rdb$database"
+
+	_, release, err := c.get(context.Background(), held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.close()
+	if got := testDriver.prepared(held); got != 1 {
+		t.Errorf("statement in use closed with the cache: %d open, want 1", got)
+	}
+
+	release()
+	if got := testDriver.prepared(held); got != 0 {
+		t.Errorf("released statement: %d open, want 0", got)
+	}
+}
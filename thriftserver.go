@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// errServerStopping is returned to a connection that received a call after
+// the server started to stop
+var errServerStopping = errors.New("Server is stopping")
+
+// ThriftServer serves thrift clients like thrift.TSimpleServer, but limits
+// the number of concurrent client connections and stops gracefully: Stop
+// stops accepting, closes idle connections and lets running calls finish.
+type ThriftServer struct {
+	processor        thrift.TProcessor
+	transport        thrift.TServerTransport
+	transportFactory thrift.TTransportFactory
+	protocolFactory  thrift.TProtocolFactory
+
+	slots    chan struct{} // one per open connection, nil for no limit
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[*serverConn]struct{}
+}
+
+// NewThriftServer ...
+func NewThriftServer(processor thrift.TProcessor, transport thrift.TServerTransport, transportFactory thrift.TTransportFactory, protocolFactory thrift.TProtocolFactory, maxConns int) *ThriftServer {
+	s := &ThriftServer{
+		processor:        processor,
+		transport:        transport,
+		transportFactory: transportFactory,
+		protocolFactory:  protocolFactory,
+		quit:             make(chan struct{}),
+		conns:            make(map[*serverConn]struct{}),
+	}
+	if maxConns > 0 {
+		s.slots = make(chan struct{}, maxConns)
+	}
+	return s
+}
+
+// Serve accepts clients until Stop and returns when all connections are closed
+func (s *ThriftServer) Serve() error {
+	if err := s.transport.Listen(); err != nil {
+		return err
+	}
+
+	err := s.acceptLoop()
+	s.wg.Wait()
+	return err
+}
+
+func (s *ThriftServer) acceptLoop() error {
+	for {
+		// clients over the limit wait in the listen backlog
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+			case <-s.quit:
+				return nil
+			}
+		}
+
+		client, err := s.transport.Accept()
+		if err != nil {
+			s.release()
+			select {
+			case <-s.quit:
+				return nil
+			default:
+			}
+			return err
+		}
+		if client == nil {
+			s.release()
+			continue
+		}
+
+		c := &serverConn{server: s, transport: client}
+		if !s.addConn(c) {
+			client.Close()
+			s.release()
+			return nil
+		}
+		go s.serveConn(c)
+	}
+}
+
+func (s *ThriftServer) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// addConn registers the connection, it fails if the server is stopping
+func (s *ThriftServer) addConn(c *serverConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopping() {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *ThriftServer) removeConn(c *serverConn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+
+	s.release()
+	s.wg.Done()
+}
+
+func (s *ThriftServer) stopping() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops accepting clients and closes idle connections. Connections with
+// a running call are closed when the call is answered.
+func (s *ThriftServer) Stop() {
+	s.stopOnce.Do(func() {
+		s.mu.Lock()
+		close(s.quit)
+		conns := make([]*serverConn, 0, len(s.conns))
+		for c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+
+		s.transport.Interrupt()
+		for _, c := range conns {
+			c.closeIdle()
+		}
+	})
+}
+
+func (s *ThriftServer) serveConn(c *serverConn) {
+	defer s.removeConn(c)
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("panic in processor: %s: %s", e, debug.Stack())
+		}
+	}()
+
+	inputTransport := s.transportFactory.GetTransport(c.transport)
+	outputTransport := s.transportFactory.GetTransport(c.transport)
+	defer inputTransport.Close()
+	defer outputTransport.Close()
+
+	inputProtocol := &callProtocol{TProtocol: s.protocolFactory.GetProtocol(inputTransport), conn: c}
+	outputProtocol := s.protocolFactory.GetProtocol(outputTransport)
+
+	for {
+		ok, err := s.processor.Process(inputProtocol, outputProtocol)
+		c.endCall()
+
+		if err, ok := err.(thrift.TTransportException); ok && err.TypeId() == thrift.END_OF_FILE {
+			return
+		}
+		if err != nil && !s.stopping() {
+			log.Printf("error processing request: %s", err)
+		}
+		if err != nil || !ok || s.stopping() {
+			return
+		}
+	}
+}
+
+// serverConn is a client connection, it is busy from reading a call until the
+// reply is written
+type serverConn struct {
+	server    *ThriftServer
+	transport thrift.TTransport
+
+	mu     sync.Mutex
+	busy   bool
+	closed bool
+}
+
+// beginCall marks the connection busy, a call read after Stop is rejected
+func (c *serverConn) beginCall() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.server.stopping() {
+		return errServerStopping
+	}
+	c.busy = true
+	return nil
+}
+
+func (c *serverConn) endCall() {
+	c.mu.Lock()
+	c.busy = false
+	c.mu.Unlock()
+}
+
+// closeIdle closes the connection if no call is running, this interrupts the
+// read of the next call
+func (c *serverConn) closeIdle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.busy && !c.closed {
+		c.closed = true
+		c.transport.Close()
+	}
+}
+
+// callProtocol marks the connection busy once a call header is read
+type callProtocol struct {
+	thrift.TProtocol
+	conn *serverConn
+}
+
+func (p *callProtocol) ReadMessageBegin() (name string, typeID thrift.TMessageType, seqID int32, err error) {
+	name, typeID, seqID, err = p.TProtocol.ReadMessageBegin()
+	if err != nil {
+		return
+	}
+	if err = p.conn.beginCall(); err != nil {
+		err = thrift.NewTTransportExceptionFromError(err)
+	}
+	return
+}
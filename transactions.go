@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrTransactionNotFound is returned for an unknown or finished transaction id
+var ErrTransactionNotFound = errors.New("Transaction not found")
+
+// Transaction is a client transaction on the data database that spans several
+// thrift calls. Statements of one transaction run one at a time.
+type Transaction struct {
+	id string
+	tx *sqlx.Tx
+
+	mu       sync.Mutex
+	lastUsed time.Time
+}
+
+// lock takes the transaction for one statement
+func (t *Transaction) lock() {
+	t.mu.Lock()
+	t.lastUsed = time.Now()
+}
+
+func (t *Transaction) unlock() {
+	t.lastUsed = time.Now()
+	t.mu.Unlock()
+}
+
+// idle reports whether the transaction was not used longer than timeout
+func (t *Transaction) idle(now time.Time, timeout time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return timeout > 0 && now.Sub(t.lastUsed) > timeout
+}
+
+// rollback ends the transaction, it waits for a running statement
+func (t *Transaction) rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.tx.Rollback()
+}
+
+// nextID returns a new batch or transaction id of the session
+func (s *Session) nextID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.transactionID++
+	return strconv.Itoa(s.transactionID)
+}
+
+// beginTransaction starts a transaction on the data database
+func (s *Session) beginTransaction() (*Transaction, error) {
+	tx, err := s.dbData.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transaction{id: s.nextID(), tx: tx, lastUsed: time.Now()}
+
+	s.mu.Lock()
+	s.transactions[t.id] = t
+	s.mu.Unlock()
+
+	return t, nil
+}
+
+// transaction returns the open transaction by id
+func (s *Session) transaction(id string) (*Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.transactions[id]
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+	return t, nil
+}
+
+// endTransaction removes the transaction from the session, the caller commits
+// or rolls it back
+func (s *Session) endTransaction(id string) (*Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.transactions[id]
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+	delete(s.transactions, id)
+	return t, nil
+}
+
+// rollbackTransactions rolls back transactions of the session, all of them or
+// only idle ones
+func (s *Session) rollbackTransactions(now time.Time, onlyIdle bool) {
+	s.mu.Lock()
+	list := make([]*Transaction, 0, len(s.transactions))
+	for _, t := range s.transactions {
+		list = append(list, t)
+	}
+	s.mu.Unlock()
+
+	for _, t := range list {
+		if onlyIdle && !t.idle(now, s.cfg.TxTimeout) {
+			continue
+		}
+		if _, err := s.endTransaction(t.id); err != nil {
+			continue
+		}
+		if err := t.rollback(); err != nil {
+			log.Printf("Session %s: rollback transaction %s: %v", s.id, t.id, err)
+			continue
+		}
+		log.Printf("Session %s: transaction %s rolled back", s.id, t.id)
+	}
+}